@@ -2,21 +2,25 @@ package main
 
 import (
 	"CarStore/UserService/pkg/auth"
+	"CarStore/UserService/pkg/grpcserver"
 	"CarStore/UserService/pkg/jwt"
+	"CarStore/UserService/pkg/redis"
+	"CarStore/UserService/pkg/session"
+	"CarStore/UserService/pkg/stream"
 	"context"
-	"encoding/json"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"log"
 	"net"
 	"os"
 
 	"github.com/joho/godotenv"
-	"google.golang.org/grpc"
 
 	carpetpb "CarStore/CarService/api/pb/car"
 	"CarStore/CarService/internal/handler"
 	"CarStore/CarService/internal/repository"
 	"CarStore/CarService/internal/usecase"
+	"CarStore/CarService/pkg/blob"
 	"CarStore/CarService/pkg/mongo"
 )
 
@@ -27,7 +31,6 @@ func main() {
 	// read env
 	mongoURI := os.Getenv("CAR_SERVICE_MONGO_URI")
 	dbName := os.Getenv("CAR_SERVICE_DB_NAME")
-	jwtSecret := os.Getenv("JWT_SECRET")
 	grpcPort := os.Getenv("CAR_SERVICE_PORT")
 	if grpcPort == "" {
 		grpcPort = "9090"
@@ -43,46 +46,59 @@ func main() {
 		log.Fatalf("mongo connect error: %v", err)
 	}
 	db := client.Database(dbName)
+	if err := repository.EnsureIndexes(context.Background(), db); err != nil {
+		log.Fatalf("mongo indexes: %v", err)
+	}
 
 	// wire layers
 	carRepo := repository.NewCarRepo(db)
 	carUC := usecase.NewCarUsecase(carRepo)
-	jwtSvc := jwt.NewJWTService(jwtSecret, "CarService")
+	// car images are kept on the local disk
+	mediaDir := os.Getenv("CAR_SERVICE_MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+	mediaStore, err := blob.NewLocalFS(mediaDir)
+	if err != nil {
+		log.Fatalf("media dir: %v", err)
+	}
+	mediaUC := usecase.NewMediaUsecase(carRepo, mediaStore)
+	publicKeys, err := jwt.LoadPublicKeys(os.Getenv("JWT_PUBLIC_KEYS"))
+	if err != nil || len(publicKeys) == 0 {
+		log.Fatalf("JWT_PUBLIC_KEYS must list the token verification keys: %v", err)
+	}
+	jwtSvc := jwt.NewVerifier("UserService", publicKeys...)
 
 	//nats connection
 	nc, err := nats.Connect(os.Getenv("NATS_URL"))
 	if err != nil {
 		log.Fatalf("NATS connect: %v", err)
 	}
-	_, err = nc.Subscribe("order.created", func(m *nats.Msg) {
-		var evt struct {
-			CarID    string `json:"car_id"`
-			Quantity int    `json:"quantity"`
-		}
-		if err := json.Unmarshal(m.Data, &evt); err != nil {
-			log.Printf("bad event: %v", err)
-			return
-		}
-		newStock, err := carUC.DecreaseStock(context.Background(), evt.CarID, evt.Quantity)
-		if err != nil {
-			log.Printf("decrease stock failed: %v", err)
-		} else {
-			log.Printf("stock for %s decreased by %d, now %d", evt.CarID, evt.Quantity, newStock)
-		}
-	})
+	js, err := jetstream.New(nc)
 	if err != nil {
-		log.Fatalf("NATS subscribe: %v", err)
+		log.Fatalf("JetStream: %v", err)
+	}
+	if err := stream.EnsureStreams(context.Background(), js); err != nil {
+		log.Fatalf("JetStream streams: %v", err)
+	}
+	if err := handler.NewStockEventHandler(carUC, repository.NewProcessedEventRepo(db), js).Subscribe(context.Background()); err != nil {
+		log.Fatalf("JetStream consume: %v", err)
 	}
 
 	// start gRPC server
+	// access tokens revoked by UserService are looked up in the shared Redis
+	revoked := session.NewStore(redis.NewClient(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASS"), 0))
+
 	lis, err := net.Listen("tcp", ":"+grpcPort)
 	if err != nil {
 		log.Fatalf("listen on %s failed: %v", grpcPort, err)
 	}
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(auth.UnaryAuthInterceptor(*jwtSvc)))
+	suspended := auth.NewStatusCache(revoked, auth.StatusCacheTTL)
+	grpcServer := grpcserver.New(grpcserver.Options{JWT: *jwtSvc, Revoked: revoked, Suspended: suspended})
+	grpcserver.ServeMetrics(os.Getenv("CAR_SERVICE_METRICS_ADDR"))
 
 	// register gRPC handler
-	carpetpb.RegisterCarServiceServer(grpcServer, handler.NewCarHandler(carUC))
+	carpetpb.RegisterCarServiceServer(grpcServer, handler.NewCarHandler(carUC, mediaUC))
 
 	log.Printf("gRPC CarService listening on :%s", grpcPort)
 	if err := grpcServer.Serve(lis); err != nil {
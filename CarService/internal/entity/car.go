@@ -17,5 +17,10 @@ type Car struct {
 	Gearbox        string    `json:"gearbox" bson:"gearbox"`
 	EngineType     string    `json:"engine_type" bson:"engine_type"`
 	Stock          int       `json:"stock" bson:"stock"`
+	VIN            string    `json:"vin,omitempty" bson:"vin,omitempty"` // unique among cars that have one
 	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	Version        int64     `json:"version" bson:"version"` // incremented by every change but to the gallery
+	// Media is the car's image gallery in display order. It is changed only
+	// through the media methods, never by Update.
+	Media []Media `json:"media,omitempty" bson:"media,omitempty"`
 }
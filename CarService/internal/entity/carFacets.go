@@ -0,0 +1,45 @@
+package entity
+
+// CarFacetQuery asks for facet counts over the cars matching Filter. Year
+// and price buckets start at each boundary and end at the next one; values
+// from the last boundary up fall into one open-ended bucket.
+type CarFacetQuery struct {
+	Filter          CarFilter
+	YearBoundaries  []float64
+	PriceBoundaries []float64
+}
+
+// FacetCount is the number of matching cars with a field value.
+type FacetCount struct {
+	Value string
+	Count int64
+}
+
+// RangeBucket counts the matching cars with Min <= value < Max. Max is zero
+// for the open-ended last bucket.
+type RangeBucket struct {
+	Min   float64
+	Max   float64
+	Count int64
+}
+
+// NumericRange is the smallest and largest value among the matching cars.
+type NumericRange struct {
+	Min float64
+	Max float64
+}
+
+// CarFacets summarises the cars matching a filter for a filter sidebar.
+// Value counts are ordered by count, highest first; empty buckets are
+// omitted.
+type CarFacets struct {
+	Total        int64
+	Brands       []FacetCount
+	Gearboxes    []FacetCount
+	EngineTypes  []FacetCount
+	Years        []RangeBucket
+	Prices       []RangeBucket
+	YearRange    NumericRange
+	PriceRange   NumericRange
+	MileageRange NumericRange
+}
@@ -0,0 +1,39 @@
+package entity
+
+// CarFilter narrows a catalog listing. Zero values mean "no constraint".
+type CarFilter struct {
+	Brand       string
+	Model       string
+	YearMin     int
+	YearMax     int
+	PriceMin    float64
+	PriceMax    float64
+	MileageMax  int
+	Gearbox     string
+	EngineType  string
+	InStockOnly bool
+}
+
+// Sortable catalog fields, named after their bson keys.
+const (
+	SortByCreatedAt = "created_at"
+	SortByPrice     = "price"
+	SortByYear      = "year"
+	SortByMileage   = "mileage"
+	SortByBrand     = "brand"
+)
+
+// CarListQuery is a filtered, sorted and paginated catalog request.
+type CarListQuery struct {
+	Filter    CarFilter
+	SortBy    string
+	SortDesc  bool
+	PageSize  int
+	PageToken string
+}
+
+// CarPage is one page of a catalog listing.
+type CarPage struct {
+	Cars          []*Car
+	NextPageToken string
+}
@@ -0,0 +1,23 @@
+package entity
+
+// CarSearchQuery is a free-text catalog search, optionally narrowed by a
+// filter.
+type CarSearchQuery struct {
+	Text   string
+	Filter CarFilter
+	Limit  int
+}
+
+// CarSearchHit is a search result with its relevance score; higher scores
+// match better.
+type CarSearchHit struct {
+	Car   *Car
+	Score float64
+}
+
+// CarSearchResult holds the hits of a search, best first, and the text
+// actually searched after prefix expansion and typo correction.
+type CarSearchResult struct {
+	Hits  []CarSearchHit
+	Query string
+}
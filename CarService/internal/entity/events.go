@@ -0,0 +1,40 @@
+package entity
+
+// NATS subjects of the order/stock saga.
+const (
+	SubjectOrderCreated  = "order.created"
+	SubjectStockReserved = "stock.reserved"
+	SubjectStockRejected = "stock.rejected"
+	SubjectStockReleased = "stock.released"
+)
+
+// OrderCreatedEvent is published by OrderService for every new order.
+type OrderCreatedEvent struct {
+	OrderID  string `json:"order_id"`
+	CarID    string `json:"car_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// StockReservedEvent tells OrderService that stock was taken for an order.
+type StockReservedEvent struct {
+	OrderID  string `json:"order_id"`
+	CarID    string `json:"car_id"`
+	Quantity int    `json:"quantity"`
+	NewStock int    `json:"new_stock"`
+}
+
+// StockRejectedEvent tells OrderService that an order could not be reserved.
+type StockRejectedEvent struct {
+	OrderID  string `json:"order_id"`
+	CarID    string `json:"car_id"`
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+// StockReleasedEvent is the compensation for a reservation that is no
+// longer needed; CarService puts the stock back.
+type StockReleasedEvent struct {
+	OrderID  string `json:"order_id"`
+	CarID    string `json:"car_id"`
+	Quantity int    `json:"quantity"`
+}
@@ -0,0 +1,20 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Media is an image in a car's gallery. The image and its thumbnail are
+// kept in the blob store under Key and ThumbnailKey.
+type Media struct {
+	ID           uuid.UUID `json:"id" bson:"id"`
+	ContentType  string    `json:"content_type" bson:"content_type"`
+	Key          string    `json:"-" bson:"key"`
+	ThumbnailKey string    `json:"-" bson:"thumbnail_key"`
+	Width        int       `json:"width" bson:"width"`
+	Height       int       `json:"height" bson:"height"`
+	Size         int64     `json:"size" bson:"size"`
+	CreatedAt    time.Time `json:"created_at" bson:"created_at"`
+}
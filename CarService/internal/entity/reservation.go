@@ -0,0 +1,24 @@
+package entity
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// StockReservation records a stock decrement under its idempotency key, so
+// the same reservation is never applied, or released, twice.
+type StockReservation struct {
+	Key        string     `json:"key" bson:"key"`
+	CarID      uuid.UUID  `json:"car_id" bson:"car_id"`
+	Quantity   int        `json:"quantity" bson:"quantity"`
+	Stock      int        `json:"stock" bson:"stock"` // stock left right after the decrement
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	ReleasedAt *time.Time `json:"released_at,omitempty" bson:"released_at,omitempty"`
+}
+
+// ProcessedEvent marks an event as handled by a consumer. Entries expire
+// once redelivery of the event is no longer possible.
+type ProcessedEvent struct {
+	Key         string    `json:"key" bson:"key"`
+	ProcessedAt time.Time `json:"processed_at" bson:"processed_at"`
+}
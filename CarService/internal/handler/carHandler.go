@@ -2,31 +2,32 @@ package handler
 
 import (
 	"context"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"log"
 
 	carpetpb "CarStore/CarService/api/pb/car"
 	"CarStore/CarService/internal/entity"
 	"CarStore/CarService/internal/usecase"
+	"CarStore/UserService/pkg/apperr"
 
 	"github.com/google/uuid"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type CarHandler struct {
 	carpetpb.UnimplementedCarServiceServer
-	uc *usecase.CarUsecase
+	uc    *usecase.CarUsecase
+	media *usecase.MediaUsecase
 }
 
-func NewCarHandler(uc *usecase.CarUsecase) carpetpb.CarServiceServer {
-	return &CarHandler{uc: uc}
+func NewCarHandler(uc *usecase.CarUsecase, media *usecase.MediaUsecase) carpetpb.CarServiceServer {
+	return &CarHandler{uc: uc, media: media}
 }
 
 func (h *CarHandler) CreateCar(ctx context.Context, req *carpetpb.CreateCarRequest) (*carpetpb.CreateCarResponse, error) {
 	log.Printf("CreateCar request: %+v", req)
 	if req.Car == nil {
-		return nil, status.Error(codes.InvalidArgument, "car payload is required")
+		return nil, apperr.Field("car", "required")
 	}
 	e := &entity.Car{
 		Brand:          req.Car.Brand,
@@ -39,28 +40,14 @@ func (h *CarHandler) CreateCar(ctx context.Context, req *carpetpb.CreateCarReque
 		Gearbox:        req.Car.Gearbox,
 		EngineType:     req.Car.EngineType,
 		Stock:          int(req.Car.Stock),
+		VIN:            req.Car.Vin,
 	}
 
 	if err := h.uc.Create(ctx, e); err != nil {
 		return nil, err
 	}
 
-	return &carpetpb.CreateCarResponse{
-		Car: &carpetpb.Car{
-			Id:             e.ID.String(),
-			Brand:          e.Brand,
-			Model:          e.Model,
-			Year:           int32(e.Year),
-			Price:          e.Price,
-			Description:    e.Description,
-			EngineCapacity: e.EngineCapacity,
-			Mileage:        int32(e.Mileage),
-			Gearbox:        e.Gearbox,
-			EngineType:     e.EngineType,
-			Stock:          int32(e.Stock),
-			CreatedAt:      timestamppb.New(e.CreatedAt),
-		},
-	}, nil
+	return &carpetpb.CreateCarResponse{Car: carToProto(e)}, nil
 }
 
 func (h *CarHandler) GetCar(ctx context.Context, req *carpetpb.GetCarRequest) (*carpetpb.GetCarResponse, error) {
@@ -69,28 +56,17 @@ func (h *CarHandler) GetCar(ctx context.Context, req *carpetpb.GetCarRequest) (*
 	if err != nil {
 		return nil, err
 	}
-	return &carpetpb.GetCarResponse{
-		Car: &carpetpb.Car{
-			Id:             e.ID.String(),
-			Brand:          e.Brand,
-			Model:          e.Model,
-			Year:           int32(e.Year),
-			Price:          e.Price,
-			Description:    e.Description,
-			EngineCapacity: e.EngineCapacity,
-			Mileage:        int32(e.Mileage),
-			Gearbox:        e.Gearbox,
-			EngineType:     e.EngineType,
-			CreatedAt:      timestamppb.New(e.CreatedAt),
-		},
-	}, nil
+	return &carpetpb.GetCarResponse{Car: carToProto(e)}, nil
 }
 
 func (h *CarHandler) UpdateCar(ctx context.Context, req *carpetpb.UpdateCarRequest) (*carpetpb.UpdateCarResponse, error) {
 	log.Printf("UpdateCar request: %+v", req)
+	if req.Car == nil {
+		return nil, apperr.Field("car", "required")
+	}
 	uid, err := uuid.Parse(req.Car.Id)
 	if err != nil {
-		return nil, err
+		return nil, apperr.Field("car.id", "must be a UUID")
 	}
 	e := &entity.Car{
 		ID:             uid,
@@ -103,13 +79,31 @@ func (h *CarHandler) UpdateCar(ctx context.Context, req *carpetpb.UpdateCarReque
 		Mileage:        int(req.Car.Mileage),
 		Gearbox:        req.Car.Gearbox,
 		EngineType:     req.Car.EngineType,
-		CreatedAt:      req.Car.CreatedAt.AsTime(),
+		Stock:          int(req.Car.Stock),
+		VIN:            req.Car.Vin,
+		Version:        req.Car.Version,
 	}
 
-	if err := h.uc.Update(ctx, e); err != nil {
+	updated, err := h.uc.Update(ctx, e, updateMask(req))
+	if err != nil {
 		return nil, err
 	}
-	return &carpetpb.UpdateCarResponse{Car: req.Car}, nil
+	return &carpetpb.UpdateCarResponse{Car: carToProto(updated)}, nil
+}
+
+// updateMask returns the paths of the request's field mask or, without
+// one, the fields of the car that are set, so that omitted fields are
+// left as they are.
+func updateMask(req *carpetpb.UpdateCarRequest) []string {
+	if paths := req.UpdateMask.GetPaths(); len(paths) > 0 {
+		return paths
+	}
+	var paths []string
+	req.Car.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		paths = append(paths, string(fd.Name()))
+		return true
+	})
+	return paths
 }
 
 func (h *CarHandler) DeleteCar(ctx context.Context, req *carpetpb.DeleteCarRequest) (*carpetpb.DeleteCarResponse, error) {
@@ -122,34 +116,127 @@ func (h *CarHandler) DeleteCar(ctx context.Context, req *carpetpb.DeleteCarReque
 
 func (h *CarHandler) ListCars(ctx context.Context, req *carpetpb.ListCarsRequest) (*carpetpb.ListCarsResponse, error) {
 	log.Printf("ListCars request: %+v", req)
-	es, err := h.uc.List(ctx)
+	q := entity.CarListQuery{
+		SortBy:    req.SortBy,
+		SortDesc:  req.SortDesc,
+		PageSize:  int(req.PageSize),
+		PageToken: req.PageToken,
+		Filter:    carFilter(req.Filter),
+	}
+	page, err := h.uc.ListPage(ctx, q)
 	if err != nil {
 		return nil, err
 	}
-	resp := &carpetpb.ListCarsResponse{}
-	for _, e := range es {
-		resp.Cars = append(resp.Cars, &carpetpb.Car{
-			Id:             e.ID.String(),
-			Brand:          e.Brand,
-			Model:          e.Model,
-			Year:           int32(e.Year),
-			Price:          e.Price,
-			Description:    e.Description,
-			EngineCapacity: e.EngineCapacity,
-			Mileage:        int32(e.Mileage),
-			Gearbox:        e.Gearbox,
-			EngineType:     e.EngineType,
-			Stock:          int32(e.Stock),
-			CreatedAt:      timestamppb.New(e.CreatedAt),
-		})
+	resp := &carpetpb.ListCarsResponse{NextPageToken: page.NextPageToken}
+	for _, e := range page.Cars {
+		resp.Cars = append(resp.Cars, carToProto(e))
 	}
 	return resp, nil
 }
 
-func (h *CarHandler) DecreaseStock(ctx context.Context, req *carpetpb.DecreaseStockRequest) (*carpetpb.DecreaseStockResponse, error) {
-	newStock, err := h.uc.DecreaseStock(ctx, req.CarId, int(req.Quantity))
+func (h *CarHandler) SearchCars(ctx context.Context, req *carpetpb.SearchCarsRequest) (*carpetpb.SearchCarsResponse, error) {
+	log.Printf("SearchCars request: %+v", req)
+	res, err := h.uc.Search(ctx, entity.CarSearchQuery{
+		Text:   req.Q,
+		Filter: carFilter(req.Filter),
+		Limit:  int(req.PageSize),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &carpetpb.SearchCarsResponse{Query: res.Query}
+	for _, hit := range res.Hits {
+		resp.Hits = append(resp.Hits, &carpetpb.CarSearchHit{Car: carToProto(hit.Car), Score: hit.Score})
+	}
+	return resp, nil
+}
+
+func (h *CarHandler) GetCarFacets(ctx context.Context, req *carpetpb.GetCarFacetsRequest) (*carpetpb.GetCarFacetsResponse, error) {
+	log.Printf("GetCarFacets request: %+v", req)
+	f, err := h.uc.GetFacets(ctx, carFilter(req.Filter))
+	if err != nil {
+		return nil, err
+	}
+	return &carpetpb.GetCarFacetsResponse{
+		Total:        f.Total,
+		Brands:       facetCountsToProto(f.Brands),
+		Gearboxes:    facetCountsToProto(f.Gearboxes),
+		EngineTypes:  facetCountsToProto(f.EngineTypes),
+		Years:        rangeBucketsToProto(f.Years),
+		Prices:       rangeBucketsToProto(f.Prices),
+		YearRange:    &carpetpb.NumericRange{Min: f.YearRange.Min, Max: f.YearRange.Max},
+		PriceRange:   &carpetpb.NumericRange{Min: f.PriceRange.Min, Max: f.PriceRange.Max},
+		MileageRange: &carpetpb.NumericRange{Min: f.MileageRange.Min, Max: f.MileageRange.Max},
+	}, nil
+}
+
+func (h *CarHandler) DecodeVIN(ctx context.Context, req *carpetpb.DecodeVINRequest) (*carpetpb.DecodeVINResponse, error) {
+	log.Printf("DecodeVIN request: %+v", req)
+	info, err := h.uc.DecodeVIN(req.Vin)
 	if err != nil {
 		return nil, err
 	}
-	return &carpetpb.DecreaseStockResponse{NewStock: int32(newStock)}, nil
+	return &carpetpb.DecodeVINResponse{
+		Vin:          info.VIN,
+		Wmi:          info.WMI,
+		Region:       info.Region,
+		Manufacturer: info.Manufacturer,
+		Brand:        info.Brand,
+		ModelYear:    int32(info.ModelYear),
+	}, nil
+}
+
+func facetCountsToProto(counts []entity.FacetCount) []*carpetpb.FacetCount {
+	out := make([]*carpetpb.FacetCount, 0, len(counts))
+	for _, c := range counts {
+		out = append(out, &carpetpb.FacetCount{Value: c.Value, Count: c.Count})
+	}
+	return out
+}
+
+func rangeBucketsToProto(buckets []entity.RangeBucket) []*carpetpb.RangeBucket {
+	out := make([]*carpetpb.RangeBucket, 0, len(buckets))
+	for _, b := range buckets {
+		out = append(out, &carpetpb.RangeBucket{Min: b.Min, Max: b.Max, Count: b.Count})
+	}
+	return out
+}
+
+// carFilter converts a request filter; nil means no constraint.
+func carFilter(f *carpetpb.CarFilter) entity.CarFilter {
+	if f == nil {
+		return entity.CarFilter{}
+	}
+	return entity.CarFilter{
+		Brand:       f.Brand,
+		Model:       f.Model,
+		YearMin:     int(f.YearMin),
+		YearMax:     int(f.YearMax),
+		PriceMin:    f.PriceMin,
+		PriceMax:    f.PriceMax,
+		MileageMax:  int(f.MileageMax),
+		Gearbox:     f.Gearbox,
+		EngineType:  f.EngineType,
+		InStockOnly: f.InStockOnly,
+	}
+}
+
+func carToProto(e *entity.Car) *carpetpb.Car {
+	return &carpetpb.Car{
+		Id:             e.ID.String(),
+		Brand:          e.Brand,
+		Model:          e.Model,
+		Year:           int32(e.Year),
+		Price:          e.Price,
+		Description:    e.Description,
+		EngineCapacity: e.EngineCapacity,
+		Mileage:        int32(e.Mileage),
+		Gearbox:        e.Gearbox,
+		EngineType:     e.EngineType,
+		Stock:          int32(e.Stock),
+		Vin:            e.VIN,
+		Version:        e.Version,
+		CreatedAt:      timestamppb.New(e.CreatedAt),
+		Media:          mediaListToProto(e.ID, e.Media),
+	}
 }
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	carpetpb "CarStore/CarService/api/pb/car"
+	"CarStore/CarService/internal/entity"
+	"CarStore/CarService/internal/usecase"
+	"CarStore/CarService/pkg/blob"
+	"CarStore/UserService/pkg/grpcserver"
+	"CarStore/UserService/pkg/grpcserver/grpctest"
+)
+
+// TestCarHandler_StatusCodes checks the codes clients see once the errors
+// interceptor has mapped the handler's errors.
+func TestCarHandler_StatusCodes(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	repo := &memoryCarRepo{store: map[uuid.UUID]entity.Car{id: {ID: id, Brand: "Toyota", Stock: 1, Version: 2}}}
+	h := NewCarHandler(usecase.NewCarUsecase(repo), nil)
+	code := func(_ interface{}, err error) codes.Code {
+		return status.Code(grpcserver.StatusError(ctx, err))
+	}
+
+	tests := []struct {
+		name string
+		got  codes.Code
+		want codes.Code
+	}{
+		{"GetCar", code(h.GetCar(ctx, &carpetpb.GetCarRequest{Id: id.String()})), codes.OK},
+		{"GetCar malformed id", code(h.GetCar(ctx, &carpetpb.GetCarRequest{Id: "42"})), codes.InvalidArgument},
+		{"GetCar unknown id", code(h.GetCar(ctx, &carpetpb.GetCarRequest{Id: uuid.NewString()})), codes.NotFound},
+		{"UpdateCar without car", code(h.UpdateCar(ctx, &carpetpb.UpdateCarRequest{})), codes.InvalidArgument},
+		{"UpdateCar stale version", code(h.UpdateCar(ctx, &carpetpb.UpdateCarRequest{
+			Car:        &carpetpb.Car{Id: id.String(), Brand: "Lexus", Version: 1},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"brand"}},
+		})), codes.Aborted},
+		{"DeleteCar malformed id", code(h.DeleteCar(ctx, &carpetpb.DeleteCarRequest{Id: "42"})), codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.got, tt.name)
+	}
+}
+
+// FuzzCarHandler checks that no request, however malformed, panics a
+// handler. Run with: go test -fuzz FuzzCarHandler ./CarService/internal/handler
+func FuzzCarHandler(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	id := uuid.New()
+	repo := &memoryCarRepo{store: map[uuid.UUID]entity.Car{
+		id: {ID: id, Brand: "Toyota", Model: "Corolla", Year: 2020, Price: 15000, Stock: 3},
+	}}
+	store, err := blob.NewLocalFS(f.TempDir())
+	require.NoError(f, err)
+	mediaID := uuid.New()
+	car := repo.store[id]
+	car.Media = []entity.Media{{ID: mediaID, ContentType: "image/png", Key: "cars/a.png", ThumbnailKey: "cars/a_thumb.jpeg"}}
+	repo.store[id] = car
+	h := NewCarHandler(usecase.NewCarUsecase(repo), usecase.NewMediaUsecase(repo, store))
+	desc := carpetpb.CarService_ServiceDesc
+
+	grpctest.Seed(f, desc, "GetCar", &carpetpb.GetCarRequest{Id: id.String()})
+	grpctest.Seed(f, desc, "UpdateCar", &carpetpb.UpdateCarRequest{Car: &carpetpb.Car{Id: id.String(), Brand: "Toyota", Year: 2021}})
+	grpctest.Seed(f, desc, "UpdateCar", &carpetpb.UpdateCarRequest{
+		Car:        &carpetpb.Car{Id: id.String(), Version: 1},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"brand", "*", "media.id"}},
+	})
+	grpctest.Seed(f, desc, "ListCars", &carpetpb.ListCarsRequest{PageSize: -1, Filter: &carpetpb.CarFilter{YearMin: 2030, YearMax: 2000}})
+	grpctest.Seed(f, desc, "ListCars", &carpetpb.ListCarsRequest{SortBy: "price", PageToken: "not-a-token"})
+	grpctest.Seed(f, desc, "SearchCars", &carpetpb.SearchCarsRequest{Q: "toyta corol", PageSize: 1000})
+	grpctest.Seed(f, desc, "SearchCars", &carpetpb.SearchCarsRequest{Q: "\"-\" \u00e9\u0301"})
+	grpctest.Seed(f, desc, "GetCarFacets", &carpetpb.GetCarFacetsRequest{Filter: &carpetpb.CarFilter{Brand: "Toyota"}})
+	grpctest.Seed(f, desc, "UpdateCar", &carpetpb.UpdateCarRequest{})
+	grpctest.Seed(f, desc, "ReorderCarMedia", &carpetpb.ReorderCarMediaRequest{CarId: id.String(), MediaIds: []string{mediaID.String(), mediaID.String()}})
+	grpctest.Seed(f, desc, "DecodeVIN", &carpetpb.DecodeVINRequest{Vin: "wba8e9g52gnt00000"})
+	grpctest.Seed(f, desc, "DecodeVIN", &carpetpb.DecodeVINRequest{Vin: "WBA"})
+	grpctest.Seed(f, desc, "DeleteCarMedia", &carpetpb.DeleteCarMediaRequest{CarId: id.String(), MediaId: "x"})
+
+	grpctest.FuzzUnary(f, desc, h, context.Background)
+}
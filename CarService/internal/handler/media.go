@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	carpetpb "CarStore/CarService/api/pb/car"
+	"CarStore/CarService/internal/entity"
+	"CarStore/UserService/pkg/apperr"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// mediaChunkSize is the size of the chunks GetCarMedia sends.
+const mediaChunkSize = 64 << 10
+
+func (h *CarHandler) UploadCarMedia(stream carpetpb.CarService_UploadCarMediaServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	meta := first.GetMetadata()
+	if meta == nil {
+		return apperr.Field("metadata", "must be the first message")
+	}
+	log.Printf("UploadCarMedia request: car %s", meta.CarId)
+	m, err := h.media.Upload(stream.Context(), meta.CarId, &uploadReader{stream: stream})
+	if err != nil {
+		return err
+	}
+	carID, _ := uuid.Parse(meta.CarId) // Upload found the car by this id
+	return stream.SendAndClose(mediaToProto(carID, m))
+}
+
+// uploadReader reads the chunks of an upload stream as one byte stream.
+type uploadReader struct {
+	stream carpetpb.CarService_UploadCarMediaServer
+	buf    []byte
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err // io.EOF once the client closes its side
+		}
+		chunk, ok := req.Data.(*carpetpb.UploadCarMediaRequest_Chunk)
+		if !ok {
+			return 0, apperr.Field("metadata", "must only be sent once")
+		}
+		r.buf = chunk.Chunk
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (h *CarHandler) GetCarMedia(req *carpetpb.GetCarMediaRequest, stream carpetpb.CarService_GetCarMediaServer) error {
+	r, contentType, err := h.media.Open(stream.Context(), req.CarId, req.MediaId, req.Thumbnail)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	buf := make([]byte, mediaChunkSize)
+	chunk := &carpetpb.CarMediaChunk{ContentType: contentType}
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk.ContentType = ""
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (h *CarHandler) DeleteCarMedia(ctx context.Context, req *carpetpb.DeleteCarMediaRequest) (*carpetpb.DeleteCarMediaResponse, error) {
+	log.Printf("DeleteCarMedia request: %+v", req)
+	if err := h.media.Delete(ctx, req.CarId, req.MediaId); err != nil {
+		return nil, err
+	}
+	return &carpetpb.DeleteCarMediaResponse{Success: true}, nil
+}
+
+func (h *CarHandler) ReorderCarMedia(ctx context.Context, req *carpetpb.ReorderCarMediaRequest) (*carpetpb.ReorderCarMediaResponse, error) {
+	log.Printf("ReorderCarMedia request: %+v", req)
+	media, err := h.media.Reorder(ctx, req.CarId, req.MediaIds)
+	if err != nil {
+		return nil, err
+	}
+	carID, _ := uuid.Parse(req.CarId) // Reorder found the car by this id
+	return &carpetpb.ReorderCarMediaResponse{Media: mediaListToProto(carID, media)}, nil
+}
+
+func mediaListToProto(carID uuid.UUID, media []entity.Media) []*carpetpb.CarMedia {
+	out := make([]*carpetpb.CarMedia, 0, len(media))
+	for i := range media {
+		out = append(out, mediaToProto(carID, &media[i]))
+	}
+	return out
+}
+
+// mediaToProto links the image to the gateway routes that serve it.
+func mediaToProto(carID uuid.UUID, m *entity.Media) *carpetpb.CarMedia {
+	url := fmt.Sprintf("/cars/%s/media/%s", carID, m.ID)
+	return &carpetpb.CarMedia{
+		Id:           m.ID.String(),
+		ContentType:  m.ContentType,
+		Width:        int32(m.Width),
+		Height:       int32(m.Height),
+		Size:         m.Size,
+		Url:          url,
+		ThumbnailUrl: url + "?thumbnail=true",
+		CreatedAt:    timestamppb.New(m.CreatedAt),
+	}
+}
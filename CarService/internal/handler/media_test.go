@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	carpetpb "CarStore/CarService/api/pb/car"
+	"CarStore/CarService/internal/entity"
+	"CarStore/CarService/internal/usecase"
+	"CarStore/CarService/pkg/blob"
+	"CarStore/UserService/pkg/apperr"
+)
+
+// uploadStream plays back requests to UploadCarMedia, then fails with err,
+// or io.EOF if err is nil.
+type uploadStream struct {
+	grpc.ServerStream
+	reqs []*carpetpb.UploadCarMediaRequest
+	err  error
+	resp *carpetpb.CarMedia
+}
+
+func (s *uploadStream) Context() context.Context { return context.Background() }
+
+func (s *uploadStream) Recv() (*carpetpb.UploadCarMediaRequest, error) {
+	if len(s.reqs) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	req := s.reqs[0]
+	s.reqs = s.reqs[1:]
+	return req, nil
+}
+
+func (s *uploadStream) SendAndClose(m *carpetpb.CarMedia) error {
+	s.resp = m
+	return nil
+}
+
+// mediaStream collects the chunks GetCarMedia sends.
+type mediaStream struct {
+	grpc.ServerStream
+	chunks []*carpetpb.CarMediaChunk
+}
+
+func (s *mediaStream) Context() context.Context { return context.Background() }
+
+func (s *mediaStream) Send(c *carpetpb.CarMediaChunk) error {
+	s.chunks = append(s.chunks, &carpetpb.CarMediaChunk{ContentType: c.ContentType, Data: bytes.Clone(c.Data)})
+	return nil
+}
+
+func metadataReq(carID uuid.UUID) *carpetpb.UploadCarMediaRequest {
+	return &carpetpb.UploadCarMediaRequest{Data: &carpetpb.UploadCarMediaRequest_Metadata{
+		Metadata: &carpetpb.UploadCarMediaMetadata{CarId: carID.String()},
+	}}
+}
+
+func chunkReqs(data []byte, size int) []*carpetpb.UploadCarMediaRequest {
+	var reqs []*carpetpb.UploadCarMediaRequest
+	for len(data) > 0 {
+		n := min(size, len(data))
+		reqs = append(reqs, &carpetpb.UploadCarMediaRequest{Data: &carpetpb.UploadCarMediaRequest_Chunk{Chunk: data[:n]}})
+		data = data[n:]
+	}
+	return reqs
+}
+
+func TestCarHandler_MediaStreams(t *testing.T) {
+	carID := uuid.New()
+	repo := &memoryCarRepo{store: map[uuid.UUID]entity.Car{carID: {ID: carID, Brand: "Toyota"}}}
+	store, err := blob.NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+	h := NewCarHandler(usecase.NewCarUsecase(repo), usecase.NewMediaUsecase(repo, store))
+
+	// noise does not compress, so the image spans several download chunks
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 192))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	data := buf.Bytes()
+
+	// The metadata must come first, and only once
+	err = h.UploadCarMedia(&uploadStream{reqs: chunkReqs(data, 16<<10)})
+	assert.ErrorIs(t, err, apperr.InvalidArgument, "upload without metadata")
+	err = h.UploadCarMedia(&uploadStream{reqs: append([]*carpetpb.UploadCarMediaRequest{metadataReq(carID), metadataReq(carID)}, chunkReqs(data, 16<<10)...)})
+	assert.ErrorIs(t, err, apperr.InvalidArgument, "upload with metadata twice")
+	err = h.UploadCarMedia(&uploadStream{})
+	assert.Equal(t, io.EOF, err, "empty upload")
+
+	// A client failing partway stores nothing
+	broken := status.Error(codes.Canceled, "client went away")
+	err = h.UploadCarMedia(&uploadStream{reqs: append([]*carpetpb.UploadCarMediaRequest{metadataReq(carID)}, chunkReqs(data[:len(data)/2], 16<<10)...), err: broken})
+	assert.Equal(t, codes.Canceled, status.Code(err), "broken upload")
+	assert.Empty(t, repo.store[carID].Media, "broken upload stored media")
+
+	// Chunks are reassembled, and streamed back in chunks
+	up := &uploadStream{reqs: append([]*carpetpb.UploadCarMediaRequest{metadataReq(carID)}, chunkReqs(data, 16<<10)...)}
+	require.NoError(t, h.UploadCarMedia(up))
+	assert.EqualValues(t, 256, up.resp.Width)
+	assert.Equal(t, "image/png", up.resp.ContentType)
+	down := &mediaStream{}
+	require.NoError(t, h.GetCarMedia(&carpetpb.GetCarMediaRequest{CarId: carID.String(), MediaId: up.resp.Id}, down))
+	var got []byte
+	for _, c := range down.chunks {
+		got = append(got, c.Data...)
+	}
+	require.GreaterOrEqual(t, len(down.chunks), 2, "download in chunks")
+	assert.True(t, bytes.Equal(data, got), "downloaded %d bytes, want the %d uploaded", len(got), len(data))
+	assert.Equal(t, "image/png", down.chunks[0].ContentType)
+}
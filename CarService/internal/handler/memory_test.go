@@ -0,0 +1,267 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+)
+
+// memoryCarRepo keeps cars in a map and follows the error contract of the
+// Mongo repository, so handler inputs reach the same code paths.
+type memoryCarRepo struct {
+	store        map[uuid.UUID]entity.Car
+	reservations map[string]*entity.StockReservation
+}
+
+func (m *memoryCarRepo) Create(ctx context.Context, car *entity.Car) error {
+	m.store[car.ID] = *car
+	return nil
+}
+
+func (m *memoryCarRepo) Update(ctx context.Context, car *entity.Car, fields []string) (*entity.Car, error) {
+	stored, ok := m.store[car.ID]
+	if !ok {
+		return nil, _interface.ErrCarNotFound
+	}
+	if stored.Version != car.Version {
+		return nil, _interface.ErrCarChanged
+	}
+	updated, err := applyFields(&stored, car, fields)
+	if err != nil {
+		return nil, err
+	}
+	m.store[car.ID] = *updated
+	return updated, nil
+}
+
+// applyFields copies the named fields from car to stored through their
+// bson documents, as the Mongo repository's $set and $unset do.
+func applyFields(stored, car *entity.Car, fields []string) (*entity.Car, error) {
+	doc, err := bsonDoc(stored)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := bsonDoc(car)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if v, ok := patch[f]; ok {
+			doc[f] = v
+		} else {
+			delete(doc, f)
+		}
+	}
+	doc["version"] = stored.Version + 1
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var updated entity.Car
+	return &updated, bson.Unmarshal(raw, &updated)
+}
+
+func bsonDoc(car *entity.Car) (bson.M, error) {
+	raw, err := bson.Marshal(car)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	return doc, bson.Unmarshal(raw, &doc)
+}
+
+func (m *memoryCarRepo) GetByID(ctx context.Context, id string) (*entity.Car, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, _interface.ErrInvalidCarID
+	}
+	car, ok := m.store[uid]
+	if !ok {
+		return nil, _interface.ErrCarNotFound
+	}
+	return &car, nil
+}
+
+func (m *memoryCarRepo) Delete(ctx context.Context, id string) error {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return _interface.ErrInvalidCarID
+	}
+	if _, ok := m.store[uid]; !ok {
+		return _interface.ErrCarNotFound
+	}
+	delete(m.store, uid)
+	return nil
+}
+
+func (m *memoryCarRepo) List(ctx context.Context) ([]*entity.Car, error) {
+	var list []*entity.Car
+	for _, c := range m.store {
+		c := c
+		list = append(list, &c)
+	}
+	return list, nil
+}
+
+func (m *memoryCarRepo) ListPage(ctx context.Context, q entity.CarListQuery) (*entity.CarPage, error) {
+	list, _ := m.List(ctx)
+	if len(list) > q.PageSize {
+		list = list[:q.PageSize]
+	}
+	return &entity.CarPage{Cars: list}, nil
+}
+
+// Search matches every car, scored by occurrences of the query words.
+func (m *memoryCarRepo) Search(ctx context.Context, q entity.CarSearchQuery) ([]entity.CarSearchHit, error) {
+	var hits []entity.CarSearchHit
+	for _, c := range m.store {
+		c := c
+		text := strings.ToLower(c.Brand + " " + c.Model + " " + c.Description)
+		score := 0.0
+		for _, w := range strings.Fields(q.Text) {
+			score += float64(strings.Count(text, w))
+		}
+		hits = append(hits, entity.CarSearchHit{Car: &c, Score: score})
+	}
+	if len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+func (m *memoryCarRepo) SearchTerms(ctx context.Context) ([]string, error) {
+	var terms []string
+	for _, c := range m.store {
+		terms = append(terms, c.Brand, c.Model, c.Gearbox, c.EngineType)
+	}
+	return terms, nil
+}
+
+// GetFacets counts brands only.
+func (m *memoryCarRepo) GetFacets(ctx context.Context, q entity.CarFacetQuery) (*entity.CarFacets, error) {
+	f := &entity.CarFacets{Total: int64(len(m.store))}
+	for _, c := range m.store {
+		f.Brands = append(f.Brands, entity.FacetCount{Value: c.Brand, Count: 1})
+	}
+	return f, nil
+}
+
+func (m *memoryCarRepo) AddMedia(ctx context.Context, carID uuid.UUID, media entity.Media, max int) error {
+	car, ok := m.store[carID]
+	if !ok {
+		return _interface.ErrCarNotFound
+	}
+	if len(car.Media) >= max {
+		return _interface.ErrGalleryFull
+	}
+	car.Media = append(car.Media, media)
+	m.store[carID] = car
+	return nil
+}
+
+func (m *memoryCarRepo) ReplaceMedia(ctx context.Context, carID uuid.UUID, old, media []entity.Media) error {
+	car, ok := m.store[carID]
+	if !ok {
+		return _interface.ErrCarNotFound
+	}
+	if !slices.EqualFunc(car.Media, old, func(a, b entity.Media) bool { return a.ID == b.ID }) {
+		return _interface.ErrMediaChanged
+	}
+	car.Media = media
+	m.store[carID] = car
+	return nil
+}
+
+func (m *memoryCarRepo) DecreaseStock(ctx context.Context, id uuid.UUID, qty int, idempotencyKey string) (int, error) {
+	if r, ok := m.reservations[idempotencyKey]; ok {
+		return r.Stock, nil
+	}
+	car, ok := m.store[id]
+	if !ok {
+		return 0, _interface.ErrCarNotFound
+	}
+	if car.Stock < qty {
+		return 0, _interface.ErrInsufficientStock
+	}
+	car.Stock -= qty
+	m.store[id] = car
+	if m.reservations == nil {
+		m.reservations = make(map[string]*entity.StockReservation)
+	}
+	m.reservations[idempotencyKey] = &entity.StockReservation{Key: idempotencyKey, CarID: id, Quantity: qty, Stock: car.Stock}
+	return car.Stock, nil
+}
+
+func (m *memoryCarRepo) ReleaseStock(ctx context.Context, idempotencyKey string) (int, error) {
+	r, ok := m.reservations[idempotencyKey]
+	if !ok || r.ReleasedAt != nil {
+		return 0, _interface.ErrReservationNotFound
+	}
+	car, ok := m.store[r.CarID]
+	if !ok {
+		return 0, _interface.ErrCarNotFound
+	}
+	now := time.Now()
+	r.ReleasedAt = &now
+	car.Stock += r.Quantity
+	m.store[r.CarID] = car
+	return car.Stock, nil
+}
+
+// memoryProcessed implements ProcessedEventRepo in memory.
+type memoryProcessed map[string]bool
+
+func (m memoryProcessed) IsProcessed(ctx context.Context, key string) (bool, error) {
+	return m[key], nil
+}
+
+func (m memoryProcessed) MarkProcessed(ctx context.Context, key string) error {
+	m[key] = true
+	return nil
+}
+
+// published is a message sent through recordingJetStream.
+type published struct {
+	subject string
+	data    []byte
+}
+
+// recordingJetStream keeps published messages instead of sending them.
+// Other JetStream methods are not used by the handler and panic.
+type recordingJetStream struct {
+	jetstream.JetStream
+	sent []published
+}
+
+func (r *recordingJetStream) Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	r.sent = append(r.sent, published{subject: subject, data: data})
+	return &jetstream.PubAck{}, nil
+}
+
+// eventMsg is a delivered event; only its subject and data are read.
+type eventMsg struct {
+	jetstream.Msg
+	subject string
+	data    []byte
+}
+
+func (m eventMsg) Subject() string { return m.subject }
+func (m eventMsg) Data() []byte    { return m.data }
+
+func newEventMsg(t *testing.T, subject string, v interface{}) eventMsg {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return eventMsg{subject: subject, data: data}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+	"CarStore/CarService/internal/usecase"
+	"CarStore/UserService/pkg/stream"
+)
+
+// StockEventHandler is CarService's side of the order saga: it reserves
+// stock for new orders, answers with stock.reserved or stock.rejected, and
+// puts stock back when a reservation is released. Reservations and releases
+// are keyed by order id, so each is applied once however often its event is
+// delivered.
+type StockEventHandler struct {
+	uc        *usecase.CarUsecase
+	processed _interface.ProcessedEventRepo
+	js        jetstream.JetStream
+}
+
+func NewStockEventHandler(uc *usecase.CarUsecase, processed _interface.ProcessedEventRepo, js jetstream.JetStream) *StockEventHandler {
+	return &StockEventHandler{uc: uc, processed: processed, js: js}
+}
+
+// Subscribe starts the durable saga consumers; they stop when ctx is done.
+func (h *StockEventHandler) Subscribe(ctx context.Context) error {
+	if err := stream.Consume(ctx, h.js, stream.Consumer{
+		Stream:  stream.StreamOrders,
+		Durable: "car-service-order-created",
+		Subject: entity.SubjectOrderCreated,
+	}, h.onOrderCreated); err != nil {
+		return err
+	}
+	return stream.Consume(ctx, h.js, stream.Consumer{
+		Stream:  stream.StreamStock,
+		Durable: "car-service-stock-released",
+		Subject: entity.SubjectStockReleased,
+	}, h.onStockReleased)
+}
+
+func (h *StockEventHandler) onOrderCreated(ctx context.Context, m jetstream.Msg) error {
+	var evt entity.OrderCreatedEvent
+	if err := json.Unmarshal(m.Data(), &evt); err != nil {
+		return stream.Permanent(err)
+	}
+	return h.once(ctx, m.Subject()+":"+evt.OrderID, func() error {
+		return h.reserve(ctx, evt)
+	})
+}
+
+// reserve decrements stock keyed by the order id, so a redelivery racing
+// the first delivery still cannot apply the reservation twice.
+func (h *StockEventHandler) reserve(ctx context.Context, evt entity.OrderCreatedEvent) error {
+	newStock, err := h.uc.DecreaseStock(ctx, evt.CarID, evt.Quantity, evt.OrderID)
+	if errors.Is(err, _interface.ErrInsufficientStock) || errors.Is(err, _interface.ErrCarNotFound) {
+		log.Printf("stock reservation for order %s rejected: %v", evt.OrderID, err)
+		return stream.Publish(ctx, h.js, entity.SubjectStockRejected, entity.SubjectStockRejected+":"+evt.OrderID, entity.StockRejectedEvent{
+			OrderID:  evt.OrderID,
+			CarID:    evt.CarID,
+			Quantity: evt.Quantity,
+			Reason:   "insufficient stock or unknown car",
+		})
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("stock for %s decreased by %d, now %d", evt.CarID, evt.Quantity, newStock)
+	return stream.Publish(ctx, h.js, entity.SubjectStockReserved, entity.SubjectStockReserved+":"+evt.OrderID, entity.StockReservedEvent{
+		OrderID:  evt.OrderID,
+		CarID:    evt.CarID,
+		Quantity: evt.Quantity,
+		NewStock: newStock,
+	})
+}
+
+func (h *StockEventHandler) onStockReleased(ctx context.Context, m jetstream.Msg) error {
+	var evt entity.StockReleasedEvent
+	if err := json.Unmarshal(m.Data(), &evt); err != nil {
+		return stream.Permanent(err)
+	}
+	newStock, err := h.uc.ReleaseStock(ctx, evt.OrderID)
+	if errors.Is(err, _interface.ErrReservationNotFound) {
+		log.Printf("ignoring stock release for order %s: no reservation left to release", evt.OrderID)
+		return nil
+	}
+	if errors.Is(err, _interface.ErrCarNotFound) {
+		return stream.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("stock for %s released by %d, now %d", evt.CarID, evt.Quantity, newStock)
+	return nil
+}
+
+// once runs handle unless key was already processed, and records key after
+// handle succeeds. It only saves redoing work: deliveries racing past the
+// check both run handle, which must therefore be idempotent itself.
+func (h *StockEventHandler) once(ctx context.Context, key string, handle func() error) error {
+	done, err := h.processed.IsProcessed(ctx, key)
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Printf("skipping already processed event %s", key)
+		return nil
+	}
+	if err := handle(); err != nil {
+		return err
+	}
+	return h.processed.MarkProcessed(ctx, key)
+}
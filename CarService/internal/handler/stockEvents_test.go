@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"CarStore/CarService/internal/entity"
+	"CarStore/CarService/internal/usecase"
+)
+
+// TestStockEventHandler covers CarService's side of the order saga with the
+// real handler and usecase; OrderService's tests cover the other side
+// against the same events.
+func TestStockEventHandler(t *testing.T) {
+	ctx := context.Background()
+	carID := uuid.New()
+	repo := &memoryCarRepo{store: map[uuid.UUID]entity.Car{carID: {ID: carID, Brand: "Toyota", Stock: 1}}}
+	js := &recordingJetStream{}
+	h := NewStockEventHandler(usecase.NewCarUsecase(repo), memoryProcessed{}, js)
+	stock := func() int { return repo.store[carID].Stock }
+	lastSent := func(subject string) {
+		t.Helper()
+		require.NotEmpty(t, js.sent)
+		require.Equal(t, subject, js.sent[len(js.sent)-1].subject)
+	}
+
+	// The first order takes the only car
+	first := newEventMsg(t, entity.SubjectOrderCreated, entity.OrderCreatedEvent{OrderID: "o1", CarID: carID.String(), Quantity: 1})
+	require.NoError(t, h.onOrderCreated(ctx, first))
+	lastSent(entity.SubjectStockReserved)
+	var reserved entity.StockReservedEvent
+	require.NoError(t, json.Unmarshal(js.sent[0].data, &reserved))
+	assert.Equal(t, "o1", reserved.OrderID)
+	assert.Zero(t, reserved.NewStock)
+
+	// A redelivery is acked without reserving or answering again
+	require.NoError(t, h.onOrderCreated(ctx, first))
+	assert.Len(t, js.sent, 1, "redelivery answered again")
+	assert.Zero(t, stock(), "redelivery reserved again")
+
+	// The second order finds no stock
+	second := newEventMsg(t, entity.SubjectOrderCreated, entity.OrderCreatedEvent{OrderID: "o2", CarID: carID.String(), Quantity: 1})
+	require.NoError(t, h.onOrderCreated(ctx, second))
+	lastSent(entity.SubjectStockRejected)
+
+	// Releasing the first reservation puts the car back, once
+	released := newEventMsg(t, entity.SubjectStockReleased, entity.StockReleasedEvent{OrderID: "o1", CarID: carID.String(), Quantity: 1})
+	for range 2 {
+		require.NoError(t, h.onStockReleased(ctx, released))
+	}
+	assert.Equal(t, 1, stock())
+
+	// Releases of orders that reserved nothing are acked and ignored
+	rejected := newEventMsg(t, entity.SubjectStockReleased, entity.StockReleasedEvent{OrderID: "o2", CarID: carID.String(), Quantity: 1})
+	require.NoError(t, h.onStockReleased(ctx, rejected))
+	assert.Equal(t, 1, stock(), "unreserved release")
+
+	// Undecodable events are dead-lettered
+	assert.Error(t, h.onOrderCreated(ctx, eventMsg{subject: entity.SubjectOrderCreated, data: []byte("{")}), "malformed event accepted")
+}
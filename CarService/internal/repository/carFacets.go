@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"CarStore/CarService/internal/entity"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// otherBucket is the id $bucket gives values beyond the last boundary.
+const otherBucket = "other"
+
+type facetValue struct {
+	Value string `bson:"_id"`
+	Count int64  `bson:"count"`
+}
+
+type facetBucket struct {
+	// Lower boundary of the bucket, or otherBucket.
+	ID    interface{} `bson:"_id"`
+	Count int64       `bson:"count"`
+}
+
+type facetStats struct {
+	Total      int64   `bson:"total"`
+	YearMin    float64 `bson:"year_min"`
+	YearMax    float64 `bson:"year_max"`
+	PriceMin   float64 `bson:"price_min"`
+	PriceMax   float64 `bson:"price_max"`
+	MileageMin float64 `bson:"mileage_min"`
+	MileageMax float64 `bson:"mileage_max"`
+}
+
+// facetResult is the single document produced by the $facet stage.
+type facetResult struct {
+	Brands      []facetValue  `bson:"brands"`
+	Gearboxes   []facetValue  `bson:"gearboxes"`
+	EngineTypes []facetValue  `bson:"engine_types"`
+	Years       []facetBucket `bson:"years"`
+	Prices      []facetBucket `bson:"prices"`
+	// Empty when nothing matches.
+	Stats []facetStats `bson:"stats"`
+}
+
+// GetFacets computes every facet in one aggregation: the catalog filter
+// selects the cars once and $facet runs a sub-pipeline per facet over them.
+func (c carRepo) GetFacets(ctx context.Context, q entity.CarFacetQuery) (*entity.CarFacets, error) {
+	pipeline := bson.A{
+		bson.M{"$match": carFilterQuery(q.Filter)},
+		bson.M{"$facet": bson.M{
+			"brands":       bson.A{bson.M{"$sortByCount": "$brand"}},
+			"gearboxes":    bson.A{bson.M{"$sortByCount": "$gearbox"}},
+			"engine_types": bson.A{bson.M{"$sortByCount": "$engine_type"}},
+			"years":        bucketStage("$year", q.YearBoundaries),
+			"prices":       bucketStage("$price", q.PriceBoundaries),
+			"stats": bson.A{bson.M{"$group": bson.M{
+				"_id":         nil,
+				"total":       bson.M{"$sum": 1},
+				"year_min":    bson.M{"$min": "$year"},
+				"year_max":    bson.M{"$max": "$year"},
+				"price_min":   bson.M{"$min": "$price"},
+				"price_max":   bson.M{"$max": "$price"},
+				"mileage_min": bson.M{"$min": "$mileage"},
+				"mileage_max": bson.M{"$max": "$mileage"},
+			}}},
+		}},
+	}
+	cursor, err := c.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var res facetResult
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&res); err != nil {
+			return nil, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return facetsFromResult(res, q), nil
+}
+
+// bucketStage counts the values of field per boundary range. $bucket needs
+// at least two boundaries, so with fewer every value lands in otherBucket.
+func bucketStage(field string, boundaries []float64) bson.A {
+	if len(boundaries) < 2 {
+		return bson.A{bson.M{"$group": bson.M{"_id": otherBucket, "count": bson.M{"$sum": 1}}}}
+	}
+	return bson.A{bson.M{"$bucket": bson.M{
+		"groupBy":    field,
+		"boundaries": boundaries,
+		"default":    otherBucket,
+		"output":     bson.M{"count": bson.M{"$sum": 1}},
+	}}}
+}
+
+func facetsFromResult(res facetResult, q entity.CarFacetQuery) *entity.CarFacets {
+	f := &entity.CarFacets{
+		Brands:      facetCounts(res.Brands),
+		Gearboxes:   facetCounts(res.Gearboxes),
+		EngineTypes: facetCounts(res.EngineTypes),
+		Years:       rangeBuckets(res.Years, q.YearBoundaries),
+		Prices:      rangeBuckets(res.Prices, q.PriceBoundaries),
+	}
+	if len(res.Stats) > 0 {
+		s := res.Stats[0]
+		f.Total = s.Total
+		f.YearRange = entity.NumericRange{Min: s.YearMin, Max: s.YearMax}
+		f.PriceRange = entity.NumericRange{Min: s.PriceMin, Max: s.PriceMax}
+		f.MileageRange = entity.NumericRange{Min: s.MileageMin, Max: s.MileageMax}
+	}
+	return f
+}
+
+// facetCounts drops the cars that leave the field empty.
+func facetCounts(values []facetValue) []entity.FacetCount {
+	var out []entity.FacetCount
+	for _, v := range values {
+		if v.Value != "" {
+			out = append(out, entity.FacetCount{Value: v.Value, Count: v.Count})
+		}
+	}
+	return out
+}
+
+// rangeBuckets maps $bucket output to ranges. A bucket's id is its lower
+// boundary; the default bucket holds values from the last boundary up, as
+// values below the first boundary (zero for years and prices) do not occur.
+func rangeBuckets(buckets []facetBucket, boundaries []float64) []entity.RangeBucket {
+	var out []entity.RangeBucket
+	for _, b := range buckets {
+		lower, ok := bucketLower(b.ID)
+		if !ok {
+			var last float64
+			if len(boundaries) > 0 {
+				last = boundaries[len(boundaries)-1]
+			}
+			out = append(out, entity.RangeBucket{Min: last, Count: b.Count})
+			continue
+		}
+		r := entity.RangeBucket{Min: lower, Count: b.Count}
+		for i := 0; i+1 < len(boundaries); i++ {
+			if boundaries[i] == lower {
+				r.Max = boundaries[i+1]
+				break
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func bucketLower(id interface{}) (float64, bool) {
+	switch v := id.(type) {
+	case float64:
+		return v, true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"CarStore/CarService/internal/entity"
+)
+
+func TestFacetsFromResult(t *testing.T) {
+	q := entity.CarFacetQuery{
+		YearBoundaries:  []float64{0, 2000, 2010},
+		PriceBoundaries: []float64{0, 10000},
+	}
+	res := facetResult{
+		Brands: []facetValue{{Value: "BMW", Count: 3}, {Value: "", Count: 1}, {Value: "Audi", Count: 2}},
+		// $bucket ids keep the type of the boundaries; years may be ints
+		Years:  []facetBucket{{ID: int32(2000), Count: 4}, {ID: otherBucket, Count: 2}},
+		Prices: []facetBucket{{ID: float64(0), Count: 5}, {ID: otherBucket, Count: 1}},
+		Stats:  []facetStats{{Total: 6, YearMin: 2001, YearMax: 2021, PriceMin: 900, PriceMax: 45000}},
+	}
+
+	f := facetsFromResult(res, q)
+	assert.Equal(t, int64(6), f.Total)
+	assert.Equal(t, []entity.FacetCount{{Value: "BMW", Count: 3}, {Value: "Audi", Count: 2}}, f.Brands)
+	assert.Equal(t, []entity.RangeBucket{{Min: 2000, Max: 2010, Count: 4}, {Min: 2010, Count: 2}}, f.Years)
+	assert.Equal(t, []entity.RangeBucket{{Min: 0, Max: 10000, Count: 5}, {Min: 10000, Count: 1}}, f.Prices)
+	assert.Equal(t, entity.NumericRange{Min: 2001, Max: 2021}, f.YearRange)
+
+	// Nothing matched
+	empty := facetsFromResult(facetResult{}, q)
+	assert.Zero(t, empty.Total)
+	assert.Empty(t, empty.Years)
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// AddMedia pushes m only while the gallery has fewer than max items, so
+// concurrent uploads cannot overfill it.
+func (c carRepo) AddMedia(ctx context.Context, carID uuid.UUID, m entity.Media, max int) error {
+	res, err := c.coll.UpdateOne(ctx,
+		bson.M{"id": carID, fmt.Sprintf("media.%d", max-1): bson.M{"$exists": false}},
+		bson.M{"$push": bson.M{"media": m}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return c.missingOr(ctx, carID, _interface.ErrGalleryFull)
+	}
+	return nil
+}
+
+// ReplaceMedia matches the stored gallery against old as a whole, which
+// makes removing and reordering items a compare-and-swap.
+func (c carRepo) ReplaceMedia(ctx context.Context, carID uuid.UUID, old, media []entity.Media) error {
+	filter := bson.M{"id": carID, "media": old}
+	if len(old) == 0 {
+		// an empty gallery is stored as a missing field
+		filter["media"] = bson.M{"$in": bson.A{nil, bson.A{}}}
+	}
+	update := bson.M{"$set": bson.M{"media": media}}
+	if len(media) == 0 {
+		update = bson.M{"$unset": bson.M{"media": ""}}
+	}
+	res, err := c.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return c.missingOr(ctx, carID, _interface.ErrMediaChanged)
+	}
+	return nil
+}
+
+// missingOr explains a conditional update that matched nothing: either the
+// car does not exist or its condition failed with err.
+func (c carRepo) missingOr(ctx context.Context, carID uuid.UUID, err error) error {
+	n, cerr := c.coll.CountDocuments(ctx, bson.M{"id": carID})
+	if cerr != nil {
+		return cerr
+	}
+	if n == 0 {
+		return _interface.ErrCarNotFound
+	}
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"CarStore/CarService/internal/entity"
 	_interface "CarStore/CarService/internal/repository/interface"
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -11,12 +12,27 @@ import (
 	"time"
 )
 
+const reservationsCollection = "stock_reservations"
+
+// reservationTTL is how long a reservation is remembered once released.
+// Until then it is kept, however late the release comes. It outlives
+// processedEventTTL, so redelivered order events never reach an expired key.
+const reservationTTL = 30 * 24 * time.Hour
+
 type carRepo struct {
-	coll *mongo.Collection
+	client       *mongo.Client
+	coll         *mongo.Collection
+	reservations *mongo.Collection
 }
 
+// NewCarRepo returns a Mongo-backed car repository. Stock reservations run
+// in a transaction, so the deployment must be a replica set.
 func NewCarRepo(db *mongo.Database) _interface.CarRepo {
-	return &carRepo{coll: db.Collection("cars")}
+	return &carRepo{
+		client:       db.Client(),
+		coll:         db.Collection("cars"),
+		reservations: db.Collection(reservationsCollection),
+	}
 }
 
 func (c carRepo) Create(ctx context.Context, car *entity.Car) error {
@@ -24,26 +40,89 @@ func (c carRepo) Create(ctx context.Context, car *entity.Car) error {
 		car.ID = uuid.New()
 	}
 	car.CreatedAt = time.Now().UTC()
+	car.Version = 1
 	_, err := c.coll.InsertOne(ctx, car)
+	if mongo.IsDuplicateKeyError(err) {
+		return _interface.ErrVINTaken // ids are random, so only the VIN can clash
+	}
 	return err
 }
 
-func (c carRepo) Update(ctx context.Context, car *entity.Car) error {
-	_, err := c.coll.ReplaceOne(ctx, bson.M{"id": car.ID}, car)
-	return err
+// Update sets the named fields from car as a compare-and-swap on the
+// version, which it increments. Fields car omits from its document, such
+// as a cleared VIN, are removed.
+func (c carRepo) Update(ctx context.Context, car *entity.Car, fields []string) (*entity.Car, error) {
+	raw, err := bson.Marshal(car)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	set, unset := bson.M{}, bson.M{}
+	for _, f := range fields {
+		if v, ok := doc[f]; ok {
+			set[f] = v
+		} else {
+			unset[f] = ""
+		}
+	}
+	update := bson.M{"$inc": bson.M{"version": 1}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	filter := bson.M{"id": car.ID, "version": car.Version}
+	if car.Version == 0 {
+		// stored before cars were versioned
+		filter["version"] = bson.M{"$exists": false}
+	}
+	var updated entity.Car
+	err = c.coll.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, c.missingOr(ctx, car.ID, _interface.ErrCarChanged)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, _interface.ErrVINTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
 }
 
 func (c carRepo) GetByID(ctx context.Context, id string) (*entity.Car, error) {
 	var car entity.Car
-	uid, _ := uuid.Parse(id)
-	err := c.coll.FindOne(ctx, bson.M{"id": uid}).Decode(&car)
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, _interface.ErrInvalidCarID
+	}
+	err = c.coll.FindOne(ctx, bson.M{"id": uid}).Decode(&car)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, _interface.ErrCarNotFound
+	}
 	return &car, err
 }
 
 func (c carRepo) Delete(ctx context.Context, id string) error {
-	uid, _ := uuid.Parse(id)
-	_, err := c.coll.DeleteOne(ctx, bson.M{"id": uid})
-	return err
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return _interface.ErrInvalidCarID
+	}
+	res, err := c.coll.DeleteOne(ctx, bson.M{"id": uid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return _interface.ErrCarNotFound
+	}
+	return nil
 }
 
 func (c carRepo) List(ctx context.Context) ([]*entity.Car, error) {
@@ -65,15 +144,208 @@ func (c carRepo) List(ctx context.Context) ([]*entity.Car, error) {
 	return cars, nil
 }
 
-func (c carRepo) DecreaseStock(ctx context.Context, id uuid.UUID, qty int) (int, error) {
-	res := c.coll.FindOneAndUpdate(ctx,
-		bson.M{"id": id, "stock": bson.M{"$gte": qty}},
-		bson.M{"$inc": bson.M{"stock": -qty}},
-		options.FindOneAndUpdate().SetReturnDocument(options.After),
-	)
-	var updated entity.Car
-	if err := res.Decode(&updated); err != nil {
+// DecreaseStock applies a reservation at most once per idempotencyKey. The
+// decrement and the reservation record are written in one transaction; a
+// repeated key returns the stock recorded by the first reservation. Like
+// ReleaseStock it bumps the version, so that an edit based on the old
+// stock fails rather than undoing the reservation.
+func (c carRepo) DecreaseStock(ctx context.Context, id uuid.UUID, qty int, idempotencyKey string) (int, error) {
+	session, err := c.client.StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	stock, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var prev entity.StockReservation
+		err := c.reservations.FindOne(sc, bson.M{"key": idempotencyKey}).Decode(&prev)
+		if err == nil {
+			return prev.Stock, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err
+		}
+
+		var updated entity.Car
+		err = c.coll.FindOneAndUpdate(sc,
+			bson.M{"id": id, "stock": bson.M{"$gte": qty}},
+			bson.M{"$inc": bson.M{"stock": -qty, "version": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updated)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, _interface.ErrInsufficientStock
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = c.reservations.InsertOne(sc, entity.StockReservation{
+			Key:       idempotencyKey,
+			CarID:     id,
+			Quantity:  qty,
+			Stock:     updated.Stock,
+			CreatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return updated.Stock, nil
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent delivery committed the same reservation first.
+		var prev entity.StockReservation
+		if err := c.reservations.FindOne(ctx, bson.M{"key": idempotencyKey}).Decode(&prev); err != nil {
+			return 0, err
+		}
+		return prev.Stock, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return stock.(int), nil
+}
+
+// ReleaseStock marks the reservation released and puts its quantity back in
+// one transaction. Only an unreleased reservation matches, so a redelivered
+// or concurrent release finds none and changes nothing.
+func (c carRepo) ReleaseStock(ctx context.Context, idempotencyKey string) (int, error) {
+	session, err := c.client.StartSession()
+	if err != nil {
 		return 0, err
 	}
-	return updated.Stock, nil
+	defer session.EndSession(ctx)
+
+	stock, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var res entity.StockReservation
+		err := c.reservations.FindOneAndUpdate(sc,
+			bson.M{"key": idempotencyKey, "released_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"released_at": time.Now().UTC()}},
+		).Decode(&res)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, _interface.ErrReservationNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var updated entity.Car
+		err = c.coll.FindOneAndUpdate(sc,
+			bson.M{"id": res.CarID},
+			bson.M{"$inc": bson.M{"stock": res.Quantity, "version": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updated)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, _interface.ErrCarNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return updated.Stock, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return stock.(int), nil
+}
+
+func (c carRepo) ListPage(ctx context.Context, q entity.CarListQuery) (*entity.CarPage, error) {
+	dir := 1
+	if q.SortDesc {
+		dir = -1
+	}
+
+	query := carFilterQuery(q.Filter)
+	if q.PageToken != "" {
+		cur, err := decodePageToken(q.PageToken, q.SortBy, q.SortDesc)
+		if err != nil {
+			return nil, err
+		}
+		cmp := "$gt"
+		if q.SortDesc {
+			cmp = "$lt"
+		}
+		query = bson.M{"$and": bson.A{query, bson.M{"$or": bson.A{
+			bson.M{q.SortBy: bson.M{cmp: cur.value}},
+			bson.M{q.SortBy: cur.value, "id": bson.M{cmp: cur.id}},
+		}}}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: q.SortBy, Value: dir}, {Key: "id", Value: dir}}).
+		SetLimit(int64(q.PageSize + 1))
+	cursor, err := c.coll.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var cars []*entity.Car
+	for cursor.Next(ctx) {
+		var c entity.Car
+		if err := cursor.Decode(&c); err != nil {
+			return nil, err
+		}
+		cars = append(cars, &c)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &entity.CarPage{Cars: cars}
+	if len(cars) > q.PageSize {
+		page.Cars = cars[:q.PageSize]
+		last := page.Cars[len(page.Cars)-1]
+		page.NextPageToken, err = encodePageToken(last, q.SortBy, q.SortDesc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// carFilterQuery translates a CarFilter into a Mongo query document.
+func carFilterQuery(f entity.CarFilter) bson.M {
+	query := bson.M{}
+	if f.Brand != "" {
+		query["brand"] = f.Brand
+	}
+	if f.Model != "" {
+		query["model"] = f.Model
+	}
+	if f.Gearbox != "" {
+		query["gearbox"] = f.Gearbox
+	}
+	if f.EngineType != "" {
+		query["engine_type"] = f.EngineType
+	}
+
+	year := bson.M{}
+	if f.YearMin > 0 {
+		year["$gte"] = f.YearMin
+	}
+	if f.YearMax > 0 {
+		year["$lte"] = f.YearMax
+	}
+	if len(year) > 0 {
+		query["year"] = year
+	}
+
+	price := bson.M{}
+	if f.PriceMin > 0 {
+		price["$gte"] = f.PriceMin
+	}
+	if f.PriceMax > 0 {
+		price["$lte"] = f.PriceMax
+	}
+	if len(price) > 0 {
+		query["price"] = price
+	}
+
+	if f.MileageMax > 0 {
+		query["mileage"] = bson.M{"$lte": f.MileageMax}
+	}
+	if f.InStockOnly {
+		query["stock"] = bson.M{"$gt": 0}
+	}
+	return query
 }
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"CarStore/CarService/internal/entity"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A collection has at most one text index, so it has a fixed name.
+const textIndexName = "cars_text"
+
+// textIndexWeights ranks a match in the brand or model above one in the
+// specification fields, and those above a mention in the description.
+var textIndexWeights = bson.D{
+	{Key: "brand", Value: 10},
+	{Key: "model", Value: 10},
+	{Key: "engine_type", Value: 5},
+	{Key: "gearbox", Value: 5},
+	{Key: "description", Value: 1},
+}
+
+// searchTermFields are the text-indexed fields weighted above the
+// description. Their short values make up the search vocabulary, so a
+// correction never suggests a word the index cannot match.
+var searchTermFields = termFields(textIndexWeights)
+
+func termFields(weights bson.D) []string {
+	var fields []string
+	for _, w := range weights {
+		if w.Value.(int) > 1 {
+			fields = append(fields, w.Key)
+		}
+	}
+	return fields
+}
+
+// scoredCar decodes a car together with its text score.
+type scoredCar struct {
+	entity.Car `bson:",inline"`
+	Score      float64 `bson:"score"`
+}
+
+func (c carRepo) Search(ctx context.Context, q entity.CarSearchQuery) ([]entity.CarSearchHit, error) {
+	query := carFilterQuery(q.Filter)
+	query["$text"] = bson.M{"$search": q.Text}
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "id", Value: 1}}).
+		SetLimit(int64(q.Limit))
+	cursor, err := c.coll.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var hits []entity.CarSearchHit
+	for cursor.Next(ctx) {
+		var sc scoredCar
+		if err := cursor.Decode(&sc); err != nil {
+			return nil, err
+		}
+		hits = append(hits, entity.CarSearchHit{Car: &sc.Car, Score: sc.Score})
+	}
+	return hits, cursor.Err()
+}
+
+func (c carRepo) SearchTerms(ctx context.Context) ([]string, error) {
+	var terms []string
+	for _, field := range searchTermFields {
+		values, err := c.coll.Distinct(ctx, field, bson.D{})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if s, ok := v.(string); ok && s != "" {
+				terms = append(terms, s)
+			}
+		}
+	}
+	return terms, nil
+}
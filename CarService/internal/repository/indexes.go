@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes backing catalog lookups, filters,
+// keyset pagination and full-text search, and the unique keys that make
+// stock handling idempotent, which expire once redeliveries are over. It is
+// safe to call on every startup.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("cars").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "brand", Value: 1}, {Key: "model", Value: 1}, {Key: "year", Value: 1}}},
+		{Keys: bson.D{{Key: "gearbox", Value: 1}, {Key: "engine_type", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "price", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "year", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "mileage", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "brand", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "stock", Value: 1}}},
+		// cars without a VIN leave the field out and are not indexed
+		{
+			Keys:    bson.D{{Key: "vin", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"vin": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{
+				{Key: "brand", Value: "text"}, {Key: "model", Value: "text"}, {Key: "description", Value: "text"},
+				{Key: "engine_type", Value: "text"}, {Key: "gearbox", Value: "text"},
+			},
+			Options: options.Index().SetName(textIndexName).SetWeights(textIndexWeights),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(reservationsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "released_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(reservationTTL.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(processedEventsCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "processed_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(processedEventTTL.Seconds()))},
+	})
+	return err
+}
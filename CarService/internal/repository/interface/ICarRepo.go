@@ -2,15 +2,75 @@ package _interface
 
 import (
 	"CarStore/CarService/internal/entity"
+	"CarStore/UserService/pkg/apperr"
 	"context"
 	"github.com/google/uuid"
 )
 
+// ErrCarNotFound is returned when no car matches the given id.
+var ErrCarNotFound = apperr.New(apperr.NotFound, "car not found")
+
+// ErrInvalidCarID is returned for ids that are not UUIDs.
+var ErrInvalidCarID = apperr.Field("id", "must be a UUID")
+
+// ErrInsufficientStock is returned when stock cannot be decreased because the
+// car is unknown or has fewer units than requested.
+var ErrInsufficientStock = apperr.New(apperr.FailedPrecondition, "insufficient stock")
+
+// ErrInvalidPageToken is returned when a page token cannot be decoded or
+// does not match the requested sort order.
+var ErrInvalidPageToken = apperr.Field("page_token", "invalid page token")
+
+// ErrVINTaken is returned when another car already has the VIN.
+var ErrVINTaken = apperr.New(apperr.AlreadyExists, "VIN already in use")
+
+// ErrCarChanged is returned when a car was modified since the version an
+// update was based on.
+var ErrCarChanged = apperr.New(apperr.Conflict, "car was modified concurrently; reload it and retry")
+
+// ErrMediaNotFound is returned when a car has no media with the given id.
+var ErrMediaNotFound = apperr.New(apperr.NotFound, "media not found")
+
+// ErrMediaChanged is returned when a car's gallery changed between reading
+// and writing it.
+var ErrMediaChanged = apperr.New(apperr.Conflict, "media changed concurrently")
+
+// ErrReservationNotFound is returned when there is no reservation to release
+// under a key: none was made, or it was released already.
+var ErrReservationNotFound = apperr.New(apperr.NotFound, "no stock reservation to release")
+
+// ErrGalleryFull is returned when a car already holds the most media
+// allowed.
+var ErrGalleryFull = apperr.New(apperr.FailedPrecondition, "gallery is full")
+
 type CarRepo interface {
 	Create(ctx context.Context, car *entity.Car) error
-	Update(ctx context.Context, car *entity.Car) error
+	// Update sets the fields named by their bson keys from car if the car
+	// is still at car.Version, and returns the updated car. It returns
+	// ErrCarChanged otherwise.
+	Update(ctx context.Context, car *entity.Car, fields []string) (*entity.Car, error)
 	GetByID(ctx context.Context, id string) (*entity.Car, error)
 	Delete(ctx context.Context, id string) error
 	List(ctx context.Context) ([]*entity.Car, error)
-	DecreaseStock(ctx context.Context, id uuid.UUID, qty int) (int, error)
+	ListPage(ctx context.Context, q entity.CarListQuery) (*entity.CarPage, error)
+	// Search runs a full-text search over the text index and returns up to
+	// q.Limit hits ordered by relevance.
+	Search(ctx context.Context, q entity.CarSearchQuery) ([]entity.CarSearchHit, error)
+	// SearchTerms returns the distinct values of the short text-indexed
+	// fields (brand, model, gearbox and engine type), from which search
+	// terms are corrected. It reads the whole collection; callers cache it.
+	SearchTerms(ctx context.Context) ([]string, error)
+	// GetFacets counts the cars matching q.Filter per facet value and range
+	// bucket.
+	GetFacets(ctx context.Context, q entity.CarFacetQuery) (*entity.CarFacets, error)
+	// AddMedia appends m to the car's gallery unless it already holds max
+	// items, in which case it returns ErrGalleryFull.
+	AddMedia(ctx context.Context, carID uuid.UUID, m entity.Media, max int) error
+	// ReplaceMedia swaps the gallery for media if it still equals old, and
+	// returns ErrMediaChanged otherwise.
+	ReplaceMedia(ctx context.Context, carID uuid.UUID, old, media []entity.Media) error
+	DecreaseStock(ctx context.Context, id uuid.UUID, qty int, idempotencyKey string) (int, error)
+	// ReleaseStock puts back the stock reserved under idempotencyKey, at
+	// most once, and returns the car's new stock.
+	ReleaseStock(ctx context.Context, idempotencyKey string) (int, error)
 }
@@ -0,0 +1,9 @@
+package _interface
+
+import "context"
+
+// ProcessedEventRepo remembers which events a consumer has already handled.
+type ProcessedEventRepo interface {
+	IsProcessed(ctx context.Context, key string) (bool, error)
+	MarkProcessed(ctx context.Context, key string) error
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// pageToken is the keyset cursor handed to clients: the sort key and id of
+// the last car on the previous page, plus the ordering it was issued for.
+type pageToken struct {
+	SortBy string          `json:"s"`
+	Desc   bool            `json:"d"`
+	Value  json.RawMessage `json:"v"`
+	ID     uuid.UUID       `json:"id"`
+}
+
+type pageCursor struct {
+	value interface{}
+	id    uuid.UUID
+}
+
+func encodePageToken(last *entity.Car, sortBy string, desc bool) (string, error) {
+	var v interface{}
+	switch sortBy {
+	case entity.SortByPrice:
+		v = last.Price
+	case entity.SortByYear:
+		v = last.Year
+	case entity.SortByMileage:
+		v = last.Mileage
+	case entity.SortByBrand:
+		v = last.Brand
+	case entity.SortByCreatedAt:
+		v = last.CreatedAt
+	default:
+		return "", fmt.Errorf("unsupported sort field %q", sortBy)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	buf, err := json.Marshal(pageToken{SortBy: sortBy, Desc: desc, Value: raw, ID: last.ID})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func decodePageToken(token, sortBy string, desc bool) (*pageCursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, _interface.ErrInvalidPageToken
+	}
+	var t pageToken
+	if err := json.Unmarshal(buf, &t); err != nil {
+		return nil, _interface.ErrInvalidPageToken
+	}
+	if t.SortBy != sortBy || t.Desc != desc {
+		return nil, _interface.ErrInvalidPageToken
+	}
+
+	cur := &pageCursor{id: t.ID}
+	switch sortBy {
+	case entity.SortByPrice:
+		var f float64
+		err = json.Unmarshal(t.Value, &f)
+		cur.value = f
+	case entity.SortByYear, entity.SortByMileage:
+		var i int
+		err = json.Unmarshal(t.Value, &i)
+		cur.value = i
+	case entity.SortByBrand:
+		var s string
+		err = json.Unmarshal(t.Value, &s)
+		cur.value = s
+	case entity.SortByCreatedAt:
+		var ts time.Time
+		err = json.Unmarshal(t.Value, &ts)
+		cur.value = ts
+	default:
+		return nil, _interface.ErrInvalidPageToken
+	}
+	if err != nil {
+		return nil, _interface.ErrInvalidPageToken
+	}
+	return cur, nil
+}
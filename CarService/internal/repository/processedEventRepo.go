@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const processedEventsCollection = "processed_events"
+
+// processedEventTTL outlives the JetStream retention of the streams, so an
+// event cannot be redelivered after its entry has expired.
+const processedEventTTL = 8 * 24 * time.Hour
+
+type processedEventRepo struct {
+	coll *mongo.Collection
+}
+
+func NewProcessedEventRepo(db *mongo.Database) _interface.ProcessedEventRepo {
+	return &processedEventRepo{coll: db.Collection(processedEventsCollection)}
+}
+
+func (r processedEventRepo) IsProcessed(ctx context.Context, key string) (bool, error) {
+	n, err := r.coll.CountDocuments(ctx, bson.M{"key": key}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r processedEventRepo) MarkProcessed(ctx context.Context, key string) error {
+	_, err := r.coll.UpdateOne(ctx,
+		bson.M{"key": key},
+		bson.M{"$setOnInsert": entity.ProcessedEvent{Key: key, ProcessedAt: time.Now().UTC()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
@@ -3,12 +3,24 @@ package usecase
 import (
 	"CarStore/CarService/internal/entity"
 	_interface "CarStore/CarService/internal/repository/interface"
+	"CarStore/UserService/pkg/apperr"
 	"context"
 	"github.com/google/uuid"
+	"slices"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ErrInvalidSortField is returned when a listing asks to sort by a field
+// that is not indexed for it.
+var ErrInvalidSortField = apperr.Field("sort_by", "invalid sort field")
+
 type CarUsecase struct {
-	repo _interface.CarRepo
+	repo  _interface.CarRepo
+	vocab vocabularyCache
 }
 
 func NewCarUsecase(r _interface.CarRepo) *CarUsecase {
@@ -16,29 +28,154 @@ func NewCarUsecase(r _interface.CarRepo) *CarUsecase {
 }
 
 func (uc *CarUsecase) Create(ctx context.Context, car *entity.Car) error {
-	return uc.repo.Create(ctx, car)
+	if err := checkVIN(car); err != nil {
+		return err
+	}
+	if err := uc.repo.Create(ctx, car); err != nil {
+		return err
+	}
+	uc.vocab.invalidate()
+	return nil
 }
 
 func (uc *CarUsecase) GetByID(ctx context.Context, id string) (*entity.Car, error) {
 	return uc.repo.GetByID(ctx, id)
 }
 
-func (uc *CarUsecase) Update(ctx context.Context, car *entity.Car) error {
-	return uc.repo.Update(ctx, car)
+// updatableFields are the fields UpdateCar may change, by their proto
+// and bson name, which are the same.
+var updatableFields = []string{
+	"brand", "model", "year", "price", "description", "engine_capacity",
+	"mileage", "gearbox", "engine_type", "stock", "vin",
+}
+
+// outputOnlyFields are ignored in update masks: the gateway puts every key
+// of a PATCH body in the mask, including the id and version that identify
+// the change.
+var outputOnlyFields = []string{"id", "version", "created_at", "media"}
+
+// ErrNothingToUpdate is returned for update masks that name no updatable
+// field.
+var ErrNothingToUpdate = apperr.Field("update_mask", "must name at least one updatable field")
+
+// Update sets the fields named in mask from car, or every updatable field
+// for "*", provided the car is still at car.Version. It returns the
+// updated car, or ErrCarChanged if someone else changed it first.
+func (uc *CarUsecase) Update(ctx context.Context, car *entity.Car, mask []string) (*entity.Car, error) {
+	fields, err := updateFields(mask)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRequired(car, fields); err != nil {
+		return nil, err
+	}
+	if slices.Contains(fields, "vin") {
+		if err := checkVIN(car); err != nil {
+			return nil, err
+		}
+	}
+	updated, err := uc.repo.Update(ctx, car, fields)
+	if err != nil {
+		return nil, err
+	}
+	uc.vocab.invalidate()
+	return updated, nil
+}
+
+// checkRequired rejects updates that would clear a field every car has.
+func checkRequired(car *entity.Car, fields []string) error {
+	for _, f := range fields {
+		if (f == "brand" && car.Brand == "") || (f == "model" && car.Model == "") || (f == "year" && car.Year == 0) {
+			return apperr.Field("car."+f, "required")
+		}
+	}
+	return nil
+}
+
+func updateFields(mask []string) ([]string, error) {
+	var fields []string
+	for _, path := range mask {
+		switch {
+		case path == "*":
+			return updatableFields, nil
+		case slices.Contains(outputOnlyFields, path):
+		case slices.Contains(updatableFields, path):
+			if !slices.Contains(fields, path) {
+				fields = append(fields, path)
+			}
+		default:
+			return nil, apperr.Field("update_mask", "unknown field "+path)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, ErrNothingToUpdate
+	}
+	return fields, nil
 }
 
 func (uc *CarUsecase) Delete(ctx context.Context, id string) error {
-	return uc.repo.Delete(ctx, id)
+	if err := uc.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	uc.vocab.invalidate()
+	return nil
 }
 
 func (uc *CarUsecase) List(ctx context.Context) ([]*entity.Car, error) {
 	return uc.repo.List(ctx)
 }
 
-func (u *CarUsecase) DecreaseStock(ctx context.Context, id string, qty int) (int, error) {
+// ListPage returns one page of the catalog, applying the default sort order
+// and clamping the page size.
+func (uc *CarUsecase) ListPage(ctx context.Context, q entity.CarListQuery) (*entity.CarPage, error) {
+	switch q.SortBy {
+	case "":
+		q.SortBy = entity.SortByCreatedAt
+	case entity.SortByCreatedAt, entity.SortByPrice, entity.SortByYear, entity.SortByMileage, entity.SortByBrand:
+	default:
+		return nil, ErrInvalidSortField
+	}
+	if q.PageSize <= 0 {
+		q.PageSize = defaultPageSize
+	}
+	if q.PageSize > maxPageSize {
+		q.PageSize = maxPageSize
+	}
+	return uc.repo.ListPage(ctx, q)
+}
+
+// Lower boundaries of the year and price buckets of catalog facets. The
+// last bucket of each is open-ended.
+var (
+	yearBucketBoundaries  = []float64{0, 2000, 2005, 2010, 2015, 2020, 2025}
+	priceBucketBoundaries = []float64{0, 5000, 10000, 20000, 30000, 50000, 75000, 100000}
+)
+
+// GetFacets counts the cars matching the filter by brand, gearbox, engine
+// type, year bucket and price bucket, as a listing with the same filter
+// would return them.
+func (uc *CarUsecase) GetFacets(ctx context.Context, f entity.CarFilter) (*entity.CarFacets, error) {
+	return uc.repo.GetFacets(ctx, entity.CarFacetQuery{
+		Filter:          f,
+		YearBoundaries:  yearBucketBoundaries,
+		PriceBoundaries: priceBucketBoundaries,
+	})
+}
+
+// DecreaseStock reserves qty units of a car, at most once per
+// idempotencyKey. Unknown cars and short stock are reported as
+// ErrCarNotFound and ErrInsufficientStock.
+func (u *CarUsecase) DecreaseStock(ctx context.Context, id string, qty int, idempotencyKey string) (int, error) {
 	uid, err := uuid.Parse(id)
 	if err != nil {
-		return 0, err
+		return 0, _interface.ErrCarNotFound
 	}
-	return u.repo.DecreaseStock(ctx, uid, qty)
+	return u.repo.DecreaseStock(ctx, uid, qty, idempotencyKey)
+}
+
+// ReleaseStock puts back the stock DecreaseStock reserved under
+// idempotencyKey. Keys without a reservation, or whose reservation was
+// already released, are reported as ErrReservationNotFound.
+func (u *CarUsecase) ReleaseStock(ctx context.Context, idempotencyKey string) (int, error) {
+	return u.repo.ReleaseStock(ctx, idempotencyKey)
 }
@@ -3,21 +3,33 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
 
 	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+	"CarStore/UserService/pkg/apperr"
 )
 
 // memoryCarRepo is an in-memory implementation of CarRepo for integration testing.
 type memoryCarRepo struct {
-	store map[uuid.UUID]*entity.Car
+	store        map[uuid.UUID]*entity.Car
+	reservations map[string]*entity.StockReservation
+	lastQuery    entity.CarListQuery
+	lastSearch   entity.CarSearchQuery
+	lastFacets   entity.CarFacetQuery
+	termLoads    int
 }
 
 func newMemoryCarRepo() *memoryCarRepo {
-	return &memoryCarRepo{store: make(map[uuid.UUID]*entity.Car)}
+	return &memoryCarRepo{store: make(map[uuid.UUID]*entity.Car), reservations: make(map[string]*entity.StockReservation)}
 }
 
 func (m *memoryCarRepo) Create(ctx context.Context, car *entity.Car) error {
@@ -45,12 +57,113 @@ func (m *memoryCarRepo) List(ctx context.Context) ([]*entity.Car, error) {
 	return list, nil
 }
 
-func (m *memoryCarRepo) Update(ctx context.Context, car *entity.Car) error {
-	if _, ok := m.store[car.ID]; !ok {
-		return fmt.Errorf("car not found")
+// ListPage applies only the in-stock filter and the page size, recording the
+// query it received so tests can inspect the usecase defaults.
+func (m *memoryCarRepo) ListPage(ctx context.Context, q entity.CarListQuery) (*entity.CarPage, error) {
+	m.lastQuery = q
+	page := &entity.CarPage{}
+	for _, c := range m.store {
+		if q.Filter.InStockOnly && c.Stock <= 0 {
+			continue
+		}
+		if len(page.Cars) == q.PageSize {
+			page.NextPageToken = "more"
+			break
+		}
+		page.Cars = append(page.Cars, c)
 	}
-	m.store[car.ID] = car
-	return nil
+	return page, nil
+}
+
+// Search scores a car by the number of query words found in its brand,
+// model, gearbox or engine type, recording the query it received.
+func (m *memoryCarRepo) Search(ctx context.Context, q entity.CarSearchQuery) ([]entity.CarSearchHit, error) {
+	m.lastSearch = q
+	var hits []entity.CarSearchHit
+	for _, c := range m.store {
+		fields := strings.ToLower(strings.Join([]string{c.Brand, c.Model, c.Gearbox, c.EngineType}, " "))
+		score := 0.0
+		for _, w := range strings.Fields(q.Text) {
+			if strings.Contains(fields, w) {
+				score++
+			}
+		}
+		if score > 0 {
+			hits = append(hits, entity.CarSearchHit{Car: c, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+func (m *memoryCarRepo) SearchTerms(ctx context.Context) ([]string, error) {
+	m.termLoads++
+	var terms []string
+	for _, c := range m.store {
+		terms = append(terms, c.Brand, c.Model, c.Gearbox, c.EngineType)
+	}
+	return terms, nil
+}
+
+// GetFacets records the query it received and reports only the total.
+func (m *memoryCarRepo) GetFacets(ctx context.Context, q entity.CarFacetQuery) (*entity.CarFacets, error) {
+	m.lastFacets = q
+	return &entity.CarFacets{Total: int64(len(m.store))}, nil
+}
+
+func (m *memoryCarRepo) Update(ctx context.Context, car *entity.Car, fields []string) (*entity.Car, error) {
+	stored, ok := m.store[car.ID]
+	if !ok {
+		return nil, fmt.Errorf("car not found")
+	}
+	if stored.Version != car.Version {
+		return nil, _interface.ErrCarChanged
+	}
+	updated, err := applyFields(stored, car, fields)
+	if err != nil {
+		return nil, err
+	}
+	m.store[car.ID] = updated
+	return updated, nil
+}
+
+// applyFields copies the named fields from car to stored through their
+// bson documents, as the Mongo repository's $set and $unset do.
+func applyFields(stored, car *entity.Car, fields []string) (*entity.Car, error) {
+	doc, err := bsonDoc(stored)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := bsonDoc(car)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if v, ok := patch[f]; ok {
+			doc[f] = v
+		} else {
+			delete(doc, f)
+		}
+	}
+	doc["version"] = stored.Version + 1
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var updated entity.Car
+	return &updated, bson.Unmarshal(raw, &updated)
+}
+
+func bsonDoc(car *entity.Car) (bson.M, error) {
+	raw, err := bson.Marshal(car)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	return doc, bson.Unmarshal(raw, &doc)
 }
 
 func (m *memoryCarRepo) Delete(ctx context.Context, id string) error {
@@ -65,16 +178,56 @@ func (m *memoryCarRepo) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-func (m *memoryCarRepo) DecreaseStock(ctx context.Context, id uuid.UUID, qty int) (int, error) {
-	car, ok := m.store[id]
+func (m *memoryCarRepo) AddMedia(ctx context.Context, carID uuid.UUID, media entity.Media, max int) error {
+	car, ok := m.store[carID]
+	if !ok {
+		return _interface.ErrCarNotFound
+	}
+	if len(car.Media) >= max {
+		return _interface.ErrGalleryFull
+	}
+	car.Media = append(car.Media, media)
+	return nil
+}
+
+func (m *memoryCarRepo) ReplaceMedia(ctx context.Context, carID uuid.UUID, old, media []entity.Media) error {
+	car, ok := m.store[carID]
 	if !ok {
-		return 0, fmt.Errorf("car not found")
+		return _interface.ErrCarNotFound
 	}
-	if car.Stock < qty {
-		return car.Stock, fmt.Errorf("insufficient stock")
+	if !slices.EqualFunc(car.Media, old, func(a, b entity.Media) bool { return a.ID == b.ID }) {
+		return _interface.ErrMediaChanged
+	}
+	car.Media = media
+	return nil
+}
+
+func (m *memoryCarRepo) DecreaseStock(ctx context.Context, id uuid.UUID, qty int, idempotencyKey string) (int, error) {
+	if r, ok := m.reservations[idempotencyKey]; ok {
+		return r.Stock, nil
+	}
+	car, ok := m.store[id]
+	if !ok || car.Stock < qty {
+		return 0, _interface.ErrInsufficientStock
 	}
 	car.Stock -= qty
 	m.store[id] = car
+	m.reservations[idempotencyKey] = &entity.StockReservation{Key: idempotencyKey, CarID: id, Quantity: qty, Stock: car.Stock}
+	return car.Stock, nil
+}
+
+func (m *memoryCarRepo) ReleaseStock(ctx context.Context, idempotencyKey string) (int, error) {
+	r, ok := m.reservations[idempotencyKey]
+	if !ok || r.ReleasedAt != nil {
+		return 0, _interface.ErrReservationNotFound
+	}
+	car, ok := m.store[r.CarID]
+	if !ok {
+		return 0, _interface.ErrCarNotFound
+	}
+	now := time.Now()
+	r.ReleasedAt = &now
+	car.Stock += r.Quantity
 	return car.Stock, nil
 }
 
@@ -99,11 +252,11 @@ func TestCarUsecase_CRUD(t *testing.T) {
 	assert.Len(t, list, 1)
 
 	// Update
-	c1.Price = 99.99
-	err = uc.Update(ctx, c1)
+	_, err = uc.Update(ctx, &entity.Car{ID: c1.ID, Price: 99.99}, []string{"price"})
 	assert.NoError(t, err)
 	updated, _ := uc.GetByID(ctx, c1.ID.String())
 	assert.Equal(t, 99.99, updated.Price)
+	assert.Equal(t, 10, updated.Stock, "fields outside the mask are kept")
 
 	// Delete
 	err = uc.Delete(ctx, c1.ID.String())
@@ -121,11 +274,230 @@ func TestCarUsecase_DecreaseStock(t *testing.T) {
 	repo.Create(ctx, c)
 
 	// Successful decrease
-	newStock, err := uc.DecreaseStock(ctx, c.ID.String(), 3)
+	newStock, err := uc.DecreaseStock(ctx, c.ID.String(), 3, "order-1")
 	assert.NoError(t, err)
 	assert.Equal(t, 2, newStock)
 
 	// Insufficient stock
-	_, err = uc.DecreaseStock(ctx, c.ID.String(), 10)
+	_, err = uc.DecreaseStock(ctx, c.ID.String(), 10, "order-2")
 	assert.Error(t, err)
+
+	// Released stock is put back, once
+	newStock, err = uc.ReleaseStock(ctx, "order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, newStock)
+	_, err = uc.ReleaseStock(ctx, "order-1")
+	assert.ErrorIs(t, err, _interface.ErrReservationNotFound)
+	assert.Equal(t, 5, c.Stock)
+
+	// Nothing is released for a key that reserved nothing
+	_, err = uc.ReleaseStock(ctx, "order-2")
+	assert.ErrorIs(t, err, _interface.ErrReservationNotFound)
+}
+
+func TestCarUsecase_ListPage(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	uc := NewCarUsecase(repo)
+
+	repo.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "A", Stock: 1})
+	repo.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "B", Stock: 0})
+	repo.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "C", Stock: 3})
+
+	// Defaults
+	page, err := uc.ListPage(ctx, entity.CarListQuery{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Cars, 3)
+	assert.Equal(t, entity.SortByCreatedAt, repo.lastQuery.SortBy)
+	assert.Equal(t, defaultPageSize, repo.lastQuery.PageSize)
+
+	// Page size is clamped
+	_, err = uc.ListPage(ctx, entity.CarListQuery{PageSize: 10_000})
+	assert.NoError(t, err)
+	assert.Equal(t, maxPageSize, repo.lastQuery.PageSize)
+
+	// Filter and paging are passed through
+	page, err = uc.ListPage(ctx, entity.CarListQuery{PageSize: 1, Filter: entity.CarFilter{InStockOnly: true}})
+	assert.NoError(t, err)
+	assert.Len(t, page.Cars, 1)
+	assert.NotEmpty(t, page.NextPageToken)
+
+	// Unknown sort field
+	_, err = uc.ListPage(ctx, entity.CarListQuery{SortBy: "description"})
+	assert.ErrorIs(t, err, ErrInvalidSortField)
+}
+
+func TestCarUsecase_DecreaseStockIdempotent(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	uc := NewCarUsecase(repo)
+	car := &entity.Car{ID: uuid.New(), Brand: "A", Model: "X", Stock: 2}
+	assert.NoError(t, repo.Create(ctx, car))
+
+	stock, err := uc.DecreaseStock(ctx, car.ID.String(), 1, "order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stock)
+
+	// The same reservation is applied once
+	stock, err = uc.DecreaseStock(ctx, car.ID.String(), 1, "order-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stock)
+	got, _ := uc.GetByID(ctx, car.ID.String())
+	assert.Equal(t, 1, got.Stock)
+
+	_, err = uc.DecreaseStock(ctx, car.ID.String(), 2, "order-2")
+	assert.ErrorIs(t, err, _interface.ErrInsufficientStock)
+
+	// Malformed ids are reported as unknown cars
+	_, err = uc.DecreaseStock(ctx, "not-a-uuid", 1, "order-3")
+	assert.ErrorIs(t, err, _interface.ErrCarNotFound)
+}
+
+func TestCarUsecase_Search(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	uc := NewCarUsecase(repo)
+	x5 := &entity.Car{ID: uuid.New(), Brand: "BMW", Model: "X5", Gearbox: "automatic", EngineType: "diesel"}
+	repo.Create(ctx, x5)
+	repo.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "Mercedes-Benz", Model: "E 220", Gearbox: "manual", EngineType: "petrol"})
+
+	// Prefixes are completed and typos corrected; the words typed are kept
+	res, err := uc.Search(ctx, entity.CarSearchQuery{Text: `"Bwm" x5 dies -automatc`})
+	assert.NoError(t, err)
+	assert.Equal(t, "bwm x5 dies automatc bmw diesel automatic", res.Query)
+	assert.Equal(t, res.Query, repo.lastSearch.Text)
+	assert.Equal(t, defaultSearchLimit, repo.lastSearch.Limit)
+	if assert.NotEmpty(t, res.Hits) {
+		assert.Equal(t, x5.ID, res.Hits[0].Car.ID)
+	}
+
+	// Words of hyphenated values are searchable on their own
+	res, err = uc.Search(ctx, entity.CarSearchQuery{Text: "benz", Limit: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, "benz", res.Query)
+	assert.Equal(t, maxSearchLimit, repo.lastSearch.Limit)
+
+	// Short words are not corrected
+	res, err = uc.Search(ctx, entity.CarSearchQuery{Text: "x6"})
+	assert.NoError(t, err)
+	assert.Equal(t, "x6", res.Query)
+
+	_, err = uc.Search(ctx, entity.CarSearchQuery{Text: " -\"\" "})
+	assert.ErrorIs(t, err, ErrEmptySearch)
+}
+
+func TestCarUsecase_SearchVocabularyIsCached(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	uc := NewCarUsecase(repo)
+	assert.NoError(t, uc.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "BMW", Model: "X5"}))
+
+	for range 3 {
+		_, err := uc.Search(ctx, entity.CarSearchQuery{Text: "bwm"})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, repo.termLoads)
+
+	// Writes through the usecase refresh the vocabulary
+	assert.NoError(t, uc.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "Skoda", Model: "Octavia"}))
+	res, err := uc.Search(ctx, entity.CarSearchQuery{Text: "octvia"})
+	assert.NoError(t, err)
+	assert.Equal(t, "octvia octavia", res.Query)
+	assert.Equal(t, 2, repo.termLoads)
+
+	// Writes elsewhere show up once the vocabulary expires
+	repo.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "Volvo"})
+	res, err = uc.Search(ctx, entity.CarSearchQuery{Text: "volvp"})
+	assert.NoError(t, err)
+	assert.Equal(t, "volvp", res.Query)
+	uc.vocab.expires = time.Now()
+	res, err = uc.Search(ctx, entity.CarSearchQuery{Text: "volvp"})
+	assert.NoError(t, err)
+	assert.Equal(t, "volvp volvo", res.Query)
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"bmw", "bmw", 0},
+		{"bwm", "bmw", 1},
+		{"audi", "adi", 1},
+		{"automatc", "automatic", 1},
+		{"mercedez", "mercedes", 1},
+		{"toyota", "tayoto", 2},
+		{"", "abc", 3},
+		{"škoda", "skoda", 1},
+	} {
+		assert.Equal(t, tc.want, editDistance(tc.a, tc.b), "%s -> %s", tc.a, tc.b)
+	}
+}
+
+func TestCarUsecase_GetFacets(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	uc := NewCarUsecase(repo)
+	repo.Create(ctx, &entity.Car{ID: uuid.New(), Brand: "A"})
+
+	filter := entity.CarFilter{Brand: "A", InStockOnly: true}
+	facets, err := uc.GetFacets(ctx, filter)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), facets.Total)
+	assert.Equal(t, filter, repo.lastFacets.Filter)
+	assert.Equal(t, yearBucketBoundaries, repo.lastFacets.YearBoundaries)
+	assert.Equal(t, priceBucketBoundaries, repo.lastFacets.PriceBoundaries)
+}
+
+func TestCarUsecase_VIN(t *testing.T) {
+	ctx := context.Background()
+	uc := NewCarUsecase(newMemoryCarRepo())
+
+	c := &entity.Car{ID: uuid.New(), Brand: "BMW", VIN: " wba8e9g52gnt00000"}
+	assert.NoError(t, uc.Create(ctx, c))
+	assert.Equal(t, "WBA8E9G52GNT00000", c.VIN)
+
+	c.VIN = "1M8GDM9A1KP042788"
+	_, err := uc.Update(ctx, c, []string{"vin"})
+	assert.ErrorIs(t, err, apperr.InvalidArgument)
+	assert.Contains(t, err.Error(), "check digit")
+
+	assert.NoError(t, uc.Create(ctx, &entity.Car{ID: uuid.New()}), "VIN is optional")
+
+	info, err := uc.DecodeVIN("wba8e9g52gnt00000")
+	assert.NoError(t, err)
+	assert.Equal(t, "BMW", info.Brand)
+	assert.Equal(t, 2016, info.ModelYear)
+	_, err = uc.DecodeVIN("WBA8E9G5")
+	assert.ErrorIs(t, err, apperr.InvalidArgument)
+}
+
+func TestCarUsecase_UpdateVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	uc := NewCarUsecase(repo)
+	id := uuid.New()
+	repo.store[id] = &entity.Car{ID: id, Brand: "Audi", Model: "A4", Year: 2019, Price: 20000, Stock: 4, VIN: "WBA8E9G52GNT00000", Version: 3}
+
+	// Two editors read version 3; the second write is stale.
+	first, err := uc.Update(ctx, &entity.Car{ID: id, Price: 18000, Version: 3}, []string{"price", "id", "version"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), first.Version)
+	_, err = uc.Update(ctx, &entity.Car{ID: id, Mileage: 50000, Version: 3}, []string{"mileage"})
+	assert.ErrorIs(t, err, _interface.ErrCarChanged)
+	assert.ErrorIs(t, err, apperr.Conflict)
+
+	// Named fields may be cleared, except required ones
+	cleared, err := uc.Update(ctx, &entity.Car{ID: id, Version: 4}, []string{"stock", "vin"})
+	assert.NoError(t, err)
+	assert.Zero(t, cleared.Stock)
+	assert.Empty(t, cleared.VIN)
+	assert.Equal(t, 18000.0, cleared.Price)
+	_, err = uc.Update(ctx, &entity.Car{ID: id, Version: 5}, []string{"brand"})
+	assert.ErrorIs(t, err, apperr.InvalidArgument)
+
+	for _, mask := range [][]string{nil, {"id", "created_at"}, {"owner"}} {
+		_, err = uc.Update(ctx, &entity.Car{ID: id, Version: 5}, mask)
+		assert.ErrorIs(t, err, apperr.InvalidArgument, mask)
+	}
 }
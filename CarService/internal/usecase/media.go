@@ -0,0 +1,261 @@
+package usecase
+
+import (
+	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+	"CarStore/CarService/pkg/blob"
+	"CarStore/UserService/pkg/apperr"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// MaxMediaSize is the largest image accepted, in bytes.
+	MaxMediaSize = 10 << 20
+	// maxMediaPerCar bounds a car's gallery.
+	maxMediaPerCar = 20
+	// maxImagePixels rejects images whose decoded size would be far larger
+	// than their upload, before they are decoded. 24 megapixels, as from a
+	// 6000x4000 camera, take about 96 MiB as RGBA.
+	maxImagePixels = 24_000_000
+	// maxConcurrentDecodes bounds the memory that decoding uploads takes.
+	maxConcurrentDecodes = 4
+	// thumbnailSize is the longest side of a thumbnail.
+	thumbnailSize = 320
+)
+
+var (
+	ErrMediaTooLarge = apperr.New(apperr.InvalidArgument, fmt.Sprintf("image exceeds %d MiB", MaxMediaSize>>20))
+	// ErrUnsupportedImage is returned for uploads that are not a JPEG, PNG
+	// or GIF image, whatever content type the client claimed.
+	ErrUnsupportedImage = apperr.New(apperr.InvalidArgument, "unsupported image: use JPEG, PNG or GIF")
+	ErrImageTooLarge    = apperr.New(apperr.InvalidArgument, "image dimensions are too large")
+	// ErrInvalidMediaOrder is returned when a reorder does not list each
+	// media item of the car exactly once.
+	ErrInvalidMediaOrder = apperr.Field("media_ids", "must list every media id of the car exactly once")
+	ErrInvalidMediaID    = apperr.Field("media_id", "must be a UUID")
+)
+
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// MediaUsecase manages car image galleries. Image data lives in the blob
+// store; the car document keeps the ordered references.
+type MediaUsecase struct {
+	repo    _interface.CarRepo
+	store   blob.Store
+	decodes chan struct{}
+}
+
+func NewMediaUsecase(r _interface.CarRepo, store blob.Store) *MediaUsecase {
+	return &MediaUsecase{repo: r, store: store, decodes: make(chan struct{}, maxConcurrentDecodes)}
+}
+
+// Upload reads an image of at most MaxMediaSize bytes from r, stores it
+// with a JPEG thumbnail and appends it to the car's gallery. The format is
+// detected from the data rather than taken from the client.
+func (uc *MediaUsecase) Upload(ctx context.Context, carID string, r io.Reader) (*entity.Media, error) {
+	car, err := uc.repo.GetByID(ctx, carID)
+	if err != nil {
+		return nil, err
+	}
+	if len(car.Media) >= maxMediaPerCar {
+		return nil, _interface.ErrGalleryFull
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxMediaSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxMediaSize {
+		return nil, ErrMediaTooLarge
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || contentTypes[format] == "" {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+	thumb, err := uc.decodeThumbnail(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New()
+	m := entity.Media{
+		ID:           id,
+		ContentType:  contentTypes[format],
+		Key:          fmt.Sprintf("cars/%s/%s.%s", car.ID, id, format),
+		ThumbnailKey: fmt.Sprintf("cars/%s/%s_thumb.jpeg", car.ID, id),
+		Width:        cfg.Width,
+		Height:       cfg.Height,
+		Size:         int64(len(data)),
+		CreatedAt:    time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if err := uc.store.Put(ctx, m.Key, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if err := uc.store.Put(ctx, m.ThumbnailKey, bytes.NewReader(thumb)); err != nil {
+		uc.deleteBlobs(m)
+		return nil, err
+	}
+	if err := uc.repo.AddMedia(ctx, car.ID, m, maxMediaPerCar); err != nil {
+		uc.deleteBlobs(m)
+		return nil, err
+	}
+	return &m, nil
+}
+
+// decodeThumbnail decodes data and returns its thumbnail, waiting while
+// maxConcurrentDecodes other images are being decoded.
+func (uc *MediaUsecase) decodeThumbnail(ctx context.Context, data []byte) ([]byte, error) {
+	select {
+	case uc.decodes <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-uc.decodes }()
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	return thumbnail(img)
+}
+
+// thumbnail scales img to fit a thumbnailSize square, keeping its aspect
+// ratio, and encodes it as JPEG. Smaller images are not enlarged.
+func thumbnail(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > thumbnailSize || h > thumbnailSize {
+		if w >= h {
+			w, h = thumbnailSize, max(1, h*thumbnailSize/w)
+		} else {
+			w, h = max(1, w*thumbnailSize/h), thumbnailSize
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	// JPEG has no transparency; show transparent areas as white
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Open returns the image, or its thumbnail, and its content type.
+func (uc *MediaUsecase) Open(ctx context.Context, carID, mediaID string, thumb bool) (io.ReadCloser, string, error) {
+	car, m, err := uc.find(ctx, carID, mediaID)
+	if err != nil {
+		return nil, "", err
+	}
+	key, contentType := m.Key, m.ContentType
+	if thumb {
+		key, contentType = m.ThumbnailKey, "image/jpeg"
+	}
+	r, err := uc.store.Open(ctx, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		log.Printf("media %s of car %s is missing blob %s", m.ID, car.ID, key)
+		return nil, "", _interface.ErrMediaNotFound
+	}
+	return r, contentType, err
+}
+
+// Delete removes an image from the gallery and then from the blob store.
+func (uc *MediaUsecase) Delete(ctx context.Context, carID, mediaID string) error {
+	car, m, err := uc.find(ctx, carID, mediaID)
+	if err != nil {
+		return err
+	}
+	rest := make([]entity.Media, 0, len(car.Media)-1)
+	for _, other := range car.Media {
+		if other.ID != m.ID {
+			rest = append(rest, other)
+		}
+	}
+	if err := uc.repo.ReplaceMedia(ctx, car.ID, car.Media, rest); err != nil {
+		return err
+	}
+	uc.deleteBlobs(*m)
+	return nil
+}
+
+// Reorder puts the gallery in the order of mediaIDs, which must name every
+// image of the car once.
+func (uc *MediaUsecase) Reorder(ctx context.Context, carID string, mediaIDs []string) ([]entity.Media, error) {
+	car, err := uc.repo.GetByID(ctx, carID)
+	if err != nil {
+		return nil, err
+	}
+	if len(mediaIDs) != len(car.Media) {
+		return nil, ErrInvalidMediaOrder
+	}
+	byID := make(map[uuid.UUID]entity.Media, len(car.Media))
+	for _, m := range car.Media {
+		byID[m.ID] = m
+	}
+	ordered := make([]entity.Media, 0, len(mediaIDs))
+	for _, id := range mediaIDs {
+		uid, err := uuid.Parse(id)
+		if err != nil {
+			return nil, ErrInvalidMediaOrder
+		}
+		m, ok := byID[uid]
+		if !ok {
+			return nil, ErrInvalidMediaOrder
+		}
+		delete(byID, uid) // catches duplicates
+		ordered = append(ordered, m)
+	}
+	if err := uc.repo.ReplaceMedia(ctx, car.ID, car.Media, ordered); err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
+
+func (uc *MediaUsecase) find(ctx context.Context, carID, mediaID string) (*entity.Car, *entity.Media, error) {
+	uid, err := uuid.Parse(mediaID)
+	if err != nil {
+		return nil, nil, ErrInvalidMediaID
+	}
+	car, err := uc.repo.GetByID(ctx, carID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range car.Media {
+		if car.Media[i].ID == uid {
+			return car, &car.Media[i], nil
+		}
+	}
+	return nil, nil, _interface.ErrMediaNotFound
+}
+
+// deleteBlobs removes the files of media that is not, or no longer,
+// referenced. Failures only leave orphaned files behind, so they are
+// logged rather than returned.
+func (uc *MediaUsecase) deleteBlobs(m entity.Media) {
+	ctx := context.Background()
+	for _, key := range []string{m.Key, m.ThumbnailKey} {
+		if err := uc.store.Delete(ctx, key); err != nil {
+			log.Printf("delete blob %s: %v", key, err)
+		}
+	}
+}
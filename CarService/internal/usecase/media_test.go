@@ -0,0 +1,117 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"CarStore/CarService/internal/entity"
+	_interface "CarStore/CarService/internal/repository/interface"
+	"CarStore/CarService/pkg/blob"
+)
+
+func pngImage(t *testing.T, w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, h/2, color.NRGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestMediaUsecase(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	car := &entity.Car{ID: uuid.New(), Brand: "BMW"}
+	repo.store[car.ID] = car
+	store, err := blob.NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+	uc := NewMediaUsecase(repo, store)
+
+	data := pngImage(t, 640, 480)
+	first, err := uc.Upload(ctx, car.ID.String(), bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", first.ContentType)
+	assert.Equal(t, 640, first.Width)
+	assert.Equal(t, int64(len(data)), first.Size)
+
+	r, contentType, err := uc.Open(ctx, car.ID.String(), first.ID.String(), false)
+	require.NoError(t, err)
+	got, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, data, got)
+
+	// the thumbnail keeps the aspect ratio
+	r, contentType, err = uc.Open(ctx, car.ID.String(), first.ID.String(), true)
+	require.NoError(t, err)
+	thumb, err := jpeg.DecodeConfig(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", contentType)
+	assert.Equal(t, [2]int{320, 240}, [2]int{thumb.Width, thumb.Height})
+
+	second, err := uc.Upload(ctx, car.ID.String(), bytes.NewReader(pngImage(t, 10, 20)))
+	require.NoError(t, err)
+
+	_, err = uc.Reorder(ctx, car.ID.String(), []string{second.ID.String()})
+	assert.ErrorIs(t, err, ErrInvalidMediaOrder)
+	_, err = uc.Reorder(ctx, car.ID.String(), []string{second.ID.String(), second.ID.String()})
+	assert.ErrorIs(t, err, ErrInvalidMediaOrder)
+	ordered, err := uc.Reorder(ctx, car.ID.String(), []string{second.ID.String(), first.ID.String()})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{second.ID, first.ID}, []uuid.UUID{ordered[0].ID, ordered[1].ID})
+
+	require.NoError(t, uc.Delete(ctx, car.ID.String(), second.ID.String()))
+	assert.Len(t, car.Media, 1)
+	_, err = store.Open(ctx, second.Key)
+	assert.ErrorIs(t, err, blob.ErrNotFound)
+	_, _, err = uc.Open(ctx, car.ID.String(), second.ID.String(), false)
+	assert.ErrorIs(t, err, _interface.ErrMediaNotFound)
+}
+
+func TestMediaUsecase_RejectsUploads(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryCarRepo()
+	car := &entity.Car{ID: uuid.New()}
+	repo.store[car.ID] = car
+	store, err := blob.NewLocalFS(t.TempDir())
+	require.NoError(t, err)
+	uc := NewMediaUsecase(repo, store)
+
+	_, err = uc.Upload(ctx, car.ID.String(), strings.NewReader("<svg></svg>"))
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+
+	tooLarge := io.MultiReader(bytes.NewReader(pngImage(t, 1, 1)), bytes.NewReader(make([]byte, MaxMediaSize)))
+	_, err = uc.Upload(ctx, car.ID.String(), tooLarge)
+	assert.ErrorIs(t, err, ErrMediaTooLarge)
+
+	// PNG headers claiming too many pixels are refused before decoding
+	for _, size := range [][2]uint32{{100000, 100000}, {5000, 5000}} {
+		bomb := pngImage(t, 1, 1)
+		binary.BigEndian.PutUint32(bomb[16:20], size[0])
+		binary.BigEndian.PutUint32(bomb[20:24], size[1])
+		binary.BigEndian.PutUint32(bomb[29:33], crc32.ChecksumIEEE(bomb[12:29]))
+		_, err = uc.Upload(ctx, car.ID.String(), bytes.NewReader(bomb))
+		assert.ErrorIs(t, err, ErrImageTooLarge, size)
+	}
+
+	for range maxMediaPerCar {
+		car.Media = append(car.Media, entity.Media{ID: uuid.New()})
+	}
+	_, err = uc.Upload(ctx, car.ID.String(), bytes.NewReader(pngImage(t, 1, 1)))
+	assert.ErrorIs(t, err, _interface.ErrGalleryFull)
+}
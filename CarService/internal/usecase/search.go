@@ -0,0 +1,219 @@
+package usecase
+
+import (
+	"CarStore/CarService/internal/entity"
+	"CarStore/UserService/pkg/apperr"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	// maxSearchWords bounds the work done on very long queries.
+	maxSearchWords = 10
+	// maxPrefixExpansions bounds how many vocabulary words a prefix adds.
+	maxPrefixExpansions = 5
+	// vocabularyTTL bounds how stale the vocabulary gets when cars are
+	// written through another instance; writes through this one refresh
+	// it right away.
+	vocabularyTTL = 5 * time.Minute
+)
+
+// ErrEmptySearch is returned when a search query has no letters or digits.
+var ErrEmptySearch = apperr.Field("q", "must contain a search term")
+
+// Search runs a full-text search. The text index matches whole (stemmed)
+// words only, so each word is first completed or corrected against the
+// vocabulary of brands, models, gearboxes and engine types: a prefix such
+// as "merc" adds "mercedes", and a typo such as "bwm" adds "bmw". The
+// original words are kept, since they may still match a description.
+func (uc *CarUsecase) Search(ctx context.Context, q entity.CarSearchQuery) (*entity.CarSearchResult, error) {
+	words := searchWords(q.Text)
+	if len(words) == 0 {
+		return nil, ErrEmptySearch
+	}
+	if len(words) > maxSearchWords {
+		words = words[:maxSearchWords]
+	}
+	vocab, err := uc.vocab.get(ctx, uc.repo.SearchTerms)
+	if err != nil {
+		return nil, err
+	}
+	q.Text = strings.Join(expandWords(words, vocab), " ")
+
+	if q.Limit <= 0 {
+		q.Limit = defaultSearchLimit
+	}
+	if q.Limit > maxSearchLimit {
+		q.Limit = maxSearchLimit
+	}
+	hits, err := uc.repo.Search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.CarSearchResult{Hits: hits, Query: q.Text}, nil
+}
+
+// vocabularyCache keeps the search vocabulary between searches, since
+// loading it reads every car.
+type vocabularyCache struct {
+	mu      sync.Mutex
+	words   []string
+	expires time.Time
+}
+
+// get returns the cached vocabulary, loading the terms again once it has
+// expired. Concurrent searches wait for a single load.
+func (c *vocabularyCache) get(ctx context.Context, load func(context.Context) ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Now().Before(c.expires) {
+		return c.words, nil
+	}
+	terms, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.words, c.expires = vocabulary(terms), time.Now().Add(vocabularyTTL)
+	return c.words, nil
+}
+
+// invalidate makes the next search load the vocabulary again.
+func (c *vocabularyCache) invalidate() {
+	c.mu.Lock()
+	c.expires = time.Time{}
+	c.mu.Unlock()
+}
+
+// searchWords lowercases text and splits it into words of letters and
+// digits. Everything else is dropped, including the quotes and minus signs
+// the text search would read as phrase and negation operators.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// vocabulary splits field values into a sorted set of distinct words.
+func vocabulary(values []string) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, v := range values {
+		for _, w := range searchWords(v) {
+			if !seen[w] {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+	}
+	sort.Strings(words)
+	return words
+}
+
+// expandWords returns the words followed by the completions and corrections
+// of those not in vocab, without duplicates.
+func expandWords(words, vocab []string) []string {
+	known := make(map[string]bool, len(vocab))
+	for _, v := range vocab {
+		known[v] = true
+	}
+	out := make([]string, 0, len(words))
+	added := make(map[string]bool)
+	add := func(w string) {
+		if !added[w] {
+			added[w] = true
+			out = append(out, w)
+		}
+	}
+	for _, w := range words {
+		add(w)
+	}
+	for _, w := range words {
+		if known[w] {
+			continue
+		}
+		if completions := completeWord(w, vocab); len(completions) > 0 {
+			for _, c := range completions {
+				add(c)
+			}
+		} else if c, ok := correctWord(w, vocab); ok {
+			add(c)
+		}
+	}
+	return out
+}
+
+// completeWord returns the vocabulary words that w is a prefix of. Single
+// characters are too ambiguous to complete.
+func completeWord(w string, vocab []string) []string {
+	if len([]rune(w)) < 2 {
+		return nil
+	}
+	// vocab is sorted, so the completions are adjacent
+	i := sort.SearchStrings(vocab, w)
+	var out []string
+	for ; i < len(vocab) && strings.HasPrefix(vocab[i], w) && len(out) < maxPrefixExpansions; i++ {
+		out = append(out, vocab[i])
+	}
+	return out
+}
+
+// correctWord returns the vocabulary word closest to w, if it is within the
+// edits allowed for w's length. Ties go to the first word alphabetically.
+func correctWord(w string, vocab []string) (string, bool) {
+	allowed := maxEdits(len([]rune(w)))
+	best, bestDist := "", allowed+1
+	for _, v := range vocab {
+		if d := editDistance(w, v); d < bestDist {
+			best, bestDist = v, d
+		}
+	}
+	return best, best != ""
+}
+
+// maxEdits allows one typo in words of three to seven characters and two in
+// longer words. Shorter words, such as model codes, are taken as typed.
+func maxEdits(n int) int {
+	switch {
+	case n < 3:
+		return 0
+	case n < 8:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// the number of insertions, deletions, substitutions and transpositions of
+// adjacent characters that turn a into b.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	// rows i-2, i-1 and i of the distance matrix
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
@@ -0,0 +1,31 @@
+package usecase
+
+import (
+	"CarStore/CarService/internal/entity"
+	"CarStore/CarService/pkg/vin"
+	"CarStore/UserService/pkg/apperr"
+	"time"
+)
+
+// checkVIN normalizes and validates the car's VIN. Cars may have no VIN.
+func checkVIN(car *entity.Car) error {
+	car.VIN = vin.Normalize(car.VIN)
+	if car.VIN == "" {
+		return nil
+	}
+	if err := vin.Validate(car.VIN); err != nil {
+		return apperr.Field("car.vin", err.Error())
+	}
+	return nil
+}
+
+// DecodeVIN reads the region, manufacturer and model year from a VIN,
+// offline, so that a new car's brand and year can be prefilled.
+func (uc *CarUsecase) DecodeVIN(v string) (*vin.Info, error) {
+	// next year's models are sold from the summer before
+	info, err := vin.Decode(vin.Normalize(v), time.Now().Year()+1)
+	if err != nil {
+		return nil, apperr.Field("vin", err.Error())
+	}
+	return info, nil
+}
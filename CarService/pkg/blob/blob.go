@@ -0,0 +1,45 @@
+// Package blob stores binary objects, such as car images, under string keys.
+// Store is the extension point for other backends, e.g. an object store;
+// LocalFS keeps objects on the local filesystem.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when no object has the key.
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey is returned for keys that are empty, absolute or
+	// contain "." or ".." segments.
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Store keeps objects by key. Keys are slash-separated relative paths such
+// as "cars/<id>/<media id>.jpg".
+type Store interface {
+	// Put stores the content of r under key, replacing any existing
+	// object. A failed Put leaves no partial object behind.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns a reader for the object; the caller closes it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object. Deleting a missing object is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey reports whether key is usable by every Store.
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
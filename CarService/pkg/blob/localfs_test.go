@@ -0,0 +1,55 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalFS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewLocalFS(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put(ctx, "cars/1/a.jpg", strings.NewReader("image")))
+	r, err := s.Open(ctx, "cars/1/a.jpg")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "image", string(data))
+
+	require.NoError(t, s.Delete(ctx, "cars/1/a.jpg"))
+	_, err = s.Open(ctx, "cars/1/a.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, s.Delete(ctx, "cars/1/a.jpg"), "deleting twice")
+
+	for _, key := range []string{"", "/etc/passwd", "../outside", "cars/../../x", "cars//a", `cars\a`} {
+		assert.ErrorIs(t, s.Put(ctx, key, strings.NewReader("x")), ErrInvalidKey, key)
+	}
+}
+
+func TestLocalFS_FailedPutLeavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewLocalFS(dir)
+	require.NoError(t, err)
+
+	broken := io.MultiReader(strings.NewReader("partial"), errReader{})
+	assert.Error(t, s.Put(context.Background(), "cars/1/a.jpg", broken))
+
+	entries, err := os.ReadDir(filepath.Join(dir, "cars", "1"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
@@ -0,0 +1,105 @@
+package vin
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"strings"
+)
+
+// Info is what a VIN tells about a car without any online lookup.
+type Info struct {
+	VIN string
+	// WMI is the world manufacturer identifier, the first three
+	// characters.
+	WMI    string
+	Region string
+	// Manufacturer and Brand are empty for WMIs missing from the table.
+	Manufacturer string
+	Brand        string
+	// ModelYear is 0 if the 10th character is not a year code.
+	ModelYear int
+}
+
+// Decode validates v and reads its region, manufacturer and model year.
+// Years are only encoded modulo 30, so the latest candidate that is not
+// after maxYear is chosen; for North American cars the 7th character
+// settles it as the standard prescribes: a digit means 1980-2009 and a
+// letter 2010-2039.
+func Decode(v string, maxYear int) (*Info, error) {
+	if err := Validate(v); err != nil {
+		return nil, err
+	}
+	info := &Info{VIN: v, WMI: v[:3], Region: region(v[0]), ModelYear: modelYear(v, maxYear)}
+	m, ok := manufacturers[v[:3]]
+	if !ok {
+		m = manufacturers[v[:2]]
+	}
+	info.Manufacturer, info.Brand = m.name, m.brand
+	return info, nil
+}
+
+// region maps the first character to the continent that assigns it.
+func region(c byte) string {
+	switch {
+	case c >= 'A' && c <= 'H':
+		return "Africa"
+	case c >= 'J' && c <= 'R':
+		return "Asia"
+	case c >= 'S' && c <= 'Z':
+		return "Europe"
+	case c >= '1' && c <= '5':
+		return "North America"
+	case c == '6' || c == '7':
+		return "Oceania"
+	case c == '8' || c == '9':
+		return "South America"
+	}
+	return ""
+}
+
+// yearCodes are the 10th-character codes of 1980 onwards, repeating every
+// 30 years.
+const yearCodes = "ABCDEFGHJKLMNPRSTVWXY123456789"
+
+func modelYear(v string, maxYear int) int {
+	i := strings.IndexByte(yearCodes, v[9])
+	if i < 0 {
+		return 0
+	}
+	year := 1980 + i
+	if region(v[0]) == "North America" {
+		if v[6] < '0' || v[6] > '9' {
+			year += 30
+		}
+		return year
+	}
+	for year+30 <= maxYear {
+		year += 30
+	}
+	return year
+}
+
+type manufacturer struct {
+	name, brand string
+}
+
+// wmiTable lists world manufacturer identifiers with the manufacturer
+// and brand they stand for. Two-character entries cover every WMI with
+// that prefix that has no entry of its own.
+//
+//go:embed wmi.csv
+var wmiTable string
+
+var manufacturers = parseWMITable(wmiTable)
+
+func parseWMITable(table string) map[string]manufacturer {
+	records, err := csv.NewReader(strings.NewReader(table)).ReadAll()
+	if err != nil {
+		panic("vin: bad wmi.csv: " + err.Error())
+	}
+	m := make(map[string]manufacturer, len(records))
+	for _, r := range records[1:] { // header
+		m[r[0]] = manufacturer{name: r[1], brand: r[2]}
+	}
+	return m
+}
@@ -0,0 +1,85 @@
+// Package vin validates vehicle identification numbers (ISO 3779) and
+// decodes the region, manufacturer and model year from them using an
+// embedded, offline table of world manufacturer identifiers.
+package vin
+
+import (
+	"errors"
+	"strings"
+)
+
+// Length is the length of every VIN since 1981.
+const Length = 17
+
+var (
+	ErrLength     = errors.New("must be 17 characters long")
+	ErrCharacter  = errors.New("may only contain digits and the letters A-Z except I, O and Q")
+	ErrCheckDigit = errors.New("check digit does not match")
+)
+
+// Normalize upper-cases v and strips surrounding space, as VINs are often
+// typed in lower case.
+func Normalize(v string) string {
+	return strings.ToUpper(strings.TrimSpace(v))
+}
+
+// Validate checks the length and the alphabet of a normalized VIN, and
+// the check digit in the 9th position of North American VINs. Elsewhere
+// the check digit is optional and the position often holds a letter or
+// an unrelated manufacturer code.
+func Validate(v string) error {
+	if len(v) != Length {
+		return ErrLength
+	}
+	digit, err := CheckDigit(v)
+	if err != nil {
+		return err
+	}
+	if region(v[0]) == "North America" && v[8] != digit {
+		return ErrCheckDigit
+	}
+	return nil
+}
+
+// weights of the 17 positions; the check digit itself weighs nothing
+var weights = [Length]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+// CheckDigit computes the check digit of v: the weighted sum of the
+// transliterated characters modulo 11, with 10 written as X.
+func CheckDigit(v string) (byte, error) {
+	if len(v) != Length {
+		return 0, ErrLength
+	}
+	sum := 0
+	for i := 0; i < Length; i++ {
+		n, ok := transliterate(v[i])
+		if !ok {
+			return 0, ErrCharacter
+		}
+		sum += n * weights[i]
+	}
+	if r := sum % 11; r < 10 {
+		return byte('0' + r), nil
+	}
+	return 'X', nil
+}
+
+// transliterate returns the value of a VIN character. I, O and Q are not
+// used as they look like 1 and 0.
+func transliterate(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'H':
+		return int(c-'A') + 1, true
+	case c >= 'J' && c <= 'N':
+		return int(c-'J') + 1, true
+	case c == 'P':
+		return 7, true
+	case c == 'R':
+		return 9, true
+	case c >= 'S' && c <= 'Z':
+		return int(c-'S') + 2, true
+	}
+	return 0, false
+}
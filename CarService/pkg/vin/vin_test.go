@@ -0,0 +1,52 @@
+package vin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("1M8GDM9AXKP042788"))
+	assert.NoError(t, Validate(Normalize(" wba8e9g52gnt00000 ")))
+
+	// European manufacturers need not use the check digit
+	for _, v := range []string{"WVWZZZ1KZ6W612345", "WDB2030461A123456", "VF1BM0B0H12345678"} {
+		assert.NoError(t, Validate(v), v)
+	}
+
+	assert.ErrorIs(t, Validate("1M8GDM9A1KP042788"), ErrCheckDigit)
+	assert.ErrorIs(t, Validate("WVWZZZ1KZ6WO12345"), ErrCharacter, "alphabet is checked everywhere")
+	assert.ErrorIs(t, Validate("1M8GDM9AXKP04278"), ErrLength)
+	assert.ErrorIs(t, Validate("1M8GDM9AXKPO42788"), ErrCharacter, "O instead of 0")
+	assert.ErrorIs(t, Validate("1m8gdm9axkp042788"), ErrCharacter, "not normalized")
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		vin  string
+		want Info
+	}{
+		// the 10th character G is 1986 or 2016
+		{"WBA8E9G52GNT00000", Info{WMI: "WBA", Region: "Europe", Manufacturer: "BMW", Brand: "BMW", ModelYear: 2016}},
+		// North America: a letter in 7th place means 2010-2039
+		{"5YJ3E1EA2KF317000", Info{WMI: "5YJ", Region: "North America", Manufacturer: "Tesla", Brand: "Tesla", ModelYear: 2019}},
+		// and a digit 1980-2009
+		{"1M8GDM9AXKP042788", Info{WMI: "1M8", Region: "North America", ModelYear: 1989}},
+		// a three-character entry takes precedence over the JT prefix
+		{"JTHBK1GG1E2000000", Info{WMI: "JTH", Region: "Asia", Manufacturer: "Toyota", Brand: "Lexus", ModelYear: 2014}},
+		{"JTDKB20U903000000", Info{WMI: "JTD", Region: "Asia", Manufacturer: "Toyota", Brand: "Toyota"}},
+		// 2031 would be in the future
+		{"ZZZ11111411111111", Info{WMI: "ZZZ", Region: "Europe", ModelYear: 2001}},
+	}
+	for _, tt := range tests {
+		got, err := Decode(tt.vin, 2026)
+		require.NoError(t, err, tt.vin)
+		tt.want.VIN = tt.vin
+		assert.Equal(t, tt.want, *got, tt.vin)
+	}
+
+	_, err := Decode("1M8GDM9A1KP042788", 2026)
+	assert.ErrorIs(t, err, ErrCheckDigit)
+}
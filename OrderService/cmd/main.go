@@ -3,14 +3,21 @@ package main
 import (
 	orderpb "CarStore/OrderService/api/pb/order"
 	"CarStore/OrderService/internal/handler"
+	"CarStore/OrderService/internal/outbox"
 	"CarStore/OrderService/internal/repository"
 	"CarStore/OrderService/internal/usecase"
+	"CarStore/OrderService/pkg/carclient"
 	"CarStore/OrderService/pkg/mongo"
 	"CarStore/UserService/pkg/auth"
+	"CarStore/UserService/pkg/grpcserver"
 	"CarStore/UserService/pkg/jwt"
+	"CarStore/UserService/pkg/redis"
+	"CarStore/UserService/pkg/session"
+	"CarStore/UserService/pkg/stream"
+	"context"
 	"github.com/joho/godotenv"
 	"github.com/nats-io/nats.go"
-	"google.golang.org/grpc"
+	"github.com/nats-io/nats.go/jetstream"
 	"log"
 	"net"
 	"os"
@@ -20,32 +27,68 @@ func main() {
 	_ = godotenv.Load()
 	uri := os.Getenv("ORDER_SERVICE_MONGO_URI")
 	dbName := os.Getenv("ORDER_SERVICE_DB_NAME")
-	jwtSecret := os.Getenv("JWT_SECRET")
 	port := os.Getenv("ORDER_SERVICE_PORT")
 	if port == "" {
 		port = "50054"
 	}
+	carAddr := os.Getenv("CAR_SERVICE_ADDR")
+	if carAddr == "" {
+		carAddr = "localhost:50053"
+	}
 
 	client, err := mongo.NewMongoClient(uri + dbName)
 	if err != nil {
 		log.Fatal(err)
 	}
 	db := client.Database(dbName)
+	if err := repository.EnsureOutboxIndexes(context.Background(), db); err != nil {
+		log.Fatalf("mongo indexes: %v", err)
+	}
 
 	nc, err := nats.Connect(os.Getenv("NATS_URL"))
 	if err != nil {
 		log.Fatalf("NATS connect failed: %v", err)
 	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		log.Fatalf("JetStream: %v", err)
+	}
+	if err := stream.EnsureStreams(context.Background(), js); err != nil {
+		log.Fatalf("JetStream streams: %v", err)
+	}
+
+	cars, err := carclient.New(carAddr)
+	if err != nil {
+		log.Fatalf("CarService client: %v", err)
+	}
+	defer cars.Close()
 
 	repo := repository.NewOrderRepo(db)
-	uc := usecase.NewOrderUsecase(repo, nc)
-	jwtSvc := jwt.NewJWTService(jwtSecret, "OrderService")
+	outboxRepo := repository.NewOutboxRepo(db)
+	uc := usecase.NewOrderUsecase(repo, outboxRepo, cars)
+	publicKeys, err := jwt.LoadPublicKeys(os.Getenv("JWT_PUBLIC_KEYS"))
+	if err != nil || len(publicKeys) == 0 {
+		log.Fatalf("JWT_PUBLIC_KEYS must list the token verification keys: %v", err)
+	}
+	jwtSvc := jwt.NewVerifier("UserService", publicKeys...)
+
+	if err := handler.NewStockEventHandler(uc, js).Subscribe(context.Background()); err != nil {
+		log.Fatalf("JetStream consume: %v", err)
+	}
+
+	// relay order events written to the outbox
+	go outbox.NewRelay(outboxRepo, outbox.NewJetStreamPublisher(js)).Run(context.Background())
+
+	// access tokens revoked by UserService are looked up in the shared Redis
+	revoked := session.NewStore(redis.NewClient(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASS"), 0))
 
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(auth.UnaryAuthInterceptor(*jwtSvc)))
+	suspended := auth.NewStatusCache(revoked, auth.StatusCacheTTL)
+	grpcServer := grpcserver.New(grpcserver.Options{JWT: *jwtSvc, Revoked: revoked, Suspended: suspended})
+	grpcserver.ServeMetrics(os.Getenv("ORDER_SERVICE_METRICS_ADDR"))
 
 	orderpb.RegisterOrderServiceServer(grpcServer, handler.NewOrderHandler(uc))
 
@@ -0,0 +1,54 @@
+package entity
+
+import "time"
+
+// NATS subjects of the order/stock saga. Lifecycle transitions are
+// published as "order.<status>", e.g. order.confirmed.
+const (
+	SubjectOrderCreated  = "order.created"
+	SubjectStockReserved = "stock.reserved"
+	SubjectStockRejected = "stock.rejected"
+	SubjectStockReleased = "stock.released"
+)
+
+// OrderCreatedEvent asks CarService to reserve stock for a new order.
+type OrderCreatedEvent struct {
+	OrderID   string    `json:"order_id"`
+	CarID     string    `json:"car_id"`
+	Quantity  int       `json:"quantity"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrderStatusEvent is published for every lifecycle transition.
+type OrderStatusEvent struct {
+	OrderID    string    `json:"order_id"`
+	UserID     string    `json:"user_id"`
+	CarID      string    `json:"car_id"`
+	Quantity   int       `json:"quantity"`
+	FromStatus string    `json:"from_status"`
+	Status     string    `json:"status"`
+	At         time.Time `json:"at"`
+}
+
+// StockReservedEvent is CarService's confirmation that stock was taken.
+type StockReservedEvent struct {
+	OrderID  string `json:"order_id"`
+	CarID    string `json:"car_id"`
+	Quantity int    `json:"quantity"`
+	NewStock int    `json:"new_stock"`
+}
+
+// StockRejectedEvent is CarService's refusal to reserve stock for an order.
+type StockRejectedEvent struct {
+	OrderID  string `json:"order_id"`
+	CarID    string `json:"car_id"`
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+// StockReleasedEvent asks CarService to put reserved stock back.
+type StockReleasedEvent struct {
+	OrderID  string `json:"order_id"`
+	CarID    string `json:"car_id"`
+	Quantity int    `json:"quantity"`
+}
@@ -5,12 +5,52 @@ import (
 	"time"
 )
 
+// Order lifecycle statuses.
+const (
+	StatusPending   = "Pending"
+	StatusConfirmed = "Confirmed"
+	StatusPaid      = "Paid"
+	StatusShipped   = "Shipped"
+	StatusDelivered = "Delivered"
+	StatusCancelled = "Cancelled"
+	StatusRefunded  = "Refunded"
+	StatusRejected  = "Rejected"
+)
+
+// transitions lists the statuses reachable from each status.
+// Cancelled, Refunded and Rejected are terminal.
+var transitions = map[string][]string{
+	StatusPending:   {StatusConfirmed, StatusRejected, StatusCancelled},
+	StatusConfirmed: {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusShipped, StatusRefunded},
+	StatusShipped:   {StatusDelivered},
+	StatusDelivered: {StatusRefunded},
+}
+
+// CanTransition reports whether an order may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusChange records when an order entered a status.
+type StatusChange struct {
+	Status string    `json:"status" bson:"status"`
+	At     time.Time `json:"at" bson:"at"`
+}
+
 type Order struct {
-	ID         uuid.UUID `json:"id" bson:"id"`
-	UserID     uuid.UUID `json:"userId" bson:"userId"`
-	CarID      uuid.UUID `json:"carId" bson:"carId"`
-	Quantity   int       `json:"quantity" bson:"quantity"`
-	TotalPrice float64   `json:"price" bson:"price"`
-	Status     string    `json:"status" bson:"status"`
-	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
+	ID         uuid.UUID      `json:"id" bson:"id"`
+	UserID     uuid.UUID      `json:"userId" bson:"userId"`
+	CarID      uuid.UUID      `json:"carId" bson:"carId"`
+	Quantity   int            `json:"quantity" bson:"quantity"`
+	TotalPrice float64        `json:"price" bson:"price"`
+	Status     string         `json:"status" bson:"status"`
+	History    []StatusChange `json:"history" bson:"history"`
+	CreatedAt  time.Time      `json:"createdAt" bson:"createdAt"`
+	UpdatedAt  time.Time      `json:"updatedAt" bson:"updatedAt"`
 }
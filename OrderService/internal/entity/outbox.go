@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// OutboxEvent is a NATS message stored alongside the order change that
+// produced it, and published later by the outbox relay.
+type OutboxEvent struct {
+	ID            uuid.UUID  `json:"id" bson:"id"`
+	Subject       string     `json:"subject" bson:"subject"`
+	Payload       []byte     `json:"payload" bson:"payload"`
+	CreatedAt     time.Time  `json:"createdAt" bson:"createdAt"`
+	Attempts      int        `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt" bson:"nextAttemptAt"`
+	LastError     string     `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	SentAt        *time.Time `json:"sentAt,omitempty" bson:"sentAt"`
+}
+
+// NewOutboxEvent marshals payload as JSON into an event that is due now.
+func NewOutboxEvent(subject string, payload interface{}) (OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	now := time.Now().UTC()
+	return OutboxEvent{
+		ID:            uuid.New(),
+		Subject:       subject,
+		Payload:       data,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"CarStore/OrderService/internal/entity"
+	_interface "CarStore/OrderService/internal/repository/interface"
+	"CarStore/OrderService/pkg/carclient"
+)
+
+// memoryOrderRepo is a concurrency-safe in-memory IOrderRepo; saga events are
+// handled on NATS goroutines. Outbox events go to the shared memoryOutbox.
+type memoryOrderRepo struct {
+	mu     sync.Mutex
+	store  map[uuid.UUID]entity.Order
+	outbox *memoryOutbox
+}
+
+func (m *memoryOrderRepo) Create(ctx context.Context, order *entity.Order, events ...entity.OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store[order.ID] = *order
+	return m.outbox.Add(ctx, events...)
+}
+
+func (m *memoryOrderRepo) Update(ctx context.Context, order *entity.Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.store[order.ID]
+	if !ok || o.Status != order.Status {
+		return _interface.ErrStatusChanged
+	}
+	o.UserID, o.CarID, o.Quantity, o.TotalPrice = order.UserID, order.CarID, order.Quantity, order.TotalPrice
+	o.UpdatedAt = order.UpdatedAt
+	m.store[order.ID] = o
+	return nil
+}
+
+func (m *memoryOrderRepo) GetByID(ctx context.Context, id string) (*entity.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, _interface.ErrInvalidOrderID
+	}
+	o, ok := m.store[uid]
+	if !ok {
+		return nil, _interface.ErrOrderNotFound
+	}
+	return &o, nil
+}
+
+func (m *memoryOrderRepo) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return _interface.ErrInvalidOrderID
+	}
+	if _, ok := m.store[uid]; !ok {
+		return _interface.ErrOrderNotFound
+	}
+	delete(m.store, uid)
+	return nil
+}
+
+func (m *memoryOrderRepo) List(ctx context.Context) ([]*entity.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*entity.Order
+	for _, o := range m.store {
+		o := o
+		list = append(list, &o)
+	}
+	return list, nil
+}
+
+func (m *memoryOrderRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]*entity.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []*entity.Order
+	for _, o := range m.store {
+		if o.UserID == userID {
+			o := o
+			list = append(list, &o)
+		}
+	}
+	return list, nil
+}
+
+func (m *memoryOrderRepo) UpdateStatus(ctx context.Context, id uuid.UUID, from, to string, at time.Time, events ...entity.OutboxEvent) (*entity.Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.store[id]
+	if !ok || o.Status != from {
+		return nil, _interface.ErrStatusChanged
+	}
+	o.Status = to
+	o.History = append(o.History, entity.StatusChange{Status: to, At: at})
+	m.store[id] = o
+	return &o, m.outbox.Add(ctx, events...)
+}
+
+// memoryOutbox is a concurrency-safe in-memory IOutboxRepo for the relay.
+type memoryOutbox struct {
+	mu     sync.Mutex
+	events []*entity.OutboxEvent
+}
+
+func (m *memoryOutbox) Add(ctx context.Context, events ...entity.OutboxEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range events {
+		e := events[i]
+		m.events = append(m.events, &e)
+	}
+	return nil
+}
+
+func (m *memoryOutbox) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*entity.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.events {
+		if e.SentAt == nil && !e.NextAttemptAt.After(now) {
+			e.NextAttemptAt = now.Add(lease)
+			cp := *e
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryOutbox) MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.events {
+		if e.ID == id {
+			e.SentAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *memoryOutbox) MarkFailed(ctx context.Context, id uuid.UUID, attempts int, next time.Time, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.events {
+		if e.ID == id {
+			e.Attempts, e.NextAttemptAt, e.LastError = attempts, next, reason
+		}
+	}
+	return nil
+}
+
+// staticCatalog prices every car at 100 and reports it as in stock, so the
+// saga rather than the catalog check decides the outcome.
+type staticCatalog struct{}
+
+func (staticCatalog) GetCar(ctx context.Context, id string) (*carclient.Car, error) {
+	return &carclient.Car{ID: id, Price: 100, Stock: 100}, nil
+}
@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"log"
+	"sort"
 
 	"github.com/google/uuid"
 	"google.golang.org/protobuf/types/known/timestamppb"
@@ -10,6 +11,8 @@ import (
 	orderpb "CarStore/OrderService/api/pb/order"
 	"CarStore/OrderService/internal/entity"
 	"CarStore/OrderService/internal/usecase"
+	"CarStore/UserService/pkg/apperr"
+	"CarStore/UserService/pkg/auth"
 )
 
 type OrderHandler struct {
@@ -23,49 +26,61 @@ func NewOrderHandler(uc *usecase.OrderUsecase) orderpb.OrderServiceServer {
 
 func (h *OrderHandler) CreateOrder(ctx context.Context, req *orderpb.CreateOrderRequest) (*orderpb.CreateOrderResponse, error) {
 	log.Printf("CreateOrder request: %+v", req)
+	userID, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	carID, err := uuid.Parse(req.CarId)
+	if err != nil {
+		return nil, apperr.Field("car_id", "must be a UUID")
+	}
 	e := &entity.Order{
-		UserID:     uuid.MustParse(req.UserId),
-		CarID:      uuid.MustParse(req.CarId),
-		Quantity:   int(req.Quantity),
-		TotalPrice: req.TotalPrice,
+		UserID:   userID,
+		CarID:    carID,
+		Quantity: int(req.Quantity),
 	}
 	if err := h.uc.Create(ctx, e); err != nil {
 		return nil, err
 	}
-	return &orderpb.CreateOrderResponse{Order: &orderpb.Order{
-		Id:         e.ID.String(),
-		UserId:     e.UserID.String(),
-		CarId:      e.CarID.String(),
-		Quantity:   int32(e.Quantity),
-		TotalPrice: e.TotalPrice,
-		Status:     e.Status,
-		CreatedAt:  timestamppb.New(e.CreatedAt),
-	}}, nil
+	return &orderpb.CreateOrderResponse{Order: orderToPB(e)}, nil
 }
 
 func (h *OrderHandler) GetOrder(ctx context.Context, req *orderpb.GetOrderRequest) (*orderpb.GetOrderResponse, error) {
 	log.Printf("GetOrder request: %+v", req)
-	e, err := h.uc.FindByID(ctx, req.Id)
+	var e *entity.Order
+	var err error
+	if auth.Can(ctx, auth.PermOrdersRead) {
+		e, err = h.uc.FindByID(ctx, req.Id)
+	} else {
+		userID, idErr := callerID(ctx)
+		if idErr != nil {
+			return nil, idErr
+		}
+		e, err = h.uc.FindOwned(ctx, req.Id, userID)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &orderpb.GetOrderResponse{Order: &orderpb.Order{
-		Id:         e.ID.String(),
-		UserId:     e.UserID.String(),
-		CarId:      e.CarID.String(),
-		Quantity:   int32(e.Quantity),
-		TotalPrice: e.TotalPrice,
-		Status:     e.Status,
-		CreatedAt:  timestamppb.New(e.CreatedAt),
-	}}, nil
+	return &orderpb.GetOrderResponse{Order: orderToPB(e)}, nil
 }
 
 func (h *OrderHandler) UpdateOrder(ctx context.Context, req *orderpb.UpdateOrderRequest) (*orderpb.UpdateOrderResponse, error) {
 	log.Printf("UpdateOrder request: %+v", req)
+	if req.Order == nil {
+		return nil, apperr.Field("order", "required")
+	}
+	ids, err := parseIDs(map[string]string{
+		"order.id":      req.Order.Id,
+		"order.user_id": req.Order.UserId,
+		"order.car_id":  req.Order.CarId,
+	})
+	if err != nil {
+		return nil, err
+	}
 	e := &entity.Order{
-		ID:         uuid.MustParse(req.Order.Id),
-		UserID:     uuid.MustParse(req.Order.UserId),
-		CarID:      uuid.MustParse(req.Order.CarId),
+		ID:         ids["order.id"],
+		UserID:     ids["order.user_id"],
+		CarID:      ids["order.car_id"],
 		Quantity:   int(req.Order.Quantity),
 		TotalPrice: req.Order.TotalPrice,
 		Status:     req.Order.Status,
@@ -74,7 +89,7 @@ func (h *OrderHandler) UpdateOrder(ctx context.Context, req *orderpb.UpdateOrder
 	if err := h.uc.Update(ctx, e); err != nil {
 		return nil, err
 	}
-	return &orderpb.UpdateOrderResponse{Order: req.Order}, nil
+	return &orderpb.UpdateOrderResponse{Order: orderToPB(e)}, nil
 }
 
 func (h *OrderHandler) DeleteOrder(ctx context.Context, req *orderpb.DeleteOrderRequest) (*orderpb.DeleteOrderResponse, error) {
@@ -93,15 +108,110 @@ func (h *OrderHandler) ListOrders(ctx context.Context, req *orderpb.ListOrdersRe
 	}
 	res := &orderpb.ListOrdersResponse{}
 	for _, e := range es {
-		res.Orders = append(res.Orders, &orderpb.Order{
-			Id:         e.ID.String(),
-			UserId:     e.UserID.String(),
-			CarId:      e.CarID.String(),
-			Quantity:   int32(e.Quantity),
-			TotalPrice: e.TotalPrice,
-			Status:     e.Status,
-			CreatedAt:  timestamppb.New(e.CreatedAt),
-		})
+		res.Orders = append(res.Orders, orderToPB(e))
+	}
+	return res, nil
+}
+
+func (h *OrderHandler) ListMyOrders(ctx context.Context, req *orderpb.ListMyOrdersRequest) (*orderpb.ListOrdersResponse, error) {
+	log.Printf("ListMyOrders request: %+v", req)
+	userID, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	es, err := h.uc.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := &orderpb.ListOrdersResponse{}
+	for _, e := range es {
+		res.Orders = append(res.Orders, orderToPB(e))
 	}
 	return res, nil
 }
+
+func (h *OrderHandler) MarkPaid(ctx context.Context, req *orderpb.MarkPaidRequest) (*orderpb.OrderStatusResponse, error) {
+	log.Printf("MarkPaid request: %+v", req)
+	return statusResponse(h.uc.MarkPaid(ctx, req.Id))
+}
+
+func (h *OrderHandler) MarkShipped(ctx context.Context, req *orderpb.MarkShippedRequest) (*orderpb.OrderStatusResponse, error) {
+	log.Printf("MarkShipped request: %+v", req)
+	return statusResponse(h.uc.MarkShipped(ctx, req.Id))
+}
+
+func (h *OrderHandler) MarkDelivered(ctx context.Context, req *orderpb.MarkDeliveredRequest) (*orderpb.OrderStatusResponse, error) {
+	log.Printf("MarkDelivered request: %+v", req)
+	return statusResponse(h.uc.MarkDelivered(ctx, req.Id))
+}
+
+func (h *OrderHandler) CancelOrder(ctx context.Context, req *orderpb.CancelOrderRequest) (*orderpb.OrderStatusResponse, error) {
+	log.Printf("CancelOrder request: %+v", req)
+	if auth.Can(ctx, auth.PermOrdersCancel) {
+		return statusResponse(h.uc.Cancel(ctx, req.Id))
+	}
+	userID, err := callerID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return statusResponse(h.uc.CancelOwned(ctx, req.Id, userID))
+}
+
+func (h *OrderHandler) RefundOrder(ctx context.Context, req *orderpb.RefundOrderRequest) (*orderpb.OrderStatusResponse, error) {
+	log.Printf("RefundOrder request: %+v", req)
+	return statusResponse(h.uc.Refund(ctx, req.Id))
+}
+
+func statusResponse(e *entity.Order, err error) (*orderpb.OrderStatusResponse, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &orderpb.OrderStatusResponse{Order: orderToPB(e)}, nil
+}
+
+// parseIDs parses the UUID fields of a request, reporting every malformed
+// one as a field violation.
+func parseIDs(fields map[string]string) (map[string]uuid.UUID, error) {
+	ids := make(map[string]uuid.UUID, len(fields))
+	invalid := &apperr.Error{Kind: apperr.InvalidArgument, Message: "invalid ids"}
+	for name, value := range fields {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			invalid.Fields = append(invalid.Fields, apperr.FieldViolation{Field: name, Description: "must be a UUID"})
+			continue
+		}
+		ids[name] = id
+	}
+	if len(invalid.Fields) > 0 {
+		sort.Slice(invalid.Fields, func(i, j int) bool { return invalid.Fields[i].Field < invalid.Fields[j].Field })
+		return nil, invalid
+	}
+	return ids, nil
+}
+
+// callerID is the user the request's access token was issued to.
+func callerID(ctx context.Context) (uuid.UUID, error) {
+	uid, _ := auth.FromContext(ctx)
+	id, err := uuid.Parse(uid)
+	if err != nil {
+		return uuid.Nil, apperr.New(apperr.Unauthenticated, "missing user in token")
+	}
+	return id, nil
+}
+
+func orderToPB(e *entity.Order) *orderpb.Order {
+	o := &orderpb.Order{
+		Id:         e.ID.String(),
+		UserId:     e.UserID.String(),
+		CarId:      e.CarID.String(),
+		Quantity:   int32(e.Quantity),
+		TotalPrice: e.TotalPrice,
+		Status:     e.Status,
+		CreatedAt:  timestamppb.New(e.CreatedAt),
+		UpdatedAt:  timestamppb.New(e.UpdatedAt),
+	}
+	for _, c := range e.History {
+		o.History = append(o.History, &orderpb.StatusChange{Status: c.Status, At: timestamppb.New(c.At)})
+	}
+	return o
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	orderpb "CarStore/OrderService/api/pb/order"
+	"CarStore/OrderService/internal/entity"
+	"CarStore/OrderService/internal/usecase"
+	"CarStore/UserService/pkg/auth"
+	"CarStore/UserService/pkg/grpcserver/grpctest"
+	"CarStore/UserService/pkg/jwt"
+)
+
+// FuzzOrderHandler checks that no request, however malformed, panics a
+// handler. Calls alternate between a customer and an admin so that both
+// sides of the ownership checks run. Run with:
+// go test -fuzz FuzzOrderHandler ./OrderService/internal/handler
+func FuzzOrderHandler(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	customer := &jwt.Claims{UserID: uuid.NewString(), Role: "user", Permissions: []string{
+		auth.PermOrdersCreate, auth.PermOrdersReadOwn, auth.PermOrdersCancelOwn,
+	}}
+	admin := &jwt.Claims{UserID: uuid.NewString(), Role: "admin", Permissions: auth.AllPermissions}
+	callers := []*jwt.Claims{customer, admin}
+	calls := 0
+	newCtx := func() context.Context {
+		calls++
+		return auth.NewContext(context.Background(), callers[calls%len(callers)])
+	}
+
+	orderID := uuid.New()
+	repo := &memoryOrderRepo{store: map[uuid.UUID]entity.Order{
+		orderID: {
+			ID: orderID, UserID: uuid.MustParse(customer.UserID), CarID: uuid.New(),
+			Quantity: 1, TotalPrice: 100, Status: entity.StatusPending, CreatedAt: time.Now(),
+		},
+	}, outbox: &memoryOutbox{}}
+	h := NewOrderHandler(usecase.NewOrderUsecase(repo, repo.outbox, staticCatalog{}))
+	desc := orderpb.OrderService_ServiceDesc
+
+	id := orderID.String()
+	grpctest.Seed(f, desc, "CreateOrder", &orderpb.CreateOrderRequest{CarId: uuid.NewString(), Quantity: -1})
+	grpctest.Seed(f, desc, "GetOrder", &orderpb.GetOrderRequest{Id: id})
+	grpctest.Seed(f, desc, "UpdateOrder", &orderpb.UpdateOrderRequest{Order: &orderpb.Order{Id: id, UserId: "x", CarId: ""}})
+	grpctest.Seed(f, desc, "CancelOrder", &orderpb.CancelOrderRequest{Id: id})
+	grpctest.Seed(f, desc, "RefundOrder", &orderpb.RefundOrderRequest{Id: "not-a-uuid"})
+
+	grpctest.FuzzUnary(f, desc, h, newCtx)
+}
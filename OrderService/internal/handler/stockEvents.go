@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"CarStore/OrderService/internal/entity"
+	"CarStore/OrderService/internal/usecase"
+	"CarStore/UserService/pkg/stream"
+)
+
+// StockEventHandler is OrderService's side of the order saga: it confirms
+// or rejects pending orders as CarService answers their stock reservations.
+type StockEventHandler struct {
+	uc *usecase.OrderUsecase
+	js jetstream.JetStream
+}
+
+func NewStockEventHandler(uc *usecase.OrderUsecase, js jetstream.JetStream) *StockEventHandler {
+	return &StockEventHandler{uc: uc, js: js}
+}
+
+// Subscribe starts the durable saga consumers; they stop when ctx is done.
+func (h *StockEventHandler) Subscribe(ctx context.Context) error {
+	if err := stream.Consume(ctx, h.js, stream.Consumer{
+		Stream:  stream.StreamStock,
+		Durable: "order-service-stock-reserved",
+		Subject: entity.SubjectStockReserved,
+	}, h.onStockReserved); err != nil {
+		return err
+	}
+	return stream.Consume(ctx, h.js, stream.Consumer{
+		Stream:  stream.StreamStock,
+		Durable: "order-service-stock-rejected",
+		Subject: entity.SubjectStockRejected,
+	}, h.onStockRejected)
+}
+
+func (h *StockEventHandler) onStockReserved(ctx context.Context, m jetstream.Msg) error {
+	var evt entity.StockReservedEvent
+	if err := json.Unmarshal(m.Data(), &evt); err != nil {
+		return stream.Permanent(err)
+	}
+	return h.uc.HandleStockReserved(ctx, evt)
+}
+
+func (h *StockEventHandler) onStockRejected(ctx context.Context, m jetstream.Msg) error {
+	var evt entity.StockRejectedEvent
+	if err := json.Unmarshal(m.Data(), &evt); err != nil {
+		return stream.Permanent(err)
+	}
+	return h.uc.HandleStockRejected(ctx, evt)
+}
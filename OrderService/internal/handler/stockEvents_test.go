@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"CarStore/OrderService/internal/entity"
+	"CarStore/OrderService/internal/outbox"
+	"CarStore/OrderService/internal/usecase"
+	"CarStore/UserService/pkg/stream"
+)
+
+// carServiceStub plays CarService's part of the stock saga for this
+// OrderService contract test: it reserves stock once per order on
+// order.created, answers with stock.reserved or stock.rejected, and puts a
+// reservation back once on stock.released, ignoring releases of orders it
+// reserved nothing for. Plain subscriptions still see stream subjects, and
+// its replies are captured by the STOCK stream. CarService's own handler,
+// which cannot be imported from here, is held to the same events by
+// TestStockEventHandler in CarService.
+type carServiceStub struct {
+	mu       sync.Mutex
+	stock    map[string]int
+	reserved map[string]entity.OrderCreatedEvent // by order id
+	nc       *nats.Conn
+}
+
+func (c *carServiceStub) subscribe(t *testing.T) {
+	_, err := c.nc.Subscribe(entity.SubjectOrderCreated, func(m *nats.Msg) {
+		var evt entity.OrderCreatedEvent
+		require.NoError(t, json.Unmarshal(m.Data, &evt))
+		c.mu.Lock()
+		_, ok := c.reserved[evt.OrderID]
+		if !ok && c.stock[evt.CarID] >= evt.Quantity {
+			c.stock[evt.CarID] -= evt.Quantity
+			c.reserved[evt.OrderID] = evt
+			ok = true
+		}
+		c.mu.Unlock()
+		if ok {
+			data, _ := json.Marshal(entity.StockReservedEvent{OrderID: evt.OrderID, CarID: evt.CarID, Quantity: evt.Quantity})
+			c.nc.Publish(entity.SubjectStockReserved, data)
+			return
+		}
+		data, _ := json.Marshal(entity.StockRejectedEvent{OrderID: evt.OrderID, CarID: evt.CarID, Quantity: evt.Quantity, Reason: "insufficient stock"})
+		c.nc.Publish(entity.SubjectStockRejected, data)
+	})
+	require.NoError(t, err)
+	_, err = c.nc.Subscribe(entity.SubjectStockReleased, func(m *nats.Msg) {
+		var evt entity.StockReleasedEvent
+		require.NoError(t, json.Unmarshal(m.Data, &evt))
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if r, ok := c.reserved[evt.OrderID]; ok {
+			c.stock[r.CarID] += r.Quantity
+			delete(c.reserved, evt.OrderID)
+		}
+	})
+	require.NoError(t, err)
+}
+
+func (c *carServiceStub) get(carID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stock[carID]
+}
+
+func runNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	require.NoError(t, err)
+	go ns.Start()
+	require.True(t, ns.ReadyForConnections(5*time.Second), "nats server not ready")
+	t.Cleanup(ns.Shutdown)
+
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// TestStockSagaContract runs OrderService's side of the saga, from the
+// outbox through JetStream to the order status, against carServiceStub.
+func TestStockSagaContract(t *testing.T) {
+	ctx := context.Background()
+	nc := runNATS(t)
+
+	carID := uuid.New()
+	cars := &carServiceStub{stock: map[string]int{carID.String(): 1}, reserved: make(map[string]entity.OrderCreatedEvent), nc: nc}
+	cars.subscribe(t)
+
+	box := &memoryOutbox{}
+	repo := &memoryOrderRepo{store: make(map[uuid.UUID]entity.Order), outbox: box}
+	uc := usecase.NewOrderUsecase(repo, box, staticCatalog{})
+	js, err := jetstream.New(nc)
+	require.NoError(t, err)
+	require.NoError(t, stream.EnsureStreams(ctx, js))
+
+	runCtx, stop := context.WithCancel(ctx)
+	t.Cleanup(stop)
+	require.NoError(t, NewStockEventHandler(uc, js).Subscribe(runCtx))
+	require.NoError(t, nc.Flush())
+	go outbox.NewRelay(box, outbox.NewJetStreamPublisher(js)).Run(runCtx)
+
+	waitFor := func(id uuid.UUID, want string) {
+		t.Helper()
+		assert.Eventually(t, func() bool {
+			o, err := uc.FindByID(ctx, id.String())
+			return err == nil && o.Status == want
+		}, 5*time.Second, 10*time.Millisecond, "order %s never became %s", id, want)
+	}
+
+	// First order takes the only car and is confirmed
+	o1 := &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 1}
+	require.NoError(t, uc.Create(ctx, o1))
+	waitFor(o1.ID, entity.StatusConfirmed)
+	assert.Equal(t, 0, cars.get(carID.String()))
+
+	// Second order finds no stock and is rejected
+	o2 := &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 1}
+	require.NoError(t, uc.Create(ctx, o2))
+	waitFor(o2.ID, entity.StatusRejected)
+
+	// Cancelling the first order compensates the reservation
+	_, err = uc.Cancel(ctx, o1.ID.String())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return cars.get(carID.String()) == 1 },
+		5*time.Second, 10*time.Millisecond, "stock was not released")
+}
@@ -0,0 +1,109 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	_interface "CarStore/OrderService/internal/repository/interface"
+)
+
+const (
+	pollInterval = time.Second
+	claimLease   = 30 * time.Second
+	baseBackoff  = time.Second
+	maxBackoff   = 5 * time.Minute
+)
+
+// Publisher delivers one outbox event. msgID is the outbox event id, so a
+// publish retried after a lost acknowledgement can be deduplicated.
+type Publisher interface {
+	Publish(ctx context.Context, subject, msgID string, data []byte) error
+}
+
+// JetStreamPublisher publishes to JetStream, using the outbox event id as
+// the Nats-Msg-Id header.
+type JetStreamPublisher struct {
+	js jetstream.JetStream
+}
+
+func NewJetStreamPublisher(js jetstream.JetStream) *JetStreamPublisher {
+	return &JetStreamPublisher{js: js}
+}
+
+func (p *JetStreamPublisher) Publish(ctx context.Context, subject, msgID string, data []byte) error {
+	_, err := p.js.Publish(ctx, subject, data, jetstream.WithMsgID(msgID))
+	return err
+}
+
+// Relay publishes pending outbox events, retrying failures with exponential
+// backoff. Events are marked sent only after a successful publish, so
+// delivery is at-least-once.
+type Relay struct {
+	repo _interface.IOutboxRepo
+	pub  Publisher
+	now  func() time.Time
+}
+
+func NewRelay(repo _interface.IOutboxRepo, pub Publisher) *Relay {
+	return &Relay{repo: repo, pub: pub, now: func() time.Time { return time.Now().UTC() }}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.Drain(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("outbox relay: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain publishes every event that is currently due.
+func (r *Relay) Drain(ctx context.Context) error {
+	for ctx.Err() == nil {
+		evt, err := r.repo.ClaimDue(ctx, r.now(), claimLease)
+		if err != nil {
+			return err
+		}
+		if evt == nil {
+			return nil
+		}
+
+		if err := r.pub.Publish(ctx, evt.Subject, evt.ID.String(), evt.Payload); err != nil {
+			attempts := evt.Attempts + 1
+			next := r.now().Add(backoff(attempts))
+			log.Printf("outbox relay: publish %s (%s) attempt %d failed, retry at %s: %v",
+				evt.Subject, evt.ID, attempts, next.Format(time.RFC3339), err)
+			if err := r.repo.MarkFailed(ctx, evt.ID, attempts, next, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.repo.MarkSent(ctx, evt.ID, r.now()); err != nil {
+			return err
+		}
+		log.Printf("published %s (%s)", evt.Subject, evt.ID)
+	}
+	return ctx.Err()
+}
+
+// backoff doubles the retry delay per attempt, capped at maxBackoff.
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
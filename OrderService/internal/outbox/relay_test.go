@@ -0,0 +1,112 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"CarStore/OrderService/internal/entity"
+)
+
+// memoryOutbox is an in-memory IOutboxRepo for testing.
+type memoryOutbox struct {
+	events []*entity.OutboxEvent
+}
+
+func (m *memoryOutbox) Add(ctx context.Context, events ...entity.OutboxEvent) error {
+	for i := range events {
+		e := events[i]
+		m.events = append(m.events, &e)
+	}
+	return nil
+}
+
+func (m *memoryOutbox) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*entity.OutboxEvent, error) {
+	for _, e := range m.events {
+		if e.SentAt == nil && !e.NextAttemptAt.After(now) {
+			e.NextAttemptAt = now.Add(lease)
+			cp := *e
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryOutbox) MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	for _, e := range m.events {
+		if e.ID == id {
+			e.SentAt = &at
+		}
+	}
+	return nil
+}
+
+func (m *memoryOutbox) MarkFailed(ctx context.Context, id uuid.UUID, attempts int, next time.Time, reason string) error {
+	for _, e := range m.events {
+		if e.ID == id {
+			e.Attempts, e.NextAttemptAt, e.LastError = attempts, next, reason
+		}
+	}
+	return nil
+}
+
+// flakyPublisher fails the first n publishes.
+type flakyPublisher struct {
+	failures int
+	sent     []string
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, subject, msgID string, data []byte) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("nats unavailable")
+	}
+	p.sent = append(p.sent, subject)
+	return nil
+}
+
+func TestRelay_RetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := &memoryOutbox{}
+	pub := &flakyPublisher{failures: 2}
+	r := NewRelay(repo, pub)
+	r.now = func() time.Time { return now }
+
+	evt, err := entity.NewOutboxEvent(entity.SubjectOrderCreated, entity.OrderCreatedEvent{OrderID: "o1"})
+	assert.NoError(t, err)
+	evt.NextAttemptAt = now
+	assert.NoError(t, repo.Add(ctx, evt))
+
+	// First attempt fails and is scheduled one second later
+	assert.NoError(t, r.Drain(ctx))
+	assert.Equal(t, 1, repo.events[0].Attempts)
+	assert.Equal(t, now.Add(time.Second), repo.events[0].NextAttemptAt)
+	assert.Equal(t, "nats unavailable", repo.events[0].LastError)
+
+	// Not due yet
+	assert.NoError(t, r.Drain(ctx))
+	assert.Equal(t, 1, repo.events[0].Attempts)
+
+	// Second attempt fails, backoff doubles
+	now = now.Add(time.Second)
+	assert.NoError(t, r.Drain(ctx))
+	assert.Equal(t, 2, repo.events[0].Attempts)
+	assert.Equal(t, now.Add(2*time.Second), repo.events[0].NextAttemptAt)
+
+	// Third attempt succeeds
+	now = now.Add(2 * time.Second)
+	assert.NoError(t, r.Drain(ctx))
+	assert.Equal(t, []string{entity.SubjectOrderCreated}, pub.sent)
+	assert.NotNil(t, repo.events[0].SentAt)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, backoff(1))
+	assert.Equal(t, 4*time.Second, backoff(3))
+	assert.Equal(t, maxBackoff, backoff(20))
+}
@@ -2,13 +2,33 @@ package _interface
 
 import (
 	"CarStore/OrderService/internal/entity"
+	"CarStore/UserService/pkg/apperr"
 	"context"
+	"github.com/google/uuid"
+	"time"
 )
 
+// ErrOrderNotFound is returned by GetByID and Delete for unknown orders.
+var ErrOrderNotFound = apperr.New(apperr.NotFound, "order not found")
+
+// ErrInvalidOrderID is returned for ids that are not UUIDs.
+var ErrInvalidOrderID = apperr.Field("id", "must be a UUID")
+
+// ErrStatusChanged is returned by Update and UpdateStatus when the order is
+// no longer in the expected status, e.g. because a concurrent transition won.
+var ErrStatusChanged = apperr.New(apperr.Conflict, "order status changed concurrently")
+
+// Create and UpdateStatus store the given outbox events in the same
+// transaction as the order change.
 type IOrderRepo interface {
-	Create(ctx context.Context, order *entity.Order) error
+	Create(ctx context.Context, order *entity.Order, events ...entity.OutboxEvent) error
+	// Update stores the editable fields of an order that is still in
+	// order.Status; status and history are left alone.
 	Update(ctx context.Context, order *entity.Order) error
 	GetByID(ctx context.Context, id string) (*entity.Order, error)
 	Delete(ctx context.Context, id string) error
 	List(ctx context.Context) ([]*entity.Order, error)
+	// ListByUser returns the user's orders, newest first.
+	ListByUser(ctx context.Context, userID uuid.UUID) ([]*entity.Order, error)
+	UpdateStatus(ctx context.Context, id uuid.UUID, from, to string, at time.Time, events ...entity.OutboxEvent) (*entity.Order, error)
 }
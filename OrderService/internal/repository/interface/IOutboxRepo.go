@@ -0,0 +1,18 @@
+package _interface
+
+import (
+	"CarStore/OrderService/internal/entity"
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+type IOutboxRepo interface {
+	// Add stores events on their own, outside of an order change.
+	Add(ctx context.Context, events ...entity.OutboxEvent) error
+	// ClaimDue leases the oldest unsent event that is due, hiding it from
+	// other relays until the lease expires. It returns nil when none is due.
+	ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*entity.OutboxEvent, error)
+	MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error
+	MarkFailed(ctx context.Context, id uuid.UUID, attempts int, next time.Time, reason string) error
+}
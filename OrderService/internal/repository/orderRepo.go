@@ -4,45 +4,91 @@ import (
 	"CarStore/OrderService/internal/entity"
 	_interface "CarStore/OrderService/internal/repository/interface"
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
 type orderRepo struct {
-	coll *mongo.Collection
+	client *mongo.Client
+	coll   *mongo.Collection
+	outbox *mongo.Collection
 }
 
+// NewOrderRepo returns a Mongo-backed order repository. Writes that carry
+// outbox events run in a transaction, so the deployment must be a replica set.
 func NewOrderRepo(db *mongo.Database) _interface.IOrderRepo {
-	return &orderRepo{coll: db.Collection("orders")}
+	return &orderRepo{
+		client: db.Client(),
+		coll:   db.Collection("orders"),
+		outbox: db.Collection(outboxCollection),
+	}
 }
 
-func (o orderRepo) Create(ctx context.Context, order *entity.Order) error {
+func (o orderRepo) Create(ctx context.Context, order *entity.Order, events ...entity.OutboxEvent) error {
 	if order.ID == uuid.Nil {
 		order.ID = uuid.New()
 	}
-	order.CreatedAt = time.Now()
-	_, err := o.coll.InsertOne(ctx, order)
-	return err
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = time.Now()
+	}
+	return o.withEvents(ctx, events, func(ctx context.Context) error {
+		_, err := o.coll.InsertOne(ctx, order)
+		return err
+	})
 }
 
+// Update sets the editable fields only, and only while the order is still
+// in order.Status, so that a concurrent transition is never overwritten.
 func (o orderRepo) Update(ctx context.Context, order *entity.Order) error {
-	_, err := o.coll.ReplaceOne(ctx, bson.M{"id": order.ID}, order)
-	return err
+	res, err := o.coll.UpdateOne(ctx,
+		bson.M{"id": order.ID, "status": order.Status},
+		bson.M{"$set": bson.M{
+			"userId":    order.UserID,
+			"carId":     order.CarID,
+			"quantity":  order.Quantity,
+			"price":     order.TotalPrice,
+			"updatedAt": order.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return _interface.ErrStatusChanged
+	}
+	return nil
 }
 
 func (o orderRepo) GetByID(ctx context.Context, id string) (*entity.Order, error) {
 	var order entity.Order
-	uid, _ := uuid.Parse(id)
-	err := o.coll.FindOne(ctx, bson.M{"id": uid}).Decode(&order)
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, _interface.ErrInvalidOrderID
+	}
+	err = o.coll.FindOne(ctx, bson.M{"id": uid}).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, _interface.ErrOrderNotFound
+	}
 	return &order, err
 }
 
 func (o orderRepo) Delete(ctx context.Context, id string) error {
-	uid, _ := uuid.Parse(id)
-	_, err := o.coll.DeleteOne(ctx, bson.M{"id": uid})
-	return err
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return _interface.ErrInvalidOrderID
+	}
+	res, err := o.coll.DeleteOne(ctx, bson.M{"id": uid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return _interface.ErrOrderNotFound
+	}
+	return nil
 }
 
 func (o orderRepo) List(ctx context.Context) ([]*entity.Order, error) {
@@ -62,3 +108,66 @@ func (o orderRepo) List(ctx context.Context) ([]*entity.Order, error) {
 	}
 	return orders, nil
 }
+
+func (o orderRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]*entity.Order, error) {
+	cursor, err := o.coll.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []*entity.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (o orderRepo) UpdateStatus(ctx context.Context, id uuid.UUID, from, to string, at time.Time, events ...entity.OutboxEvent) (*entity.Order, error) {
+	var updated entity.Order
+	err := o.withEvents(ctx, events, func(ctx context.Context) error {
+		res := o.coll.FindOneAndUpdate(ctx,
+			bson.M{"id": id, "status": from},
+			bson.M{
+				"$set":  bson.M{"status": to, "updatedAt": at},
+				"$push": bson.M{"history": entity.StatusChange{Status: to, At: at}},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		)
+		err := res.Decode(&updated)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return _interface.ErrStatusChanged
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// withEvents runs write and inserts events into the outbox atomically.
+// Without events the write runs on its own.
+func (o orderRepo) withEvents(ctx context.Context, events []entity.OutboxEvent, write func(ctx context.Context) error) error {
+	if len(events) == 0 {
+		return write(ctx)
+	}
+	session, err := o.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if err := write(sc); err != nil {
+			return nil, err
+		}
+		docs := make([]interface{}, len(events))
+		for i := range events {
+			docs[i] = events[i]
+		}
+		_, err := o.outbox.InsertMany(sc, docs)
+		return nil, err
+	})
+	return err
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"CarStore/OrderService/internal/entity"
+	_interface "CarStore/OrderService/internal/repository/interface"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const outboxCollection = "outbox"
+
+// sentOutboxTTL is how long published events are kept for inspection
+// before MongoDB deletes them. Unsent events have no sentAt and are kept.
+const sentOutboxTTL = 7 * 24 * time.Hour
+
+type outboxRepo struct {
+	coll *mongo.Collection
+}
+
+func NewOutboxRepo(db *mongo.Database) _interface.IOutboxRepo {
+	return &outboxRepo{coll: db.Collection(outboxCollection)}
+}
+
+// EnsureOutboxIndexes creates the index the relay polls on and the TTL
+// index that prunes sent events. It is safe to call on every startup.
+func EnsureOutboxIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection(outboxCollection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "sentAt", Value: 1}, {Key: "nextAttemptAt", Value: 1}}},
+		{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "sentAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(sentOutboxTTL.Seconds()))},
+	})
+	return err
+}
+
+func (r outboxRepo) Add(ctx context.Context, events ...entity.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(events))
+	for i := range events {
+		docs[i] = events[i]
+	}
+	_, err := r.coll.InsertMany(ctx, docs)
+	return err
+}
+
+func (r outboxRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*entity.OutboxEvent, error) {
+	res := r.coll.FindOneAndUpdate(ctx,
+		bson.M{"sentAt": nil, "nextAttemptAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextAttemptAt": now.Add(lease)}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+			SetReturnDocument(options.After),
+	)
+	var evt entity.OutboxEvent
+	if err := res.Decode(&evt); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &evt, nil
+}
+
+func (r outboxRepo) MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	_, err := r.coll.UpdateOne(ctx,
+		bson.M{"id": id},
+		bson.M{"$set": bson.M{"sentAt": at}},
+	)
+	return err
+}
+
+func (r outboxRepo) MarkFailed(ctx context.Context, id uuid.UUID, attempts int, next time.Time, reason string) error {
+	_, err := r.coll.UpdateOne(ctx,
+		bson.M{"id": id},
+		bson.M{"$set": bson.M{"attempts": attempts, "nextAttemptAt": next, "lastError": reason}},
+	)
+	return err
+}
@@ -3,54 +3,123 @@ package usecase
 import (
 	"CarStore/OrderService/internal/entity"
 	_interface "CarStore/OrderService/internal/repository/interface"
+	"CarStore/OrderService/pkg/carclient"
+	"CarStore/UserService/pkg/apperr"
 	"context"
-	"encoding/json"
-	"github.com/nats-io/nats.go"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
 	"log"
+	"math"
+	"strings"
 	"time"
 )
 
+var (
+	// ErrInvalidTransition is returned when an order cannot move to the
+	// requested status from its current one.
+	ErrInvalidTransition = apperr.New(apperr.FailedPrecondition, "invalid order status transition")
+	ErrInvalidQuantity   = apperr.Field("quantity", "must be positive")
+	ErrCarNotFound       = apperr.New(apperr.NotFound, "car not found")
+	ErrOutOfStock        = apperr.New(apperr.FailedPrecondition, "not enough cars in stock")
+	// ErrItemFixed is returned for updates to the car or quantity of an
+	// order, whose stock may already be reserved.
+	ErrItemFixed     = apperr.New(apperr.FailedPrecondition, "car and quantity cannot change once the order is placed; cancel it and order again")
+	ErrOrderNotFound = _interface.ErrOrderNotFound
+	// ErrStatusChanged is returned when a concurrent transition won; the
+	// caller may re-read the order and retry.
+	ErrStatusChanged = _interface.ErrStatusChanged
+)
+
+// CarCatalog looks up the current price and stock of a car.
+type CarCatalog interface {
+	GetCar(ctx context.Context, id string) (*carclient.Car, error)
+}
+
+// OrderUsecase never publishes to NATS directly: events are written to the
+// outbox together with the order change and relayed by outbox.Relay.
 type OrderUsecase struct {
-	repo _interface.IOrderRepo
-	nc   *nats.Conn
+	repo   _interface.IOrderRepo
+	outbox _interface.IOutboxRepo
+	cars   CarCatalog
 }
 
-func NewOrderUsecase(r _interface.IOrderRepo, nc *nats.Conn) *OrderUsecase {
-	return &OrderUsecase{repo: r, nc: nc}
+func NewOrderUsecase(r _interface.IOrderRepo, outbox _interface.IOutboxRepo, cars CarCatalog) *OrderUsecase {
+	return &OrderUsecase{repo: r, outbox: outbox, cars: cars}
 }
 
+// Create prices the order from the car catalog and stores it as Pending.
+// Any TotalPrice set by the caller is overwritten.
 func (o *OrderUsecase) Create(ctx context.Context, order *entity.Order) error {
-	if err := o.repo.Create(ctx, order); err != nil {
+	total, err := o.price(ctx, order)
+	if err != nil {
 		return err
 	}
+	order.TotalPrice = total
+
+	now := time.Now().UTC()
+	order.Status = entity.StatusPending
+	order.CreatedAt = now
+	order.UpdatedAt = now
+	order.History = []entity.StatusChange{{Status: entity.StatusPending, At: now}}
+	if order.ID == uuid.Nil {
+		order.ID = uuid.New()
+	}
 
-	// publish
-	evt := struct {
-		OrderID   string    `json:"order_id"`
-		CarID     string    `json:"car_id"`
-		Quantity  int       `json:"quantity"`
-		CreatedAt time.Time `json:"created_at"`
-	}{
+	created, err := entity.NewOutboxEvent(entity.SubjectOrderCreated, entity.OrderCreatedEvent{
 		OrderID:   order.ID.String(),
 		CarID:     order.CarID.String(),
 		Quantity:  order.Quantity,
 		CreatedAt: order.CreatedAt,
+	})
+	if err != nil {
+		return err
 	}
-	data, _ := json.Marshal(evt)
-	if err := o.nc.Publish("order.created", data); err != nil {
-		log.Printf("warning: failed to publish order.created: %v", err)
-	} else {
-		log.Printf("published order.created for order %s", order.ID)
-	}
-
-	return nil
+	return o.repo.Create(ctx, order, created)
 }
 
 func (o *OrderUsecase) FindByID(ctx context.Context, id string) (*entity.Order, error) {
 	return o.repo.GetByID(ctx, id)
 }
 
+// FindOwned returns the order if it belongs to userID. Other users' orders
+// are reported as not found, so their ids cannot be probed.
+func (o *OrderUsecase) FindOwned(ctx context.Context, id string, userID uuid.UUID) (*entity.Order, error) {
+	order, err := o.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+func (o *OrderUsecase) ListByUser(ctx context.Context, userID uuid.UUID) ([]*entity.Order, error) {
+	return o.repo.ListByUser(ctx, userID)
+}
+
+// Update replaces the editable fields of an order. The lifecycle fields are
+// kept from the stored order; status changes go through the transition methods.
+// The car and quantity cannot change: order.created went out with them when
+// the order was placed, so stock may be reserved, and released later, for
+// them even while the order is Pending.
 func (o *OrderUsecase) Update(ctx context.Context, order *entity.Order) error {
+	existing, err := o.repo.GetByID(ctx, order.ID.String())
+	if err != nil {
+		return err
+	}
+	if order.Status != "" && order.Status != existing.Status {
+		return fmt.Errorf("%w: status cannot be changed by update", ErrInvalidTransition)
+	}
+	if order.CarID != existing.CarID || order.Quantity != existing.Quantity {
+		return ErrItemFixed
+	}
+	order.TotalPrice = existing.TotalPrice
+	order.Status = existing.Status
+	order.History = existing.History
+	order.CreatedAt = existing.CreatedAt
+	order.UpdatedAt = time.Now().UTC()
 	return o.repo.Update(ctx, order)
 }
 
@@ -61,3 +130,176 @@ func (o *OrderUsecase) Delete(ctx context.Context, id string) error {
 func (o *OrderUsecase) List(ctx context.Context) ([]*entity.Order, error) {
 	return o.repo.List(ctx)
 }
+
+// confirm is only called for stock.reserved events: a confirmed order must
+// have stock reserved, as cancelling it releases that stock.
+func (o *OrderUsecase) confirm(ctx context.Context, id string) (*entity.Order, error) {
+	return o.transition(ctx, id, entity.StatusConfirmed)
+}
+
+func (o *OrderUsecase) Reject(ctx context.Context, id string) (*entity.Order, error) {
+	return o.transition(ctx, id, entity.StatusRejected)
+}
+
+func (o *OrderUsecase) MarkPaid(ctx context.Context, id string) (*entity.Order, error) {
+	return o.transition(ctx, id, entity.StatusPaid)
+}
+
+func (o *OrderUsecase) MarkShipped(ctx context.Context, id string) (*entity.Order, error) {
+	return o.transition(ctx, id, entity.StatusShipped)
+}
+
+func (o *OrderUsecase) MarkDelivered(ctx context.Context, id string) (*entity.Order, error) {
+	return o.transition(ctx, id, entity.StatusDelivered)
+}
+
+// Cancel cancels an order. If its stock had already been reserved, a
+// compensating stock.released event is published.
+func (o *OrderUsecase) Cancel(ctx context.Context, id string) (*entity.Order, error) {
+	return o.transition(ctx, id, entity.StatusCancelled)
+}
+
+// CancelOwned cancels an order on behalf of its owner.
+func (o *OrderUsecase) CancelOwned(ctx context.Context, id string, userID uuid.UUID) (*entity.Order, error) {
+	if _, err := o.FindOwned(ctx, id, userID); err != nil {
+		return nil, err
+	}
+	return o.Cancel(ctx, id)
+}
+
+// Refund refunds a paid or delivered order. Refunding an order that was not
+// shipped yet publishes a stock.released event.
+func (o *OrderUsecase) Refund(ctx context.Context, id string) (*entity.Order, error) {
+	return o.transition(ctx, id, entity.StatusRefunded)
+}
+
+// HandleStockReserved confirms the order CarService reserved stock for.
+// If the order was cancelled, rejected or deleted in the meantime the
+// reservation is released again; duplicate deliveries for confirmed orders
+// are ignored.
+func (o *OrderUsecase) HandleStockReserved(ctx context.Context, evt entity.StockReservedEvent) error {
+	_, err := o.confirm(ctx, evt.OrderID)
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		log.Printf("releasing stock reserved for missing order %s", evt.OrderID)
+		return o.release(ctx, entity.StockReleasedEvent{OrderID: evt.OrderID, CarID: evt.CarID, Quantity: evt.Quantity})
+	case !isStale(err):
+		return err
+	}
+	// A deletion racing this read fails the delivery; its redelivery
+	// takes the branch above.
+	order, err := o.repo.GetByID(ctx, evt.OrderID)
+	if err != nil {
+		return err
+	}
+	if order.Status == entity.StatusCancelled || order.Status == entity.StatusRejected {
+		return o.release(ctx, releasedFor(order))
+	}
+	return nil
+}
+
+// release publishes a compensating stock.released event on its own.
+func (o *OrderUsecase) release(ctx context.Context, evt entity.StockReleasedEvent) error {
+	release, err := entity.NewOutboxEvent(entity.SubjectStockReleased, evt)
+	if err != nil {
+		return err
+	}
+	return o.outbox.Add(ctx, release)
+}
+
+// HandleStockRejected rejects the order CarService could not reserve stock for.
+func (o *OrderUsecase) HandleStockRejected(ctx context.Context, evt entity.StockRejectedEvent) error {
+	_, err := o.Reject(ctx, evt.OrderID)
+	if isStale(err) {
+		log.Printf("ignoring stock rejection for order %s: %v", evt.OrderID, err)
+		return nil
+	}
+	return err
+}
+
+// isStale reports whether a transition failed because the order had already
+// moved on, either before or while it was attempted.
+func isStale(err error) bool {
+	return errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrStatusChanged)
+}
+
+// releasesStock reports whether an order moving from one status to another
+// gives back the stock reserved for it: when a confirmed order is cancelled
+// or a paid one refunded. A delivered car that is refunded is not back on
+// the lot; it is restocked once it has been returned.
+func releasesStock(from, to string) bool {
+	return to == entity.StatusCancelled && from == entity.StatusConfirmed ||
+		to == entity.StatusRefunded && from == entity.StatusPaid
+}
+
+func releasedFor(order *entity.Order) entity.StockReleasedEvent {
+	return entity.StockReleasedEvent{
+		OrderID:  order.ID.String(),
+		CarID:    order.CarID.String(),
+		Quantity: order.Quantity,
+	}
+}
+
+// price computes the order total from the catalog price of its car,
+// rejecting unknown cars and quantities above the available stock.
+func (o *OrderUsecase) price(ctx context.Context, order *entity.Order) (float64, error) {
+	if order.Quantity <= 0 {
+		return 0, ErrInvalidQuantity
+	}
+	car, err := o.cars.GetCar(ctx, order.CarID.String())
+	if errors.Is(err, carclient.ErrCarNotFound) {
+		return 0, ErrCarNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("car lookup: %w", err)
+	}
+	if car.Stock < order.Quantity {
+		return 0, ErrOutOfStock
+	}
+	return math.Round(car.Price*float64(order.Quantity)*100) / 100, nil
+}
+
+// transition moves an order to the given status if the lifecycle allows it,
+// recording an order.<status> event in the outbox. Cancelling an order whose
+// stock was reserved also records the compensating stock.released event.
+func (o *OrderUsecase) transition(ctx context.Context, id, to string) (*entity.Order, error) {
+	order, err := o.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	from := order.Status
+	if !entity.CanTransition(from, to) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, from, to)
+	}
+
+	at := time.Now().UTC()
+	statusEvt, err := entity.NewOutboxEvent("order."+strings.ToLower(to), entity.OrderStatusEvent{
+		OrderID:    order.ID.String(),
+		UserID:     order.UserID.String(),
+		CarID:      order.CarID.String(),
+		Quantity:   order.Quantity,
+		FromStatus: from,
+		Status:     to,
+		At:         at,
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := []entity.OutboxEvent{statusEvt}
+	if releasesStock(from, to) {
+		release, err := entity.NewOutboxEvent(entity.SubjectStockReleased, releasedFor(order))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, release)
+	}
+
+	updated, err := o.repo.UpdateStatus(ctx, order.ID, from, to, at, events...)
+	if errors.Is(err, _interface.ErrStatusChanged) {
+		return nil, fmt.Errorf("%w: order is no longer %s", ErrStatusChanged, from)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
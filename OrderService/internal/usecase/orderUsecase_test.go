@@ -0,0 +1,322 @@
+package usecase
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"CarStore/OrderService/internal/entity"
+	_interface "CarStore/OrderService/internal/repository/interface"
+	"CarStore/OrderService/pkg/carclient"
+)
+
+// memoryOrderRepo is an in-memory implementation of IOrderRepo for testing.
+// Outbox events are appended to the shared memoryOutbox.
+type memoryOrderRepo struct {
+	store  map[uuid.UUID]*entity.Order
+	outbox *memoryOutbox
+}
+
+func newMemoryOrderRepo(outbox *memoryOutbox) *memoryOrderRepo {
+	return &memoryOrderRepo{store: make(map[uuid.UUID]*entity.Order), outbox: outbox}
+}
+
+func (m *memoryOrderRepo) Create(ctx context.Context, order *entity.Order, events ...entity.OutboxEvent) error {
+	if order.ID == uuid.Nil {
+		order.ID = uuid.New()
+	}
+	m.store[order.ID] = order
+	return m.outbox.Add(ctx, events...)
+}
+
+func (m *memoryOrderRepo) Update(ctx context.Context, order *entity.Order) error {
+	stored, ok := m.store[order.ID]
+	if !ok || stored.Status != order.Status {
+		return _interface.ErrStatusChanged
+	}
+	stored.UserID, stored.CarID, stored.Quantity, stored.TotalPrice = order.UserID, order.CarID, order.Quantity, order.TotalPrice
+	stored.UpdatedAt = order.UpdatedAt
+	return nil
+}
+
+func (m *memoryOrderRepo) GetByID(ctx context.Context, id string) (*entity.Order, error) {
+	uID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	order, ok := m.store[uID]
+	if !ok {
+		return nil, _interface.ErrOrderNotFound
+	}
+	cp := *order
+	return &cp, nil
+}
+
+func (m *memoryOrderRepo) Delete(ctx context.Context, id string) error {
+	uID, err := uuid.Parse(id)
+	if err != nil {
+		return err
+	}
+	delete(m.store, uID)
+	return nil
+}
+
+func (m *memoryOrderRepo) List(ctx context.Context) ([]*entity.Order, error) {
+	list := make([]*entity.Order, 0, len(m.store))
+	for _, o := range m.store {
+		list = append(list, o)
+	}
+	return list, nil
+}
+
+func (m *memoryOrderRepo) ListByUser(ctx context.Context, userID uuid.UUID) ([]*entity.Order, error) {
+	var list []*entity.Order
+	for _, o := range m.store {
+		if o.UserID == userID {
+			list = append(list, o)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (m *memoryOrderRepo) UpdateStatus(ctx context.Context, id uuid.UUID, from, to string, at time.Time, events ...entity.OutboxEvent) (*entity.Order, error) {
+	order, ok := m.store[id]
+	if !ok || order.Status != from {
+		return nil, _interface.ErrStatusChanged
+	}
+	order.Status = to
+	order.UpdatedAt = at
+	order.History = append(order.History, entity.StatusChange{Status: to, At: at})
+	cp := *order
+	return &cp, m.outbox.Add(ctx, events...)
+}
+
+// memoryOutbox records outbox events; only Add is used by the usecase.
+type memoryOutbox struct {
+	subjects []string
+}
+
+func (m *memoryOutbox) Add(ctx context.Context, events ...entity.OutboxEvent) error {
+	for _, e := range events {
+		m.subjects = append(m.subjects, e.Subject)
+	}
+	return nil
+}
+
+func (m *memoryOutbox) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (*entity.OutboxEvent, error) {
+	return nil, nil
+}
+
+func (m *memoryOutbox) MarkSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return nil
+}
+
+func (m *memoryOutbox) MarkFailed(ctx context.Context, id uuid.UUID, attempts int, next time.Time, reason string) error {
+	return nil
+}
+
+// fakeCarService serves cars from a map, standing in for the CarService client.
+type fakeCarService struct {
+	cars map[string]*carclient.Car
+}
+
+func newFakeCarService(cars ...*carclient.Car) *fakeCarService {
+	f := &fakeCarService{cars: make(map[string]*carclient.Car)}
+	for _, c := range cars {
+		f.cars[c.ID] = c
+	}
+	return f
+}
+
+func (f *fakeCarService) GetCar(ctx context.Context, id string) (*carclient.Car, error) {
+	c, ok := f.cars[id]
+	if !ok {
+		return nil, carclient.ErrCarNotFound
+	}
+	return c, nil
+}
+
+func TestOrderUsecase_CreatePricing(t *testing.T) {
+	ctx := context.Background()
+	carID := uuid.New()
+	cars := newFakeCarService(&carclient.Car{ID: carID.String(), Price: 19999.99, Stock: 3})
+	outbox := &memoryOutbox{}
+	uc := NewOrderUsecase(newMemoryOrderRepo(outbox), outbox, cars)
+
+	// Client-supplied total is ignored
+	o := &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 2, TotalPrice: 1}
+	assert.NoError(t, uc.Create(ctx, o))
+	assert.Equal(t, 39999.98, o.TotalPrice)
+
+	// Unknown car
+	err := uc.Create(ctx, &entity.Order{UserID: uuid.New(), CarID: uuid.New(), Quantity: 1})
+	assert.ErrorIs(t, err, ErrCarNotFound)
+
+	// Not enough stock
+	err = uc.Create(ctx, &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 4})
+	assert.ErrorIs(t, err, ErrOutOfStock)
+
+	// Non-positive quantity
+	err = uc.Create(ctx, &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 0})
+	assert.ErrorIs(t, err, ErrInvalidQuantity)
+}
+
+func TestOrderUsecase_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	pub := &memoryOutbox{}
+	repo := newMemoryOrderRepo(pub)
+	carID := uuid.New()
+	uc := NewOrderUsecase(repo, pub, newFakeCarService(&carclient.Car{ID: carID.String(), Price: 100, Stock: 1}))
+
+	o := &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 1}
+	assert.NoError(t, uc.Create(ctx, o))
+	assert.Equal(t, entity.StatusPending, o.Status)
+
+	// Skipping a step is rejected
+	_, err := uc.MarkShipped(ctx, o.ID.String())
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	// Happy path
+	steps := []func(context.Context, string) (*entity.Order, error){
+		uc.confirm, uc.MarkPaid, uc.MarkShipped, uc.MarkDelivered, uc.Refund,
+	}
+	for _, step := range steps {
+		_, err := step(ctx, o.ID.String())
+		assert.NoError(t, err)
+	}
+	got, err := uc.FindByID(ctx, o.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, entity.StatusRefunded, got.Status)
+	assert.Len(t, got.History, 6)
+	assert.Equal(t, []string{
+		"order.created", "order.confirmed", "order.paid", "order.shipped", "order.delivered", "order.refunded",
+	}, pub.subjects)
+
+	// Terminal status
+	_, err = uc.Cancel(ctx, o.ID.String())
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+}
+
+func TestOrderUsecase_UpdateKeepsStatus(t *testing.T) {
+	ctx := context.Background()
+	outbox := &memoryOutbox{}
+	repo := newMemoryOrderRepo(outbox)
+	carID := uuid.New()
+	uc := NewOrderUsecase(repo, outbox, newFakeCarService(&carclient.Car{ID: carID.String(), Price: 100, Stock: 2}))
+
+	o := &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 1}
+	assert.NoError(t, uc.Create(ctx, o))
+
+	// Status cannot be overwritten through Update
+	err := uc.Update(ctx, &entity.Order{ID: o.ID, Quantity: 2, Status: entity.StatusDelivered})
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	// Neither can the car or quantity that stock was requested for
+	err = uc.Update(ctx, &entity.Order{ID: o.ID, UserID: o.UserID, CarID: o.CarID, Quantity: 2})
+	assert.ErrorIs(t, err, ErrItemFixed)
+	err = uc.Update(ctx, &entity.Order{ID: o.ID, UserID: o.UserID, CarID: uuid.New(), Quantity: 1})
+	assert.ErrorIs(t, err, ErrItemFixed)
+
+	// Other fields can, and the price and lifecycle are preserved
+	owner := uuid.New()
+	err = uc.Update(ctx, &entity.Order{ID: o.ID, UserID: owner, CarID: o.CarID, Quantity: 1, TotalPrice: 1})
+	assert.NoError(t, err)
+	got, _ := uc.FindByID(ctx, o.ID.String())
+	assert.Equal(t, owner, got.UserID)
+	assert.Equal(t, 100.0, got.TotalPrice)
+	assert.Equal(t, entity.StatusPending, got.Status)
+	assert.Len(t, got.History, 1)
+}
+
+func TestOrderUsecase_StockSaga(t *testing.T) {
+	ctx := context.Background()
+	pub := &memoryOutbox{}
+	repo := newMemoryOrderRepo(pub)
+	carID := uuid.New()
+	uc := NewOrderUsecase(repo, pub, newFakeCarService(&carclient.Car{ID: carID.String(), Price: 100, Stock: 5}))
+
+	newOrder := func() *entity.Order {
+		o := &entity.Order{UserID: uuid.New(), CarID: carID, Quantity: 1}
+		assert.NoError(t, uc.Create(ctx, o))
+		return o
+	}
+
+	// Reserved stock confirms the order; a redelivery is ignored
+	o1 := newOrder()
+	assert.NoError(t, uc.HandleStockReserved(ctx, entity.StockReservedEvent{OrderID: o1.ID.String()}))
+	assert.NoError(t, uc.HandleStockReserved(ctx, entity.StockReservedEvent{OrderID: o1.ID.String()}))
+	got, _ := uc.FindByID(ctx, o1.ID.String())
+	assert.Equal(t, entity.StatusConfirmed, got.Status)
+	assert.NotContains(t, pub.subjects, entity.SubjectStockReleased)
+
+	// Cancelling a confirmed order releases its stock
+	_, err := uc.Cancel(ctx, o1.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, entity.SubjectStockReleased, pub.subjects[len(pub.subjects)-1])
+
+	// So does refunding a paid order before it ships
+	paid := newOrder()
+	assert.NoError(t, uc.HandleStockReserved(ctx, entity.StockReservedEvent{OrderID: paid.ID.String()}))
+	_, err = uc.MarkPaid(ctx, paid.ID.String())
+	assert.NoError(t, err)
+	_, err = uc.Refund(ctx, paid.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, entity.SubjectStockReleased, pub.subjects[len(pub.subjects)-1])
+
+	// Rejected stock rejects the order
+	o2 := newOrder()
+	assert.NoError(t, uc.HandleStockRejected(ctx, entity.StockRejectedEvent{OrderID: o2.ID.String()}))
+	got, _ = uc.FindByID(ctx, o2.ID.String())
+	assert.Equal(t, entity.StatusRejected, got.Status)
+
+	// A reservation arriving after cancellation is compensated
+	o3 := newOrder()
+	_, err = uc.Cancel(ctx, o3.ID.String())
+	assert.NoError(t, err)
+	pub.subjects = nil
+	assert.NoError(t, uc.HandleStockReserved(ctx, entity.StockReservedEvent{OrderID: o3.ID.String()}))
+	assert.Equal(t, []string{entity.SubjectStockReleased}, pub.subjects)
+
+	// So is one for an order deleted before it arrived
+	o4 := newOrder()
+	assert.NoError(t, uc.Delete(ctx, o4.ID.String()))
+	pub.subjects = nil
+	assert.NoError(t, uc.HandleStockReserved(ctx, entity.StockReservedEvent{OrderID: o4.ID.String(), CarID: o4.CarID.String(), Quantity: o4.Quantity}))
+	assert.Equal(t, []string{entity.SubjectStockReleased}, pub.subjects)
+}
+
+func TestOrderUsecase_Ownership(t *testing.T) {
+	ctx := context.Background()
+	outbox := &memoryOutbox{}
+	carID := uuid.New()
+	uc := NewOrderUsecase(newMemoryOrderRepo(outbox), outbox, newFakeCarService(&carclient.Car{ID: carID.String(), Price: 100, Stock: 5}))
+
+	alice, bob := uuid.New(), uuid.New()
+	first := &entity.Order{UserID: alice, CarID: carID, Quantity: 1}
+	assert.NoError(t, uc.Create(ctx, first))
+	second := &entity.Order{UserID: alice, CarID: carID, Quantity: 1}
+	assert.NoError(t, uc.Create(ctx, second))
+	assert.NoError(t, uc.Create(ctx, &entity.Order{UserID: bob, CarID: carID, Quantity: 1}))
+
+	// Other users' orders look like missing ones
+	_, err := uc.FindOwned(ctx, first.ID.String(), bob)
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+	_, err = uc.CancelOwned(ctx, first.ID.String(), bob)
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+
+	got, err := uc.FindOwned(ctx, first.ID.String(), alice)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, got.ID)
+	cancelled, err := uc.CancelOwned(ctx, first.ID.String(), alice)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.StatusCancelled, cancelled.Status)
+
+	mine, err := uc.ListByUser(ctx, alice)
+	assert.NoError(t, err)
+	assert.Len(t, mine, 2)
+}
@@ -0,0 +1,59 @@
+package carclient
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	carpetpb "CarStore/CarService/api/pb/car"
+)
+
+// ErrCarNotFound is returned when CarService has no car with the given id.
+var ErrCarNotFound = errors.New("car not found")
+
+// Car is the catalog data OrderService needs to price and check an order.
+type Car struct {
+	ID    string
+	Price float64
+	Stock int
+}
+
+// Client looks cars up in CarService over gRPC.
+type Client struct {
+	conn *grpc.ClientConn
+	api  carpetpb.CarServiceClient
+}
+
+// New dials CarService at addr.
+func New(addr string) (*Client, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, api: carpetpb.NewCarServiceClient(conn)}, nil
+}
+
+// GetCar fetches the current price and stock of a car.
+func (c *Client) GetCar(ctx context.Context, id string) (*Car, error) {
+	resp, err := c.api.GetCar(ctx, &carpetpb.GetCarRequest{Id: id})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrCarNotFound
+		}
+		return nil, err
+	}
+	return &Car{
+		ID:    resp.Car.Id,
+		Price: resp.Car.Price,
+		Stock: int(resp.Car.Stock),
+	}, nil
+}
+
+// Close releases the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
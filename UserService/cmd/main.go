@@ -4,13 +4,17 @@ package main
 import (
 	"CarStore/UserService/pkg/auth"
 	"CarStore/UserService/pkg/email"
+	"CarStore/UserService/pkg/grpcserver"
 	"CarStore/UserService/pkg/redis"
+	"CarStore/UserService/pkg/session"
+	"context"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
-	"google.golang.org/grpc"
 
 	userpb "CarStore/UserService/api/pb/user"
 	"CarStore/UserService/internal/handler"
@@ -29,7 +33,8 @@ func main() {
 	// env vars
 	mongoURI := os.Getenv("USER_SERVICE_MONGO_URI")
 	dbName := os.Getenv("USER_SERVICE_DB_NAME")
-	jwtSecret := os.Getenv("JWT_SECRET")
+	jwtKeyID := os.Getenv("JWT_KEY_ID")
+	jwtKeyFile := os.Getenv("JWT_PRIVATE_KEY_FILE")
 	grpcPort := os.Getenv("GRPC_PORT")
 	if grpcPort == "" {
 		grpcPort = "50052"
@@ -42,8 +47,8 @@ func main() {
 	smtpFrom := os.Getenv("SMTP_FROM")
 
 	// sanity check
-	if mongoURI == "" || dbName == "" || jwtSecret == "" {
-		log.Fatal("MONGO_URI, DB_NAME and JWT_SECRET must be set")
+	if mongoURI == "" || dbName == "" || jwtKeyID == "" || jwtKeyFile == "" {
+		log.Fatal("MONGO_URI, DB_NAME, JWT_KEY_ID and JWT_PRIVATE_KEY_FILE must be set")
 	}
 
 	// connect Mongo
@@ -55,23 +60,58 @@ func main() {
 
 	// wiring
 	userRepo := repository.NewUserRepository(db)
-	jwtSvc := jwt.NewJWTService(jwtSecret, "UserService")
+	signingKey, err := jwt.LoadPrivateKey(jwtKeyID, jwtKeyFile)
+	if err != nil {
+		log.Fatalf("jwt signing key: %v", err)
+	}
+	// public keys of rotated-out signing keys, kept until their tokens expire
+	previousKeys, err := jwt.LoadPublicKeys(os.Getenv("JWT_PUBLIC_KEYS"))
+	if err != nil {
+		log.Fatalf("jwt public keys: %v", err)
+	}
+	jwtSvc := jwt.NewJWTService("UserService", signingKey, previousKeys...)
 	emailSvc := email.NewSMTPSender(smtpHost, smtpPort, smtpUser, smtpPass, smtpFrom)
 	rdb := redis.NewClient(os.Getenv("REDIS_ADDR"), os.Getenv("REDIS_PASS"), 0)
-	userUC := usecase.NewUserUsecase(userRepo, jwtSvc, emailSvc, rdb)
+	sessions := session.NewStore(rdb)
+	userUC := usecase.NewUserUsecase(userRepo, repository.NewRoleRepository(db), jwtSvc, sessions, emailSvc, rdb)
+	if err := userUC.EnsureDefaultRoles(context.Background()); err != nil {
+		log.Fatalf("default roles: %v", err)
+	}
+	// admins must enroll in two-factor authentication before using admin RPCs
+	userUC.RequireAdminTOTP(os.Getenv("REQUIRE_ADMIN_TOTP") == "true")
 
 	// gRPC server
 	lis, err := net.Listen("tcp", ":"+grpcPort)
 	if err != nil {
 		log.Fatalf("listen on %s: %v", grpcPort, err)
 	}
-	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(auth.UnaryAuthInterceptor(*jwtSvc)))
+	suspended := auth.NewStatusCache(sessions, auth.StatusCacheTTL)
+	grpcServer := grpcserver.New(grpcserver.Options{JWT: *jwtSvc, Revoked: sessions, Suspended: suspended})
+	grpcserver.ServeMetrics(os.Getenv("USER_SERVICE_METRICS_ADDR"))
+
+	// peers allowed to pass on the client address in X-Forwarded-For,
+	// i.e. the API gateway
+	trustedProxies, err := handler.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("trusted proxies: %v", err)
+	}
 
 	// register your service implementation
-	userpb.RegisterUserServiceServer(grpcServer, handler.NewAuthHandler(userUC))
+	userpb.RegisterUserServiceServer(grpcServer, handler.NewAuthHandler(userUC, trustedProxies))
+
+	// on SIGINT or SIGTERM, finish the running RPCs and the emails they
+	// queued before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
 
 	log.Printf("gRPC UserService listening on :%s", grpcPort)
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("gRPC serve error: %v", err)
 	}
+	userUC.Wait()
+	log.Printf("gRPC UserService stopped")
 }
@@ -0,0 +1,11 @@
+package entity
+
+import "time"
+
+// Role is a named set of permissions assigned to users.
+type Role struct {
+	Name        string    `json:"name" bson:"name"`
+	Permissions []string  `json:"permissions" bson:"permissions"`
+	Description string    `json:"description" bson:"description"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,16 @@
+package entity
+
+import "time"
+
+// TokenPair is what a successful login or refresh hands to the client: a
+// short-lived access token and the refresh token to renew it.
+//
+// When the account has two-factor authentication enabled, a password login
+// only yields ChallengeToken, which is exchanged for the tokens together
+// with a TOTP or recovery code.
+type TokenPair struct {
+	AccessToken    string
+	RefreshToken   string
+	ExpiresIn      time.Duration
+	ChallengeToken string
+}
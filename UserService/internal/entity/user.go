@@ -8,11 +8,26 @@ import (
 type User struct {
 	ID               uuid.UUID `json:"id" bson:"id"`
 	Email            string    `json:"email" bson:"email"`
+	PendingEmail     string    `json:"pending_email,omitempty" bson:"pending_email,omitempty"` // replaces Email once confirmed
 	Username         string    `json:"username" bson:"username"`
 	Password         string    `json:"-" bson:"password"`
 	Role             string    `json:"role" bson:"role"`
 	IsActive         bool      `json:"is_active" bson:"is_active"`
 	VerificationCode string    `json:"-" bson:"verif_code"`
 	CodeExpiresAt    time.Time `json:"-" bson:"code_expires"`
+	ResetTokenHash   string    `json:"-" bson:"reset_token_hash,omitempty"`
+	ResetExpiresAt   time.Time `json:"-" bson:"reset_expires,omitempty"`
 	CreatedAt        time.Time `json:"created_at" bson:"createdat"`
+
+	// Suspended accounts cannot log in and their tokens are rejected.
+	Suspended       bool      `json:"suspended" bson:"suspended"`
+	SuspendedReason string    `json:"suspended_reason,omitempty" bson:"suspended_reason,omitempty"`
+	SuspendedAt     time.Time `json:"suspended_at,omitempty" bson:"suspended_at,omitempty"`
+
+	// TOTPSecret is set at enrollment; it takes effect once TOTPEnabled is set
+	// by confirming a first code. RecoveryCodes holds hashes of unused codes.
+	TOTPSecret    string   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPEnabled   bool     `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPRequired  bool     `json:"totp_required" bson:"totp_required"`
+	RecoveryCodes []string `json:"-" bson:"recovery_codes,omitempty"`
 }
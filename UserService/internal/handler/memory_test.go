@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/internal/repository"
+)
+
+// memoryUsers keeps users in a map and follows the error contract of the
+// Mongo repository, so handler inputs reach the same code paths.
+type memoryUsers struct {
+	store map[uuid.UUID]*entity.User
+}
+
+func (m *memoryUsers) find(match func(*entity.User) bool) (*entity.User, error) {
+	for _, u := range m.store {
+		if match(u) {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (m *memoryUsers) get(id uuid.UUID) (*entity.User, error) {
+	u, ok := m.store[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (m *memoryUsers) update(id uuid.UUID, change func(*entity.User)) (*entity.User, error) {
+	u, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	change(u)
+	cp := *u
+	return &cp, nil
+}
+
+func (m *memoryUsers) Create(ctx context.Context, u *entity.User) error {
+	cp := *u
+	m.store[u.ID] = &cp
+	return nil
+}
+
+func (m *memoryUsers) FindByEmail(ctx context.Context, email string) (*entity.User, error) {
+	return m.find(func(u *entity.User) bool { return u.Email == email })
+}
+
+func (m *memoryUsers) FindByUsername(ctx context.Context, username string) (*entity.User, error) {
+	return m.find(func(u *entity.User) bool { return u.Username == username })
+}
+
+func (m *memoryUsers) Update(ctx context.Context, u *entity.User) error {
+	if _, err := m.get(u.ID); err != nil {
+		return err
+	}
+	cp := *u
+	m.store[u.ID] = &cp
+	return nil
+}
+
+func (m *memoryUsers) FindByID(ctx context.Context, id string) (*entity.User, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, repository.ErrInvalidUserID
+	}
+	return m.find(func(u *entity.User) bool { return u.ID == uid })
+}
+
+func (m *memoryUsers) FindAll(ctx context.Context) ([]*entity.User, error) {
+	var list []*entity.User
+	for _, u := range m.store {
+		cp := *u
+		list = append(list, &cp)
+	}
+	return list, nil
+}
+
+func (m *memoryUsers) SetVerificationCode(ctx context.Context, email, code string, expires time.Time) error {
+	u, err := m.find(func(u *entity.User) bool { return u.Email == email })
+	if err != nil {
+		return err
+	}
+	_, err = m.update(u.ID, func(u *entity.User) { u.VerificationCode, u.CodeExpiresAt = code, expires })
+	return err
+}
+
+func (m *memoryUsers) VerifyCode(ctx context.Context, email, code string) (*entity.User, error) {
+	u, err := m.find(func(u *entity.User) bool {
+		return (u.Email == email || u.PendingEmail == email) && u.VerificationCode == code && code != "" && time.Now().Before(u.CodeExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.update(u.ID, func(u *entity.User) { u.IsActive, u.VerificationCode = true, "" })
+}
+
+func (m *memoryUsers) UpdateUsername(ctx context.Context, id uuid.UUID, username string) (*entity.User, error) {
+	return m.update(id, func(u *entity.User) { u.Username = username })
+}
+
+func (m *memoryUsers) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	_, err := m.update(id, func(u *entity.User) { u.Password = passwordHash })
+	return err
+}
+
+func (m *memoryUsers) SetPendingEmail(ctx context.Context, id uuid.UUID, email, code string, expires time.Time) (*entity.User, error) {
+	return m.update(id, func(u *entity.User) { u.PendingEmail, u.VerificationCode, u.CodeExpiresAt = email, code, expires })
+}
+
+func (m *memoryUsers) ConfirmPendingEmail(ctx context.Context, id uuid.UUID, email string) (*entity.User, error) {
+	u, err := m.get(id)
+	if err != nil || u.PendingEmail != email {
+		return nil, repository.ErrUserNotFound
+	}
+	return m.update(id, func(u *entity.User) { u.Email, u.PendingEmail, u.IsActive, u.VerificationCode = email, "", true, "" })
+}
+
+func (m *memoryUsers) SetTOTPSecret(ctx context.Context, id uuid.UUID, secret string) error {
+	_, err := m.update(id, func(u *entity.User) { u.TOTPSecret = secret })
+	return err
+}
+
+func (m *memoryUsers) EnableTOTP(ctx context.Context, id uuid.UUID, recoveryCodeHashes []string) error {
+	_, err := m.update(id, func(u *entity.User) { u.TOTPEnabled, u.RecoveryCodes = true, recoveryCodeHashes })
+	return err
+}
+
+func (m *memoryUsers) UseRecoveryCode(ctx context.Context, id uuid.UUID, codeHash string) (bool, error) {
+	u, err := m.get(id)
+	if err != nil {
+		return false, err
+	}
+	for i, c := range u.RecoveryCodes {
+		if c == codeHash {
+			u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryUsers) SetTOTPRequired(ctx context.Context, id uuid.UUID, required bool) (*entity.User, error) {
+	return m.update(id, func(u *entity.User) { u.TOTPRequired = required })
+}
+
+func (m *memoryUsers) SetSuspended(ctx context.Context, id uuid.UUID, suspended bool, reason string, at time.Time) (*entity.User, error) {
+	return m.update(id, func(u *entity.User) { u.Suspended, u.SuspendedReason, u.SuspendedAt = suspended, reason, at })
+}
+
+func (m *memoryUsers) SetResetToken(ctx context.Context, id uuid.UUID, tokenHash string, expires time.Time) error {
+	_, err := m.update(id, func(u *entity.User) { u.ResetTokenHash, u.ResetExpiresAt = tokenHash, expires })
+	return err
+}
+
+func (m *memoryUsers) ConsumeResetToken(ctx context.Context, tokenHash, passwordHash string, now time.Time) (*entity.User, error) {
+	u, err := m.find(func(u *entity.User) bool {
+		return u.ResetTokenHash == tokenHash && tokenHash != "" && now.Before(u.ResetExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.update(u.ID, func(u *entity.User) { u.Password, u.ResetTokenHash = passwordHash, "" })
+}
+
+func (m *memoryUsers) ChangeRole(ctx context.Context, id, role string) (*entity.User, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, repository.ErrInvalidUserID
+	}
+	return m.update(uid, func(u *entity.User) { u.Role = role })
+}
+
+func (m *memoryUsers) CountByRole(ctx context.Context, role string) (int64, error) {
+	var n int64
+	for _, u := range m.store {
+		if u.Role == role {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memoryUsers) DeleteUser(ctx context.Context, id string) error {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return repository.ErrInvalidUserID
+	}
+	if _, err := m.get(uid); err != nil {
+		return err
+	}
+	delete(m.store, uid)
+	return nil
+}
+
+type memoryRoles map[string]*entity.Role
+
+func (m memoryRoles) FindByName(ctx context.Context, name string) (*entity.Role, error) {
+	r, ok := m[name]
+	if !ok {
+		return nil, repository.ErrRoleNotFound
+	}
+	cp := *r
+	return &cp, nil
+}
+
+func (m memoryRoles) FindAll(ctx context.Context) ([]*entity.Role, error) {
+	var list []*entity.Role
+	for _, r := range m {
+		cp := *r
+		list = append(list, &cp)
+	}
+	return list, nil
+}
+
+func (m memoryRoles) Create(ctx context.Context, role *entity.Role) error {
+	if _, ok := m[role.Name]; ok {
+		return repository.ErrRoleExists
+	}
+	cp := *role
+	m[role.Name] = &cp
+	return nil
+}
+
+func (m memoryRoles) Update(ctx context.Context, role *entity.Role) error {
+	if _, ok := m[role.Name]; !ok {
+		return repository.ErrRoleNotFound
+	}
+	cp := *role
+	m[role.Name] = &cp
+	return nil
+}
+
+func (m memoryRoles) Delete(ctx context.Context, name string) error {
+	if _, ok := m[name]; !ok {
+		return repository.ErrRoleNotFound
+	}
+	delete(m, name)
+	return nil
+}
@@ -2,40 +2,67 @@ package handler
 
 import (
 	userpb "CarStore/UserService/api/pb/user"
+	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/internal/repository"
 	"CarStore/UserService/internal/usecase"
+	"CarStore/UserService/pkg/apperr"
 	"CarStore/UserService/pkg/auth"
 	"context"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"errors"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"log"
+	"net"
+	"net/netip"
+	"strings"
 )
 
+// Handlers return usecase errors as they are; the server's error
+// interceptor maps them to status codes.
+var errMissingToken = apperr.New(apperr.Unauthenticated, "missing token")
+
 type AuthHandler struct {
 	userpb.UnimplementedUserServiceServer
 	uc *usecase.UserUsecase
+	// trustedProxies may set X-Forwarded-For, e.g. the API gateway.
+	trustedProxies []netip.Prefix
 }
 
-func NewAuthHandler(uc *usecase.UserUsecase) userpb.UserServiceServer {
-	return &AuthHandler{uc: uc}
+func NewAuthHandler(uc *usecase.UserUsecase, trustedProxies []netip.Prefix) userpb.UserServiceServer {
+	return &AuthHandler{uc: uc, trustedProxies: trustedProxies}
 }
 
-func (h *AuthHandler) RegisterUser(ctx context.Context, req *userpb.RegisterUserRequest) (*userpb.AuthResponse, error) {
-	log.Printf("RegisterUser request: %+v", req)
-
-	if req.Email == "" {
-		return nil, status.Error(codes.InvalidArgument, "Email is required")
-	}
-
-	if req.Username == "" {
-		return nil, status.Error(codes.InvalidArgument, "Username is required")
+// ParseTrustedProxies parses a comma-separated list of IP addresses and
+// CIDR prefixes, such as "10.0.0.5,172.18.0.0/16".
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
 	}
+	return prefixes, nil
+}
 
-	if req.Password == "" {
-		return nil, status.Error(codes.InvalidArgument, "Password is required")
-	}
+func (h *AuthHandler) RegisterUser(ctx context.Context, req *userpb.RegisterUserRequest) (*userpb.AuthResponse, error) {
+	log.Printf("RegisterUser request: %+v", req)
 
 	if err := h.uc.Register(ctx, req.Email, req.Username, req.Password, "user"); err != nil {
-		return &userpb.AuthResponse{Status: err.Error()}, nil
+		return nil, err
 	}
 	return &userpb.AuthResponse{Token: "", Status: "code_sent"}, nil
 }
@@ -44,11 +71,116 @@ func (h *AuthHandler) LoginUser(ctx context.Context, req *userpb.LoginUserReques
 	log.Printf("LoginUser request: %+v", req.Identifier) //password hidden
 	// pick identifier
 	ident := req.Identifier
-	token, err := h.uc.Login(ctx, ident, req.Password)
+	tokens, err := h.uc.Login(ctx, ident, req.Password, h.clientIP(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return authResponse(tokens), nil
+}
+
+func (h *AuthHandler) VerifyTOTPLogin(ctx context.Context, req *userpb.VerifyTOTPLoginRequest) (*userpb.AuthResponse, error) {
+	log.Printf("VerifyTOTPLogin request") // challenge and code hidden
+	tokens, err := h.uc.VerifyTOTPLogin(ctx, req.MfaChallenge, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	return authResponse(tokens), nil
+}
+
+func (h *AuthHandler) EnrollTOTP(ctx context.Context, req *userpb.EnrollTOTPRequest) (*userpb.EnrollTOTPResponse, error) {
+	uid, _ := auth.FromContext(ctx)
+	uri, secret, err := h.uc.EnrollTOTP(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	return &userpb.EnrollTOTPResponse{OtpauthUri: uri, Secret: secret}, nil
+}
+
+func (h *AuthHandler) ConfirmTOTP(ctx context.Context, req *userpb.ConfirmTOTPRequest) (*userpb.ConfirmTOTPResponse, error) {
+	uid, _ := auth.FromContext(ctx)
+	recovery, err := h.uc.ConfirmTOTP(ctx, uid, req.Code)
+	if errors.Is(err, usecase.ErrInvalidTOTPCode) {
+		// a wrong code here is a bad request, not a failed login
+		return nil, apperr.Field("code", err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userpb.ConfirmTOTPResponse{RecoveryCodes: recovery, Status: "totp_enabled"}, nil
+}
+
+func (h *AuthHandler) RequireTOTP(ctx context.Context, req *userpb.RequireTOTPRequest) (*userpb.RequireTOTPResponse, error) {
+	log.Printf("RequireTOTP request: %+v", req)
+	if _, err := h.uc.RequireTOTP(ctx, req.UserId, req.Required); err != nil {
+		return nil, err
+	}
+	return &userpb.RequireTOTPResponse{Status: "ok"}, nil
+}
+
+// authResponse renders a login result: either the tokens or, for two-factor
+// accounts, the challenge to answer with VerifyTOTPLogin.
+func authResponse(tokens *entity.TokenPair) *userpb.AuthResponse {
+	if tokens.ChallengeToken != "" {
+		return &userpb.AuthResponse{MfaChallenge: tokens.ChallengeToken, Status: "mfa_required"}
+	}
+	return &userpb.AuthResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+		Status:       "OK",
+	}
+}
+
+func (h *AuthHandler) RefreshToken(ctx context.Context, req *userpb.RefreshTokenRequest) (*userpb.AuthResponse, error) {
+	tokens, err := h.uc.Refresh(ctx, req.RefreshToken)
 	if err != nil {
-		return &userpb.AuthResponse{Status: err.Error()}, nil
+		return nil, err
+	}
+	return authResponse(tokens), nil
+}
+
+func (h *AuthHandler) Logout(ctx context.Context, req *userpb.LogoutRequest) (*userpb.LogoutResponse, error) {
+	claims, ok := auth.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, errMissingToken
+	}
+	if err := h.uc.Logout(ctx, claims.ID, claims.SessionID, claims.ExpiresAt.Time); err != nil {
+		return nil, err
+	}
+	return &userpb.LogoutResponse{Status: "logged_out"}, nil
+}
+
+func (h *AuthHandler) RequestPasswordReset(ctx context.Context, req *userpb.RequestPasswordResetRequest) (*userpb.RequestPasswordResetResponse, error) {
+	log.Printf("RequestPasswordReset request")
+	if err := h.uc.RequestPasswordReset(ctx, req.Email, h.clientIP(ctx)); err != nil {
+		log.Printf("password reset request failed: %v", err)
+	}
+	return &userpb.RequestPasswordResetResponse{Status: "if the account exists, a reset code was sent"}, nil
+}
+
+func (h *AuthHandler) ResetPassword(ctx context.Context, req *userpb.ResetPasswordRequest) (*userpb.ResetPasswordResponse, error) {
+	log.Printf("ResetPassword request") // token and password hidden
+	if err := h.uc.ResetPassword(ctx, req.Token, req.NewPassword); err != nil {
+		return nil, err
 	}
-	return &userpb.AuthResponse{Token: token, Status: "OK"}, nil
+	return &userpb.ResetPasswordResponse{Status: "password_reset"}, nil
+}
+
+func (h *AuthHandler) GetJWKS(ctx context.Context, req *userpb.GetJWKSRequest) (*userpb.JWKSResponse, error) {
+	resp := &userpb.JWKSResponse{}
+	for _, k := range h.uc.JWKS() {
+		resp.Keys = append(resp.Keys, &userpb.JWK{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Alg: k.Alg,
+			Use: k.Use,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+		})
+	}
+	return resp, nil
 }
 
 func (h *AuthHandler) GetProfile(ctx context.Context, req *userpb.GetProfileRequest) (*userpb.ProfileResponse, error) {
@@ -56,12 +188,55 @@ func (h *AuthHandler) GetProfile(ctx context.Context, req *userpb.GetProfileRequ
 	uid, _ := auth.FromContext(ctx)
 	u, err := h.uc.Profile(ctx, uid)
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, "user not found")
+		return nil, err
+	}
+	return &userpb.ProfileResponse{User: &userpb.User{
+		Id:           u.ID.String(),
+		Email:        u.Email,
+		PendingEmail: u.PendingEmail,
+		Username:     u.Username,
+	}}, nil
+}
+
+func (h *AuthHandler) UpdateProfile(ctx context.Context, req *userpb.UpdateProfileRequest) (*userpb.ProfileResponse, error) {
+	log.Printf("UpdateProfile request: %+v", req)
+	uid, _ := auth.FromContext(ctx)
+	u, err := h.uc.UpdateProfile(ctx, uid, req.Username)
+	if err != nil {
+		return nil, err
 	}
 	return &userpb.ProfileResponse{User: &userpb.User{
-		Id:       u.ID.String(),
-		Email:    u.Email,
-		Username: u.Username,
+		Id:           u.ID.String(),
+		Email:        u.Email,
+		PendingEmail: u.PendingEmail,
+		Username:     u.Username,
+	}}, nil
+}
+
+func (h *AuthHandler) ChangePassword(ctx context.Context, req *userpb.ChangePasswordRequest) (*userpb.ChangePasswordResponse, error) {
+	log.Printf("ChangePassword request") // passwords hidden
+	claims, ok := auth.ClaimsFromContext(ctx)
+	if !ok {
+		return nil, errMissingToken
+	}
+	if err := h.uc.ChangePassword(ctx, claims.UserID, claims.SessionID, req.CurrentPassword, req.NewPassword); err != nil {
+		return nil, err
+	}
+	return &userpb.ChangePasswordResponse{Status: "password_changed"}, nil
+}
+
+func (h *AuthHandler) ChangeEmail(ctx context.Context, req *userpb.ChangeEmailRequest) (*userpb.ProfileResponse, error) {
+	log.Printf("ChangeEmail request: %+v", req)
+	uid, _ := auth.FromContext(ctx)
+	u, err := h.uc.ChangeEmail(ctx, uid, req.Email)
+	if err != nil {
+		return nil, err
+	}
+	return &userpb.ProfileResponse{User: &userpb.User{
+		Id:           u.ID.String(),
+		Email:        u.Email,
+		PendingEmail: u.PendingEmail,
+		Username:     u.Username,
 	}}, nil
 }
 
@@ -74,11 +249,13 @@ func (h *AuthHandler) ListUsers(ctx context.Context, req *userpb.ListUsersReques
 	resp := &userpb.ListUsersResponse{}
 	for _, u := range us {
 		resp.Users = append(resp.Users, &userpb.User{
-			Id:       u.ID.String(),
-			Email:    u.Email,
-			Username: u.Username,
-			Password: u.Password,
-			Role:     u.Role,
+			Id:              u.ID.String(),
+			Email:           u.Email,
+			Username:        u.Username,
+			Password:        u.Password,
+			Role:            u.Role,
+			Suspended:       u.Suspended,
+			SuspendedReason: u.SuspendedReason,
 		})
 	}
 	return resp, nil
@@ -86,26 +263,65 @@ func (h *AuthHandler) ListUsers(ctx context.Context, req *userpb.ListUsersReques
 
 func (h *AuthHandler) SendVerificationCode(ctx context.Context, req *userpb.SendCodeRequest) (*userpb.SendCodeResponse, error) {
 	log.Printf("SendVerificationCode request: %+v", req)
-	status, err := h.uc.SendVerificationCode(ctx, req.Email)
-	if err != nil {
+	if err := h.uc.SendVerificationCode(ctx, req.Email, h.clientIP(ctx)); err != nil {
 		return nil, err
 	}
-	return &userpb.SendCodeResponse{Status: status}, nil
+	return &userpb.SendCodeResponse{Status: "if the address awaits verification, a code was sent"}, nil
 }
 
 func (h *AuthHandler) ConfirmEmail(ctx context.Context, req *userpb.ConfirmEmailRequest) (*userpb.ConfirmEmailResponse, error) {
 	log.Printf("ConfirmEmail request: %+v", req)
-	token, err := h.uc.ConfirmEmail(ctx, req.Email, req.Code)
+	if err := h.uc.ConfirmEmail(ctx, req.Email, req.Code, h.clientIP(ctx)); err != nil {
+		return nil, err
+	}
+	return &userpb.ConfirmEmailResponse{Status: "verified"}, nil
+}
+
+func (h *AuthHandler) UnlockAccount(ctx context.Context, req *userpb.UnlockAccountRequest) (*userpb.UnlockAccountResponse, error) {
+	log.Printf("UnlockAccount request: %+v", req)
+	if err := h.uc.UnlockAccount(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+	return &userpb.UnlockAccountResponse{Status: "unlocked"}, nil
+}
+
+func (h *AuthHandler) SuspendUser(ctx context.Context, req *userpb.SuspendUserRequest) (*userpb.AccountStatusResponse, error) {
+	log.Printf("SuspendUser request: %+v", req)
+	updated, err := h.uc.SuspendUser(ctx, req.UserId, req.Reason)
 	if err != nil {
 		return nil, err
 	}
-	return &userpb.ConfirmEmailResponse{Token: token, Status: "verified"}, nil
+	return &userpb.AccountStatusResponse{User: accountStatusUser(updated), Status: "suspended"}, nil
+}
+
+func (h *AuthHandler) ReactivateUser(ctx context.Context, req *userpb.ReactivateUserRequest) (*userpb.AccountStatusResponse, error) {
+	log.Printf("ReactivateUser request: %+v", req)
+	updated, err := h.uc.ReactivateUser(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return &userpb.AccountStatusResponse{User: accountStatusUser(updated), Status: "active"}, nil
+}
+
+func accountStatusUser(u *entity.User) *userpb.User {
+	return &userpb.User{
+		Id:              u.ID.String(),
+		Email:           u.Email,
+		Username:        u.Username,
+		Role:            u.Role,
+		Suspended:       u.Suspended,
+		SuspendedReason: u.SuspendedReason,
+	}
 }
 
 func (h *AuthHandler) ChangeUserRole(ctx context.Context, req *userpb.ChangeUserRoleRequest) (*userpb.ChangeUserRoleResponse, error) {
 	updated, err := h.uc.ChangeUserRole(ctx, req.UserId, req.Role)
+	if errors.Is(err, repository.ErrRoleNotFound) {
+		// the role is a request field here, not the resource being fetched
+		return nil, apperr.Field("role", err.Error())
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "could not change role: %v", err)
+		return nil, err
 	}
 	return &userpb.ChangeUserRoleResponse{
 		User: &userpb.User{
@@ -118,15 +334,63 @@ func (h *AuthHandler) ChangeUserRole(ctx context.Context, req *userpb.ChangeUser
 	}, nil
 }
 
-func (h *AuthHandler) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
-	log.Printf("DeleteUser request: %+v", req.UserId)
+func (h *AuthHandler) ListRoles(ctx context.Context, req *userpb.ListRolesRequest) (*userpb.ListRolesResponse, error) {
+	roles, err := h.uc.ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp := &userpb.ListRolesResponse{AvailablePermissions: auth.AllPermissions}
+	for _, r := range roles {
+		resp.Roles = append(resp.Roles, toProtoRole(r))
+	}
+	return resp, nil
+}
+
+func (h *AuthHandler) CreateRole(ctx context.Context, req *userpb.CreateRoleRequest) (*userpb.Role, error) {
+	log.Printf("CreateRole request: %+v", req)
+	if req.Role == nil {
+		return nil, apperr.Field("role", "required")
+	}
+	created, err := h.uc.CreateRole(ctx, fromProtoRole(req.Role))
+	if err != nil {
+		return nil, err
+	}
+	return toProtoRole(created), nil
+}
+
+func (h *AuthHandler) UpdateRole(ctx context.Context, req *userpb.UpdateRoleRequest) (*userpb.Role, error) {
+	log.Printf("UpdateRole request: %+v", req)
+	if req.Role == nil {
+		return nil, apperr.Field("role", "required")
+	}
+	updated, err := h.uc.UpdateRole(ctx, fromProtoRole(req.Role))
+	if err != nil {
+		return nil, err
+	}
+	return toProtoRole(updated), nil
+}
 
-	if req.UserId == "" {
-		return nil, status.Error(codes.InvalidArgument, "user id required")
+func (h *AuthHandler) DeleteRole(ctx context.Context, req *userpb.DeleteRoleRequest) (*userpb.DeleteRoleResponse, error) {
+	log.Printf("DeleteRole request: %+v", req)
+	if err := h.uc.DeleteRole(ctx, req.Name); err != nil {
+		return nil, err
 	}
+	return &userpb.DeleteRoleResponse{Status: "deleted"}, nil
+}
+
+func toProtoRole(r *entity.Role) *userpb.Role {
+	return &userpb.Role{Name: r.Name, Permissions: r.Permissions, Description: r.Description}
+}
+
+func fromProtoRole(r *userpb.Role) *entity.Role {
+	return &entity.Role{Name: r.Name, Permissions: r.Permissions, Description: r.Description}
+}
+
+func (h *AuthHandler) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	log.Printf("DeleteUser request: %+v", req.UserId)
 
 	if err := h.uc.DeleteUser(ctx, req.UserId); err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "could not delete user: %v", err)
+		return nil, err
 	}
 
 	return &userpb.DeleteUserResponse{
@@ -134,3 +398,40 @@ func (h *AuthHandler) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequ
 		Status:  "deleted",
 	}, nil
 }
+
+// clientIP returns the address of the caller. Only a trusted proxy may
+// name another client: behind the gateway that is the last X-Forwarded-For
+// entry, which the gateway itself appends. Anyone else could forge it.
+func (h *AuthHandler) clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	if !h.trusted(host) {
+		return host
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if fwd := md.Get("x-forwarded-for"); len(fwd) > 0 {
+		hops := strings.Split(fwd[len(fwd)-1], ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	return host
+}
+
+func (h *AuthHandler) trusted(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range h.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
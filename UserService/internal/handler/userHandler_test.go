@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	userpb "CarStore/UserService/api/pb/user"
+	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/internal/usecase"
+	"CarStore/UserService/pkg/auth"
+	"CarStore/UserService/pkg/email"
+	"CarStore/UserService/pkg/grpcserver"
+	"CarStore/UserService/pkg/grpcserver/grpctest"
+	"CarStore/UserService/pkg/jwt"
+	"CarStore/UserService/pkg/session"
+)
+
+// newUser returns a user with the password "secret1".
+func newUser(tb testing.TB, username, mail string, verified bool) *entity.User {
+	tb.Helper()
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret1"), bcrypt.MinCost)
+	require.NoError(tb, err)
+	return &entity.User{
+		ID: uuid.New(), Email: mail, Username: username, Password: string(hashed),
+		Role: "user", IsActive: verified, CreatedAt: time.Now(),
+	}
+}
+
+// newAuthHandler returns a handler over the given users, with Redis served
+// by miniredis.
+func newAuthHandler(tb testing.TB, users ...*entity.User) userpb.UserServiceServer {
+	tb.Helper()
+	mredis, err := miniredis.Run()
+	require.NoError(tb, err)
+	tb.Cleanup(mredis.Close)
+	rdb := redis.NewClient(&redis.Options{Addr: mredis.Addr()})
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(tb, err)
+	key, err := jwt.NewPrivateKey("test", priv)
+	require.NoError(tb, err)
+
+	repo := &memoryUsers{store: make(map[uuid.UUID]*entity.User)}
+	for _, u := range users {
+		repo.store[u.ID] = u
+	}
+	uc := usecase.NewUserUsecase(repo, memoryRoles{}, jwt.NewJWTService("UserService", key),
+		session.NewStore(rdb), email.NewConsoleSender(), rdb)
+	require.NoError(tb, uc.EnsureDefaultRoles(context.Background()))
+	return NewAuthHandler(uc, nil)
+}
+
+// TestAuthHandler_StatusCodes checks the codes clients see for failed
+// registrations and logins once the errors interceptor has mapped the
+// handler's errors.
+func TestAuthHandler_StatusCodes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	ctx := context.Background()
+	h := newAuthHandler(t, newUser(t, "u1", "a@b.com", true), newUser(t, "u2", "c@d.com", false))
+	code := func(_ *userpb.AuthResponse, err error) codes.Code {
+		return status.Code(grpcserver.StatusError(ctx, err))
+	}
+	register := func(mail, username string) codes.Code {
+		return code(h.RegisterUser(ctx, &userpb.RegisterUserRequest{Email: mail, Username: username, Password: "secret12"}))
+	}
+	login := func(identifier, password string) codes.Code {
+		return code(h.LoginUser(ctx, &userpb.LoginUserRequest{Identifier: identifier, Password: password}))
+	}
+
+	tests := []struct {
+		name string
+		got  codes.Code
+		want codes.Code
+	}{
+		{"Register taken email", register("a@b.com", "u3"), codes.AlreadyExists},
+		{"Register taken username", register("e@f.com", "u1"), codes.AlreadyExists},
+		{"Register", register("e@f.com", "u3"), codes.OK},
+		{"Login unverified", login("u2", "secret1"), codes.FailedPrecondition},
+		{"Login unknown user", login("nobody@b.com", "secret1"), codes.Unauthenticated},
+		{"Login wrong password", login("a@b.com", "wrong"), codes.Unauthenticated},
+		{"Login", login("a@b.com", "secret1"), codes.OK},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.got, tt.name)
+	}
+
+	// repeated failures lock the account
+	got := codes.OK
+	for range 10 {
+		got = login("u1", "wrong")
+	}
+	assert.Equal(t, codes.ResourceExhausted, got, "Login after repeated failures")
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.5, 172.18.0.0/16")
+	require.NoError(t, err)
+	h := &AuthHandler{trustedProxies: proxies}
+	call := func(from string, forwardedFor ...string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(from), Port: 4242}})
+		if len(forwardedFor) > 0 {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", forwardedFor[0]))
+		}
+		return ctx
+	}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"direct call", call("203.0.113.9"), "203.0.113.9"},
+		{"forged header on a direct call", call("203.0.113.9", "198.51.100.1"), "203.0.113.9"},
+		{"gateway", call("10.0.0.5", "198.51.100.1, 203.0.113.7"), "203.0.113.7"},
+		{"gateway by prefix", call("172.18.3.4", "203.0.113.7"), "203.0.113.7"},
+		{"gateway without header", call("10.0.0.5"), "10.0.0.5"},
+		{"no peer", context.Background(), ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, h.clientIP(tt.ctx), tt.name)
+	}
+
+	_, err = ParseTrustedProxies("10.0.0.300")
+	assert.Error(t, err, "invalid address accepted")
+}
+
+// FuzzAuthHandler checks that no request, however malformed, panics a
+// handler. Calls alternate between a user and an admin. Run with:
+// go test -fuzz FuzzAuthHandler ./UserService/internal/handler
+func FuzzAuthHandler(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	user := newUser(f, "u1", "a@b.com", true)
+	h := newAuthHandler(f, user)
+
+	// as accepted by ValidateToken, which requires an expiry
+	token := gojwt.RegisteredClaims{ID: uuid.NewString(), ExpiresAt: gojwt.NewNumericDate(time.Now().Add(time.Hour))}
+	callers := []*jwt.Claims{
+		{UserID: user.ID.String(), Role: "user", SessionID: uuid.NewString(), RegisteredClaims: token},
+		{UserID: uuid.NewString(), Role: "admin", Permissions: auth.AllPermissions, SessionID: uuid.NewString(), RegisteredClaims: token},
+	}
+	calls := 0
+	newCtx := func() context.Context {
+		calls++
+		return auth.NewContext(context.Background(), callers[calls%len(callers)])
+	}
+	desc := userpb.UserService_ServiceDesc
+
+	grpctest.Seed(f, desc, "LoginUser", &userpb.LoginUserRequest{Identifier: "a@b.com", Password: "secret1"})
+	grpctest.Seed(f, desc, "VerifyTOTPLogin", &userpb.VerifyTOTPLoginRequest{MfaChallenge: "x", Code: "123456"})
+	grpctest.Seed(f, desc, "RefreshToken", &userpb.RefreshTokenRequest{RefreshToken: "a.b.c"})
+	grpctest.Seed(f, desc, "ChangeUserRole", &userpb.ChangeUserRoleRequest{UserId: user.ID.String(), Role: "nope"})
+	grpctest.Seed(f, desc, "SuspendUser", &userpb.SuspendUserRequest{UserId: "not-a-uuid"})
+	grpctest.Seed(f, desc, "UpdateRole", &userpb.UpdateRoleRequest{})
+	grpctest.Seed(f, desc, "ConfirmTOTP", &userpb.ConfirmTOTPRequest{Code: "abc"})
+
+	grpctest.FuzzUnary(f, desc, h, newCtx)
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/pkg/apperr"
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var (
+	ErrRoleNotFound = apperr.New(apperr.NotFound, "role not found")
+	ErrRoleExists   = apperr.New(apperr.AlreadyExists, "role already exists")
+)
+
+type RoleRepository interface {
+	FindByName(ctx context.Context, name string) (*entity.Role, error)
+	FindAll(ctx context.Context) ([]*entity.Role, error)
+	// Create inserts the role, or returns ErrRoleExists.
+	Create(ctx context.Context, role *entity.Role) error
+	// Update replaces the role's permissions and description, or returns
+	// ErrRoleNotFound.
+	Update(ctx context.Context, role *entity.Role) error
+	Delete(ctx context.Context, name string) error
+}
+
+type roleRepositoryMongo struct {
+	collection *mongo.Collection
+}
+
+func NewRoleRepository(db *mongo.Database) RoleRepository {
+	return &roleRepositoryMongo{
+		collection: db.Collection("roles"),
+	}
+}
+
+func (r *roleRepositoryMongo) FindByName(ctx context.Context, name string) (*entity.Role, error) {
+	var role entity.Role
+	err := r.collection.FindOne(ctx, bson.M{"name": name}).Decode(&role)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *roleRepositoryMongo) FindAll(ctx context.Context) ([]*entity.Role, error) {
+	cursor, err := r.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var roles []*entity.Role
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Create upserts with $setOnInsert, so concurrent creates of one name
+// cannot both succeed even without a unique index.
+func (r *roleRepositoryMongo) Create(ctx context.Context, role *entity.Role) error {
+	role.UpdatedAt = time.Now().UTC()
+	res, err := r.collection.UpdateOne(ctx,
+		bson.M{"name": role.Name},
+		bson.M{"$setOnInsert": role},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+	if res.UpsertedCount == 0 {
+		return ErrRoleExists
+	}
+	return nil
+}
+
+func (r *roleRepositoryMongo) Update(ctx context.Context, role *entity.Role) error {
+	role.UpdatedAt = time.Now().UTC()
+	res, err := r.collection.UpdateOne(ctx,
+		bson.M{"name": role.Name},
+		bson.M{"$set": bson.M{
+			"permissions": role.Permissions,
+			"description": role.Description,
+			"updated_at":  role.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (r *roleRepositoryMongo) Delete(ctx context.Context, name string) error {
+	res, err := r.collection.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
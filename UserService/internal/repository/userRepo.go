@@ -2,9 +2,9 @@ package repository
 
 import (
 	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/pkg/apperr"
 	"context"
 	"errors"
-	"fmt"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,6 +12,12 @@ import (
 	"time"
 )
 
+var (
+	ErrUserNotFound  = apperr.New(apperr.NotFound, "user not found")
+	ErrInvalidUserID = apperr.Field("user_id", "must be a UUID")
+)
+
+// UserRepository methods return ErrUserNotFound when no user matches.
 type UserRepository interface {
 	Create(ctx context.Context, user *entity.User) error
 	FindByEmail(ctx context.Context, email string) (*entity.User, error)
@@ -21,7 +27,19 @@ type UserRepository interface {
 	FindAll(ctx context.Context) ([]*entity.User, error)
 	SetVerificationCode(ctx context.Context, email, code string, expires time.Time) error
 	VerifyCode(ctx context.Context, email, code string) (*entity.User, error)
+	UpdateUsername(ctx context.Context, id uuid.UUID, username string) (*entity.User, error)
+	UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error
+	SetPendingEmail(ctx context.Context, id uuid.UUID, email, code string, expires time.Time) (*entity.User, error)
+	ConfirmPendingEmail(ctx context.Context, id uuid.UUID, email string) (*entity.User, error)
+	SetTOTPSecret(ctx context.Context, id uuid.UUID, secret string) error
+	EnableTOTP(ctx context.Context, id uuid.UUID, recoveryCodeHashes []string) error
+	UseRecoveryCode(ctx context.Context, id uuid.UUID, codeHash string) (bool, error)
+	SetTOTPRequired(ctx context.Context, id uuid.UUID, required bool) (*entity.User, error)
+	SetSuspended(ctx context.Context, id uuid.UUID, suspended bool, reason string, at time.Time) (*entity.User, error)
+	SetResetToken(ctx context.Context, id uuid.UUID, tokenHash string, expires time.Time) error
+	ConsumeResetToken(ctx context.Context, tokenHash, passwordHash string, now time.Time) (*entity.User, error)
 	ChangeRole(ctx context.Context, id, role string) (*entity.User, error)
+	CountByRole(ctx context.Context, role string) (int64, error)
 	DeleteUser(ctx context.Context, id string) error
 }
 
@@ -44,21 +62,11 @@ func (u userRepositoryMongo) Create(ctx context.Context, user *entity.User) erro
 }
 
 func (u userRepositoryMongo) FindByEmail(ctx context.Context, email string) (*entity.User, error) {
-	var user entity.User
-	err := u.collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return decodeUser(u.collection.FindOne(ctx, bson.M{"email": email}))
 }
 
 func (u userRepositoryMongo) FindByUsername(ctx context.Context, username string) (*entity.User, error) {
-	var user entity.User
-	err := u.collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
-	if err != nil {
-		return nil, err
-	}
-	return &user, nil
+	return decodeUser(u.collection.FindOne(ctx, bson.M{"username": username}))
 }
 
 func (u userRepositoryMongo) Update(ctx context.Context, user *entity.User) error {
@@ -75,10 +83,11 @@ func (u userRepositoryMongo) Update(ctx context.Context, user *entity.User) erro
 }
 
 func (u userRepositoryMongo) FindByID(ctx context.Context, id string) (*entity.User, error) {
-	var user entity.User
-	uid, _ := uuid.Parse(id)
-	err := u.collection.FindOne(ctx, bson.M{"id": uid}).Decode(&user)
-	return &user, err
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidUserID
+	}
+	return decodeUser(u.collection.FindOne(ctx, bson.M{"id": uid}))
 }
 
 func (u userRepositoryMongo) FindAll(ctx context.Context) ([]*entity.User, error) {
@@ -107,37 +116,136 @@ func (u *userRepositoryMongo) SetVerificationCode(ctx context.Context, email, co
 	return err
 }
 
+// VerifyCode finds the user whose address, or pending new address, is
+// email if code is its unexpired verification code.
 func (u *userRepositoryMongo) VerifyCode(ctx context.Context, email, code string) (*entity.User, error) {
-	var user entity.User
-	err := u.collection.FindOne(ctx, bson.M{
-		"email":        email,
+	return decodeUser(u.collection.FindOne(ctx, bson.M{
+		"$or":          bson.A{bson.M{"email": email}, bson.M{"pending_email": email}},
 		"verif_code":   code,
 		"code_expires": bson.M{"$gte": time.Now()},
-	}).Decode(&user)
-	return &user, err
+	}))
 }
 
-func (u *userRepositoryMongo) ChangeRole(ctx context.Context, id, role string) (*entity.User, error) {
-	uid, err := uuid.Parse(id)
-	if err != nil {
-		return nil, fmt.Errorf("invalid user id: %v", err)
-	}
+func (u *userRepositoryMongo) UpdateUsername(ctx context.Context, id uuid.UUID, username string) (*entity.User, error) {
+	return u.findOneAndSet(ctx, id, bson.M{"username": username})
+}
 
-	filter := bson.M{"id": uid}
-	update := bson.M{"$set": bson.M{"role": role}}
-	after := options.After
-	res := u.collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(after))
+func (u *userRepositoryMongo) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	_, err := u.findOneAndSet(ctx, id, bson.M{"password": passwordHash})
+	return err
+}
+
+// SetPendingEmail stores a new address with the code that confirms it. The
+// current address stays in use until then.
+func (u *userRepositoryMongo) SetPendingEmail(ctx context.Context, id uuid.UUID, email, code string, expires time.Time) (*entity.User, error) {
+	return u.findOneAndSet(ctx, id, bson.M{"pending_email": email, "verif_code": code, "code_expires": expires})
+}
+
+// ConfirmPendingEmail makes the pending address the account's address,
+// provided it is still email.
+func (u *userRepositoryMongo) ConfirmPendingEmail(ctx context.Context, id uuid.UUID, email string) (*entity.User, error) {
+	return decodeUser(u.collection.FindOneAndUpdate(ctx,
+		bson.M{"id": id, "pending_email": email},
+		bson.M{
+			"$set":   bson.M{"email": email, "is_active": true, "verif_code": "", "code_expires": time.Time{}},
+			"$unset": bson.M{"pending_email": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	))
+}
+
+func (u *userRepositoryMongo) findOneAndSet(ctx context.Context, id uuid.UUID, set bson.M) (*entity.User, error) {
+	return decodeUser(u.collection.FindOneAndUpdate(ctx,
+		bson.M{"id": id},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	))
+}
+
+func decodeUser(res *mongo.SingleResult) (*entity.User, error) {
 	var user entity.User
-	if err := res.Decode(&user); err != nil {
+	err := res.Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// SetTOTPSecret stores a pending secret; it is only used once enabled.
+func (u *userRepositoryMongo) SetTOTPSecret(ctx context.Context, id uuid.UUID, secret string) error {
+	_, err := u.collection.UpdateOne(ctx,
+		bson.M{"id": id, "totp_enabled": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"totp_secret": secret}},
+	)
+	return err
+}
+
+func (u *userRepositoryMongo) EnableTOTP(ctx context.Context, id uuid.UUID, recoveryCodeHashes []string) error {
+	_, err := u.findOneAndSet(ctx, id, bson.M{"totp_enabled": true, "recovery_codes": recoveryCodeHashes})
+	return err
+}
+
+// UseRecoveryCode removes the code from the user's unused codes, reporting
+// whether it was there. Concurrent uses of one code cannot both succeed.
+func (u *userRepositoryMongo) UseRecoveryCode(ctx context.Context, id uuid.UUID, codeHash string) (bool, error) {
+	res, err := u.collection.UpdateOne(ctx,
+		bson.M{"id": id, "recovery_codes": codeHash},
+		bson.M{"$pull": bson.M{"recovery_codes": codeHash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (u *userRepositoryMongo) SetTOTPRequired(ctx context.Context, id uuid.UUID, required bool) (*entity.User, error) {
+	return u.findOneAndSet(ctx, id, bson.M{"totp_required": required})
+}
+
+func (u *userRepositoryMongo) SetSuspended(ctx context.Context, id uuid.UUID, suspended bool, reason string, at time.Time) (*entity.User, error) {
+	return u.findOneAndSet(ctx, id, bson.M{"suspended": suspended, "suspended_reason": reason, "suspended_at": at})
+}
+
+func (u *userRepositoryMongo) SetResetToken(ctx context.Context, id uuid.UUID, tokenHash string, expires time.Time) error {
+	_, err := u.collection.UpdateOne(ctx,
+		bson.M{"id": id},
+		bson.M{"$set": bson.M{"reset_token_hash": tokenHash, "reset_expires": expires}},
+	)
+	return err
+}
+
+// ConsumeResetToken sets the new password if tokenHash matches an unexpired
+// reset token, clearing the token in the same update so it works only once.
+func (u *userRepositoryMongo) ConsumeResetToken(ctx context.Context, tokenHash, passwordHash string, now time.Time) (*entity.User, error) {
+	return decodeUser(u.collection.FindOneAndUpdate(ctx,
+		bson.M{"reset_token_hash": tokenHash, "reset_expires": bson.M{"$gte": now}},
+		bson.M{
+			"$set":   bson.M{"password": passwordHash},
+			"$unset": bson.M{"reset_token_hash": "", "reset_expires": ""},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	))
+}
+
+func (u *userRepositoryMongo) CountByRole(ctx context.Context, role string) (int64, error) {
+	return u.collection.CountDocuments(ctx, bson.M{"role": role})
+}
+
+func (u *userRepositoryMongo) ChangeRole(ctx context.Context, id, role string) (*entity.User, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidUserID
+	}
+	return u.findOneAndSet(ctx, uid, bson.M{"role": role})
+}
+
 func (u *userRepositoryMongo) DeleteUser(ctx context.Context, id string) error {
 	uid, err := uuid.Parse(id)
 	if err != nil {
-		return fmt.Errorf("invalid user id: %v", err)
+		return ErrInvalidUserID
 	}
 
 	filter := bson.M{"id": uid}
@@ -146,7 +254,7 @@ func (u *userRepositoryMongo) DeleteUser(ctx context.Context, id string) error {
 		return err
 	}
 	if res.DeletedCount == 0 {
-		return errors.New("user not found")
+		return ErrUserNotFound
 	}
 	return nil
 }
@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/internal/repository"
+	"CarStore/UserService/pkg/apperr"
+	"CarStore/UserService/pkg/auth"
+)
+
+const (
+	roleAdmin = "admin"
+	roleUser  = "user"
+)
+
+var (
+	ErrUnknownPermission = apperr.New(apperr.InvalidArgument, "unknown permission")
+	ErrInvalidRoleName   = apperr.Field("name", "required")
+	// ErrProtectedRole is returned when changing the admin role or deleting
+	// a built-in role.
+	ErrProtectedRole = apperr.New(apperr.FailedPrecondition, "built-in role cannot be changed")
+	ErrRoleInUse     = apperr.New(apperr.FailedPrecondition, "role is assigned to users")
+)
+
+// defaultRoles are created on startup when missing. Once created they are
+// managed through the role RPCs like any other role, except that admin is
+// kept in sync with auth.AllPermissions.
+var defaultRoles = []*entity.Role{
+	{
+		Name:        roleAdmin,
+		Description: "Full access",
+		Permissions: auth.AllPermissions,
+	},
+	{
+		Name:        roleUser,
+		Description: "Customers; assigned on registration",
+		Permissions: []string{auth.PermUsersSelf, auth.PermOrdersCreate, auth.PermOrdersReadOwn, auth.PermOrdersCancelOwn},
+	},
+}
+
+// EnsureDefaultRoles creates the built-in roles that do not exist yet and
+// grants admin any permission added since it was created. New permissions
+// have to be granted to other roles with UpdateRole. Permissions that no
+// longer exist are dropped from every role.
+func (u *UserUsecase) EnsureDefaultRoles(ctx context.Context) error {
+	for _, r := range defaultRoles {
+		role := *r
+		err := u.roles.Create(ctx, &role)
+		if errors.Is(err, repository.ErrRoleExists) && role.Name == roleAdmin {
+			err = u.roles.Update(ctx, &role)
+		}
+		if err != nil && !errors.Is(err, repository.ErrRoleExists) {
+			return err
+		}
+	}
+
+	roles, err := u.roles.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		known := slices.DeleteFunc(slices.Clone(role.Permissions), func(p string) bool { return !auth.IsKnownPermission(p) })
+		if len(known) == len(role.Permissions) {
+			continue
+		}
+		log.Printf("dropping retired permissions from role %q", role.Name)
+		role.Permissions = known
+		if err := u.roles.Update(ctx, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *UserUsecase) ListRoles(ctx context.Context) ([]*entity.Role, error) {
+	return u.roles.FindAll(ctx)
+}
+
+func (u *UserUsecase) CreateRole(ctx context.Context, role *entity.Role) (*entity.Role, error) {
+	if err := validateRole(role); err != nil {
+		return nil, err
+	}
+	if role.Name == auth.RoleTOTPEnrollment {
+		return nil, ErrProtectedRole
+	}
+	if err := u.roles.Create(ctx, role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// UpdateRole replaces the permissions of a role. Users get them with their
+// next access token, at the latest after jwt.AccessTokenTTL.
+func (u *UserUsecase) UpdateRole(ctx context.Context, role *entity.Role) (*entity.Role, error) {
+	if err := validateRole(role); err != nil {
+		return nil, err
+	}
+	// nobody could repair the roles if admin lost roles:admin
+	if role.Name == roleAdmin {
+		return nil, ErrProtectedRole
+	}
+	if err := u.roles.Update(ctx, role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (u *UserUsecase) DeleteRole(ctx context.Context, name string) error {
+	if name == roleAdmin || name == roleUser {
+		return ErrProtectedRole
+	}
+	n, err := u.repo.CountByRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: %d users", ErrRoleInUse, n)
+	}
+	return u.roles.Delete(ctx, name)
+}
+
+// rolePermissions returns the permissions of a role. A role that does not
+// exist grants nothing.
+func (u *UserUsecase) rolePermissions(ctx context.Context, name string) ([]string, error) {
+	role, err := u.roles.FindByName(ctx, name)
+	if errors.Is(err, repository.ErrRoleNotFound) {
+		log.Printf("role %q not found, issuing token without permissions", name)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return role.Permissions, nil
+}
+
+func validateRole(role *entity.Role) error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" {
+		return ErrInvalidRoleName
+	}
+	for _, p := range role.Permissions {
+		if !auth.IsKnownPermission(p) {
+			return fmt.Errorf("%w: %q", ErrUnknownPermission, p)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package usecase
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/internal/repository"
+	"CarStore/UserService/pkg/totp"
+)
+
+// EnrollTOTP starts two-factor enrollment and returns the otpauth URI for
+// the user's authenticator app. Enrollment completes with ConfirmTOTP.
+func (u *UserUsecase) EnrollTOTP(ctx context.Context, userID string) (uri, secret string, err error) {
+	user, err := u.repo.FindByID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user.TOTPEnabled {
+		return "", "", ErrTOTPAlreadyEnabled
+	}
+	secret, err = totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := u.repo.SetTOTPSecret(ctx, user.ID, secret); err != nil {
+		return "", "", err
+	}
+	return totp.URI(totpIssuer, user.Email, secret), secret, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves the
+// authenticator works, and returns one-time recovery codes. They are shown
+// only now; just their hashes are kept.
+func (u *UserUsecase) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := u.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+	err = u.limitTOTPGuesses(ctx, user, func() error { return u.checkTOTP(ctx, user, code) })
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if err := u.repo.EnableTOTP(ctx, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	u.invalidateProfile(ctx, userID)
+	return codes, nil
+}
+
+// VerifyTOTPLogin completes a login started by Login for a two-factor
+// account. code is either a current TOTP code or an unused recovery code.
+func (u *UserUsecase) VerifyTOTPLogin(ctx context.Context, challenge, code string) (*entity.TokenPair, error) {
+	key := mfaChallengeKey(challenge)
+	attempts, err := u.rdb.HIncrBy(ctx, key, "attempts", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+	userID, err := u.rdb.HGet(ctx, key, "user_id").Result()
+	if errors.Is(err, redis.Nil) {
+		// HIncrBy created an empty hash for an unknown challenge
+		u.rdb.Del(ctx, key)
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attempts > mfaChallengeAttempts {
+		u.rdb.Del(ctx, key)
+		return nil, ErrInvalidChallenge
+	}
+
+	user, err := u.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = u.limitTOTPGuesses(ctx, user, func() error { return u.checkSecondFactor(ctx, user, code) })
+	if err != nil {
+		return nil, err
+	}
+	// the challenge is single use
+	if n, err := u.rdb.Del(ctx, key).Result(); err != nil || n == 0 {
+		return nil, ErrInvalidChallenge
+	}
+	return u.startSession(ctx, user)
+}
+
+// RequireTOTP flags an account that must enroll in two-factor
+// authentication. Until it does, its tokens only allow enrolling.
+func (u *UserUsecase) RequireTOTP(ctx context.Context, userID string, required bool) (*entity.User, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, repository.ErrInvalidUserID
+	}
+	updated, err := u.repo.SetTOTPRequired(ctx, uid, required)
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateProfile(ctx, userID)
+	return updated, nil
+}
+
+func (u *UserUsecase) newMFAChallenge(ctx context.Context, userID string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := crand.Read(buf); err != nil {
+		return "", err
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(buf)
+	key := mfaChallengeKey(challenge)
+	pipe := u.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "attempts", 0)
+	pipe.Expire(ctx, key, mfaChallengeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+func (u *UserUsecase) checkSecondFactor(ctx context.Context, user *entity.User, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		return u.checkTOTP(ctx, user, code)
+	}
+	ok, err := u.repo.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// limitTOTPGuesses counts a guess against the user and runs check unless
+// the user is locked out. Wrong codes keep counting; logging in again does
+// not lift the lockout, only a right code does.
+func (u *UserUsecase) limitTOTPGuesses(ctx context.Context, user *entity.User, check func() error) error {
+	key := user.ID.String()
+	if err := u.totpGuesses.Take(ctx, key); err != nil {
+		return err
+	}
+	err := check()
+	switch {
+	case err == nil:
+		return u.totpGuesses.Reset(ctx, key)
+	case !errors.Is(err, ErrInvalidTOTPCode):
+		// no code was judged
+		if ferr := u.totpGuesses.Forgive(ctx, key); ferr != nil {
+			log.Printf("totp guess of user %s left counted: %v", key, ferr)
+		}
+	}
+	return err
+}
+
+// checkTOTP validates a code and refuses a code that was already used.
+func (u *UserUsecase) checkTOTP(ctx context.Context, user *entity.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	// a step stays acceptable for at most (2*skew+1) periods
+	key := fmt.Sprintf("totp:used:%s:%d", user.ID, step)
+	fresh, err := u.rdb.SetNX(ctx, key, 1, 3*totp.Period).Result()
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// newRecoveryCode returns a code like "k3vq-7mzp-2hxa".
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := crand.Read(buf); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))[:12]
+	return s[:4] + "-" + s[4:8] + "-" + s[8:], nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+func mfaChallengeKey(challenge string) string {
+	return "mfa:challenge:" + challenge
+}
@@ -3,41 +3,156 @@ package usecase
 import (
 	"CarStore/UserService/internal/entity"
 	"CarStore/UserService/internal/repository"
+	"CarStore/UserService/pkg/apperr"
+	"CarStore/UserService/pkg/auth"
 	"CarStore/UserService/pkg/email"
+	"CarStore/UserService/pkg/jwt"
+	"CarStore/UserService/pkg/limiter"
+	"CarStore/UserService/pkg/session"
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"log"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	resetTokenTTL = 30 * time.Minute
+
+	totpIssuer           = "CarStore"
+	mfaChallengeTTL      = 5 * time.Minute
+	mfaChallengeAttempts = 5
+	recoveryCodeCount    = 10
+
+	verificationCodeTTL = 15 * time.Minute
+
+	// Emails whose timing could tell whether an account exists are sent
+	// in the background, at most maxPendingSends at a time.
+	maxPendingSends       = 16
+	backgroundSendTimeout = 30 * time.Second
+)
+
+// Throttling of guessable secrets. Logins are counted per user, whichever
+// identifier names them, and per normalized identifier for identifiers of
+// no account; both lock out alike, so lockouts reveal nothing.
+var (
+	loginAccountPolicy = limiter.Policy{Free: 5, Window: time.Hour, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute}
+	loginIPPolicy      = limiter.Policy{Free: 20, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	codeGuessPolicy    = limiter.Policy{Free: 5, Window: verificationCodeTTL, BaseDelay: time.Minute, MaxDelay: verificationCodeTTL}
+	codeSendPolicy     = limiter.Policy{Free: 3, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	sendIPPolicy       = limiter.Policy{Free: 10, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	// Wrong second factors are counted per user, since every password
+	// login mints a fresh challenge with its own attempts.
+	totpGuessPolicy = limiter.Policy{Free: 5, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour}
+)
+
+var (
+	// ErrInvalidCredentials is returned for unknown accounts and wrong
+	// passwords alike.
+	ErrInvalidCredentials = apperr.New(apperr.Unauthenticated, "invalid credentials")
+	ErrInvalidCode        = apperr.New(apperr.InvalidArgument, "invalid or expired verification code")
+	// ErrEmailNotVerified carries a reason so clients can offer to resend
+	// the verification code.
+	ErrEmailNotVerified = &apperr.Error{Kind: apperr.FailedPrecondition, Message: "email not verified", Reason: "EMAIL_NOT_VERIFIED"}
+	ErrAccountSuspended = &apperr.Error{Kind: apperr.PermissionDenied, Message: "account suspended", Reason: "ACCOUNT_SUSPENDED"}
+	// ErrTooManyAttempts matches the *limiter.LockedError returned while
+	// an account, address or client is locked out.
+	ErrTooManyAttempts = limiter.ErrLocked
+
+	ErrInvalidResetToken = apperr.New(apperr.InvalidArgument, "invalid or expired reset token")
+	ErrWrongPassword     = apperr.New(apperr.PermissionDenied, "current password is incorrect")
+	ErrEmailTaken        = apperr.New(apperr.AlreadyExists, "email already in use")
+	ErrUsernameTaken     = apperr.New(apperr.AlreadyExists, "username already in use")
+	ErrUserIDRequired    = apperr.Field("user_id", "required")
+
+	ErrTOTPAlreadyEnabled = apperr.New(apperr.FailedPrecondition, "two-factor authentication already enabled")
+	ErrTOTPNotEnrolled    = apperr.New(apperr.FailedPrecondition, "two-factor enrollment not started")
+	ErrInvalidTOTPCode    = apperr.New(apperr.Unauthenticated, "invalid two-factor code")
+	ErrInvalidChallenge   = apperr.New(apperr.Unauthenticated, "invalid or expired login challenge")
+)
+
 type JWTService interface {
-	GenerateToken(userID string, role string) (string, error)
+	GenerateToken(userID, role, sessionID string, permissions []string) (string, error)
+	JWKS() []jwt.JWK
+}
+
+// SessionStore keeps refresh token families and access token revocations.
+type SessionStore interface {
+	Start(ctx context.Context, userID string) (string, *session.Session, error)
+	Rotate(ctx context.Context, refreshToken string) (string, *session.Session, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserSessions(ctx context.Context, userID string, before time.Time) error
+	RevokeOtherSessions(ctx context.Context, userID, keepSessionID string) error
+	SetSuspended(ctx context.Context, userID string, suspended bool) error
 }
 
 type UserUsecase struct {
 	repo        repository.UserRepository
+	roles       repository.RoleRepository
 	jwtSvc      JWTService
+	sessions    SessionStore
 	emailSender email.Sender
 	rdb         *redis.Client
+
+	adminTOTPRequired bool
+
+	loginAccounts *limiter.Limiter
+	loginIPs      *limiter.Limiter
+	codeGuesses   *limiter.Limiter
+	codeSends     *limiter.Limiter
+	resetSends    *limiter.Limiter
+	sendIPs       *limiter.Limiter
+	totpGuesses   *limiter.Limiter
+
+	sendSlots  chan struct{}
+	background sync.WaitGroup
 }
 
-func NewUserUsecase(r repository.UserRepository, j JWTService, e email.Sender, rdb *redis.Client) *UserUsecase {
-	return &UserUsecase{repo: r, jwtSvc: j, emailSender: e, rdb: rdb}
+func NewUserUsecase(r repository.UserRepository, roles repository.RoleRepository, j JWTService, s SessionStore, e email.Sender, rdb *redis.Client) *UserUsecase {
+	return &UserUsecase{
+		repo:          r,
+		roles:         roles,
+		jwtSvc:        j,
+		sessions:      s,
+		emailSender:   e,
+		rdb:           rdb,
+		loginAccounts: limiter.New(rdb, "login_account", loginAccountPolicy),
+		loginIPs:      limiter.New(rdb, "login_ip", loginIPPolicy),
+		codeGuesses:   limiter.New(rdb, "code_guess", codeGuessPolicy),
+		codeSends:     limiter.New(rdb, "code_send", codeSendPolicy),
+		resetSends:    limiter.New(rdb, "reset_send", codeSendPolicy),
+		sendIPs:       limiter.New(rdb, "send_ip", sendIPPolicy),
+		totpGuesses:   limiter.New(rdb, "totp_guess", totpGuessPolicy),
+		sendSlots:     make(chan struct{}, maxPendingSends),
+	}
+}
+
+// RequireAdminTOTP makes two-factor enrollment mandatory for every admin
+// account, in addition to accounts flagged through RequireTOTP.
+func (u *UserUsecase) RequireAdminTOTP(required bool) {
+	u.adminTOTPRequired = required
 }
 
 func (u *UserUsecase) Register(ctx context.Context, email, username, password, role string) error {
+	email = normalizeIdentifier(email)
 	//Checking for unique username and email
-	if _, err := u.repo.FindByEmail(ctx, email); err == nil {
-		return errors.New("email already in use")
+	if err := u.checkUnused(ctx, u.repo.FindByEmail, email, ErrEmailTaken); err != nil {
+		return err
 	}
-	if _, err := u.repo.FindByUsername(ctx, username); err == nil {
-		return errors.New("username already in use")
+	if err := u.checkUnused(ctx, u.repo.FindByUsername, username, ErrUsernameTaken); err != nil {
+		return err
 	}
 
 	//hashing password
@@ -60,41 +175,167 @@ func (u *UserUsecase) Register(ctx context.Context, email, username, password, r
 		return err
 	}
 
-	_, err = u.SendVerificationCode(ctx, email)
-	return err
+	if err := u.throttleSend(ctx, u.codeSends, email, ""); err != nil {
+		return err
+	}
+	return u.sendVerificationCode(ctx, email, u.storeVerificationCode(ctx, email))
+}
 
-	/*token, err := u.jwtSvc.GenerateToken(user.ID.String(), user.Role)
-	if err != nil {
-		return "", err
+// checkUnused returns taken if find finds a user, and nil if it finds none.
+func (u *UserUsecase) checkUnused(ctx context.Context, find func(context.Context, string) (*entity.User, error), value string, taken error) error {
+	_, err := find(ctx, value)
+	if err == nil {
+		return taken
 	}
-	return token, nil*/
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
+	}
+	return err
 }
 
-func (u *UserUsecase) Login(ctx context.Context, identifier, password string) (string, error) {
+// Login checks the credentials and starts a new session. Attempts are
+// counted per account and per client IP before the password is checked,
+// and both back off exponentially once their free attempts are used up.
+func (u *UserUsecase) Login(ctx context.Context, identifier, password, clientIP string) (*entity.TokenPair, error) {
 	var user *entity.User
 	var err error
 
 	if strings.Contains(identifier, "@") {
-		user, err = u.repo.FindByEmail(ctx, identifier)
+		user, err = u.repo.FindByEmail(ctx, normalizeIdentifier(identifier))
 	} else {
 		user, err = u.repo.FindByUsername(ctx, identifier)
 	}
-	if err != nil {
-		return "", errors.New("user not found")
+	if errors.Is(err, repository.ErrUserNotFound) {
+		user = nil
+	} else if err != nil {
+		return nil, err
+	}
+	account := "identifier:" + normalizeIdentifier(identifier)
+	if user != nil {
+		account = loginAccount(user)
+	}
+	if err := u.takeAttempt(ctx, u.loginAccounts, account, clientIP); err != nil {
+		return nil, err
+	}
+	if user == nil {
+		// spend the same time as for a wrong password
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+	if err := u.attemptSucceeded(ctx, u.loginAccounts, account, clientIP); err != nil {
+		return nil, err
+	}
+	// only reported once the password is known to be right
+	if err := checkAccountStatus(user); err != nil {
+		return nil, err
 	}
 
-	return u.jwtSvc.GenerateToken(user.ID.String(), user.Role)
+	if user.TOTPEnabled {
+		challenge, err := u.newMFAChallenge(ctx, user.ID.String())
+		if err != nil {
+			return nil, err
+		}
+		return &entity.TokenPair{ChallengeToken: challenge}, nil
+	}
+	return u.startSession(ctx, user)
+}
+
+// Refresh rotates a refresh token and issues a new access token for the
+// same session. The role is re-read, so role changes apply on refresh.
+func (u *UserUsecase) Refresh(ctx context.Context, refreshToken string) (*entity.TokenPair, error) {
+	next, sess, err := u.sessions.Rotate(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	user, err := u.repo.FindByID(ctx, sess.UserID)
+	if err != nil {
+		// the user is gone; nothing may be issued for this session anymore
+		if err := u.sessions.RevokeSession(ctx, sess.SessionID); err != nil {
+			return nil, err
+		}
+		return nil, session.ErrInvalidRefreshToken
+	}
+	if user.Suspended {
+		if err := u.sessions.RevokeSession(ctx, sess.SessionID); err != nil {
+			return nil, err
+		}
+		return nil, ErrAccountSuspended
+	}
+	access, err := u.accessToken(ctx, user, sess.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.TokenPair{AccessToken: access, RefreshToken: next, ExpiresIn: jwt.AccessTokenTTL}, nil
+}
+
+// Logout revokes the access token it was called with and ends its session,
+// invalidating the session's refresh tokens.
+func (u *UserUsecase) Logout(ctx context.Context, jti, sessionID string, expiresAt time.Time) error {
+	if err := u.sessions.RevokeToken(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+	if sessionID == "" {
+		return nil
+	}
+	return u.sessions.RevokeSession(ctx, sessionID)
+}
+
+// JWKS returns the public keys access tokens can be verified with.
+func (u *UserUsecase) JWKS() []jwt.JWK {
+	return u.jwtSvc.JWKS()
+}
+
+func (u *UserUsecase) startSession(ctx context.Context, user *entity.User) (*entity.TokenPair, error) {
+	if err := checkAccountStatus(user); err != nil {
+		return nil, err
+	}
+	refresh, sess, err := u.sessions.Start(ctx, user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	access, err := u.accessToken(ctx, user, sess.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresIn: jwt.AccessTokenTTL}, nil
+}
+
+// checkAccountStatus refuses sessions for suspended accounts and for
+// accounts whose email is not verified yet.
+func checkAccountStatus(user *entity.User) error {
+	if user.Suspended {
+		return ErrAccountSuspended
+	}
+	if !user.IsActive {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// accessToken issues an access token carrying the user's role and its
+// permissions. Accounts that must enroll in two-factor authentication get a
+// role without permissions, which only allows enrolling.
+func (u *UserUsecase) accessToken(ctx context.Context, user *entity.User, sessionID string) (string, error) {
+	mustEnroll := user.TOTPRequired || (u.adminTOTPRequired && user.Role == roleAdmin)
+	if mustEnroll && !user.TOTPEnabled {
+		return u.jwtSvc.GenerateToken(user.ID.String(), auth.RoleTOTPEnrollment, sessionID, nil)
+	}
+	perms, err := u.rolePermissions(ctx, user.Role)
+	if err != nil {
+		return "", err
+	}
+	return u.jwtSvc.GenerateToken(user.ID.String(), user.Role, sessionID, perms)
 }
 
 func (u *UserUsecase) Profile(ctx context.Context, id string) (*entity.User, error) {
 	if id == "" {
-		return nil, errors.New("user id required")
+		return nil, ErrUserIDRequired
 	}
-	key := "user:profile:" + id
+	key := profileKey(id)
 
 	data, err := u.rdb.Get(ctx, key).Bytes()
 	if err == nil {
@@ -115,70 +356,428 @@ func (u *UserUsecase) Profile(ctx context.Context, id string) (*entity.User, err
 	return user, nil
 }
 
+// UpdateProfile changes the user's username.
+func (u *UserUsecase) UpdateProfile(ctx context.Context, userID, username string) (*entity.User, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, repository.ErrInvalidUserID
+	}
+	other, err := u.repo.FindByUsername(ctx, username)
+	if err == nil && other.ID != uid {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return nil, err
+	}
+	updated, err := u.repo.UpdateUsername(ctx, uid, username)
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateProfile(ctx, userID)
+	return updated, nil
+}
+
+// ChangePassword replaces the password after checking the current one, and
+// signs out every session except the one making the change. The strength of
+// new passwords is checked on the request, by the (user.password) rule.
+func (u *UserUsecase) ChangePassword(ctx context.Context, userID, sessionID, current, newPassword string) error {
+	user, err := u.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+		return ErrWrongPassword
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := u.repo.UpdatePassword(ctx, user.ID, string(hashed)); err != nil {
+		return err
+	}
+	return u.sessions.RevokeOtherSessions(ctx, userID, sessionID)
+}
+
+// ChangeEmail sends a code to a new address. The account keeps its current
+// address until the code is confirmed with ConfirmEmail, so a mistyped
+// address cannot lock the user out.
+func (u *UserUsecase) ChangeEmail(ctx context.Context, userID, newEmail string) (*entity.User, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, repository.ErrInvalidUserID
+	}
+	newEmail = normalizeIdentifier(newEmail)
+	if err := u.checkUnused(ctx, u.repo.FindByEmail, newEmail, ErrEmailTaken); err != nil {
+		return nil, err
+	}
+	if err := u.throttleSend(ctx, u.codeSends, newEmail, ""); err != nil {
+		return nil, err
+	}
+	var updated *entity.User
+	err = u.sendVerificationCode(ctx, newEmail, func(code string, expires time.Time) error {
+		var err error
+		updated, err = u.repo.SetPendingEmail(ctx, uid, newEmail, code, expires)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateProfile(ctx, userID)
+	return updated, nil
+}
+
 func (u *UserUsecase) List(ctx context.Context) ([]*entity.User, error) {
 	return u.repo.FindAll(ctx)
 }
 
-func (u *UserUsecase) SendVerificationCode(ctx context.Context, email string) (string, error) {
+// SendVerificationCode emails a new code to confirm the address of an
+// account that is not verified yet. Sends are throttled per address and
+// per client IP before the account is looked up, and the lookup and the
+// email happen in the background, so neither the answer nor its timing
+// tells whether the address is registered or verified.
+func (u *UserUsecase) SendVerificationCode(ctx context.Context, email, clientIP string) error {
+	email = normalizeIdentifier(email)
+	if err := u.throttleSend(ctx, u.codeSends, email, clientIP); err != nil {
+		return err
+	}
+	return u.inBackground(ctx, "verification code for "+email, func(ctx context.Context) error {
+		user, err := u.repo.FindByEmail(ctx, email)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if user.IsActive {
+			return nil
+		}
+		return u.sendVerificationCode(ctx, email, u.storeVerificationCode(ctx, email))
+	})
+}
+
+// throttleSend counts an email to email against the client, if known, and
+// in sends against the address, and fails if either is locked out.
+func (u *UserUsecase) throttleSend(ctx context.Context, sends *limiter.Limiter, email, clientIP string) error {
+	if clientIP != "" {
+		if err := u.sendIPs.Take(ctx, clientIP); err != nil {
+			return err
+		}
+	}
+	return sends.Take(ctx, normalizeIdentifier(email))
+}
+
+// sendVerificationCode emails a new code to email after store saved it.
+// Callers throttle the send with throttleSend first.
+func (u *UserUsecase) sendVerificationCode(ctx context.Context, email string, store func(code string, expires time.Time) error) error {
 	code := fmt.Sprintf("%06d", rand.Intn(1_000_000))
-	expires := time.Now().Add(15 * time.Minute)
-	if err := u.repo.SetVerificationCode(ctx, email, code, expires); err != nil {
-		return "", err
+	expires := time.Now().Add(verificationCodeTTL)
+	if err := store(code, expires); err != nil {
+		return err
 	}
-	body := fmt.Sprintf("Your verification code is %s. It expires in 15m.", code)
-	if err := u.emailSender.Send(email, "Verify your account", body); err != nil {
-		return "", err
+	body := fmt.Sprintf("Your verification code is %s. It expires in %s.", code, expiresIn(verificationCodeTTL))
+	return u.emailSender.Send(email, "Verify your account", body)
+}
+
+func (u *UserUsecase) storeVerificationCode(ctx context.Context, email string) func(string, time.Time) error {
+	return func(code string, expires time.Time) error {
+		return u.repo.SetVerificationCode(ctx, email, code, expires)
 	}
+}
 
-	// invalidate cache if exists
-	if u.rdb != nil {
-		u.rdb.Del(ctx, fmt.Sprintf("user:profile:%s", email))
+// ConfirmEmail activates the account if code matches, or moves it to the
+// address given to ChangeEmail if that is the one confirmed. It starts no
+// session: proving access to the mailbox is not a login, so the user signs
+// in with the password and second factor afterwards. Wrong codes are
+// throttled like passwords, since six digits are easy to guess.
+func (u *UserUsecase) ConfirmEmail(ctx context.Context, email, code, clientIP string) error {
+	address := normalizeIdentifier(email)
+	if err := u.takeAttempt(ctx, u.codeGuesses, address, clientIP); err != nil {
+		return err
+	}
+	user, err := u.repo.VerifyCode(ctx, address, code)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		// no code was judged
+		u.forgiveAttempt(ctx, u.codeGuesses, address, clientIP)
+		return err
 	}
-	return "code_sent", nil
+	if err := u.attemptSucceeded(ctx, u.codeGuesses, address, clientIP); err != nil {
+		return err
+	}
+	if user.PendingEmail != "" && user.PendingEmail == address {
+		// the address may have been registered since the change was asked for
+		if err := u.checkUnused(ctx, u.repo.FindByEmail, address, ErrEmailTaken); err != nil {
+			return err
+		}
+		if _, err := u.repo.ConfirmPendingEmail(ctx, user.ID, address); err != nil {
+			return err
+		}
+	} else {
+		user.IsActive = true
+		user.VerificationCode = ""
+		user.CodeExpiresAt = time.Time{}
+		if err := u.repo.Update(ctx, user); err != nil {
+			return err
+		}
+	}
+	u.invalidateProfile(ctx, user.ID.String())
+	return nil
 }
 
-func (u *UserUsecase) ConfirmEmail(ctx context.Context, email, code string) (string, error) {
-	user, err := u.repo.VerifyCode(ctx, email, code)
+// UnlockAccount lifts the login, verification code and second factor
+// lockouts of a user.
+// Lockouts of client IPs are left alone.
+func (u *UserUsecase) UnlockAccount(ctx context.Context, userID string) error {
+	user, err := u.repo.FindByID(ctx, userID)
 	if err != nil {
-		return "", err
+		return err
 	}
-	user.IsActive = true
-	user.VerificationCode = ""
-	user.CodeExpiresAt = time.Time{}
-	if err := u.repo.Update(ctx, user); err != nil {
-		return "", err
+	if err := u.loginAccounts.Reset(ctx, loginAccount(user)); err != nil {
+		return err
+	}
+	if err := u.codeGuesses.Reset(ctx, normalizeIdentifier(user.Email)); err != nil {
+		return err
+	}
+	if err := u.totpGuesses.Reset(ctx, user.ID.String()); err != nil {
+		return err
+	}
+	if err := u.resetSends.Reset(ctx, normalizeIdentifier(user.Email)); err != nil {
+		return err
+	}
+	return u.codeSends.Reset(ctx, normalizeIdentifier(user.Email))
+}
+
+// takeAttempt counts an attempt against the client, then the account, and
+// fails if either is locked out. Failed attempts need no further action.
+func (u *UserUsecase) takeAttempt(ctx context.Context, accounts *limiter.Limiter, account, clientIP string) error {
+	if clientIP != "" {
+		if err := u.loginIPs.Take(ctx, clientIP); err != nil {
+			return err
+		}
+	}
+	return accounts.Take(ctx, account)
+}
+
+// attemptSucceeded clears the failures of the account, and takes back the
+// attempt from the client, whose other failures keep counting.
+func (u *UserUsecase) attemptSucceeded(ctx context.Context, accounts *limiter.Limiter, account, clientIP string) error {
+	if err := accounts.Reset(ctx, account); err != nil {
+		return err
+	}
+	if clientIP == "" {
+		return nil
+	}
+	return u.loginIPs.Forgive(ctx, clientIP)
+}
+
+// forgiveAttempt takes back an attempt that failed before the secret was
+// checked, so that an outage does not lock anyone out.
+func (u *UserUsecase) forgiveAttempt(ctx context.Context, accounts *limiter.Limiter, account, clientIP string) {
+	if err := accounts.Forgive(ctx, account); err != nil {
+		log.Printf("attempt for %s left counted: %v", account, err)
+	}
+	if clientIP == "" {
+		return
+	}
+	if err := u.loginIPs.Forgive(ctx, clientIP); err != nil {
+		log.Printf("attempt from %s left counted: %v", clientIP, err)
+	}
+}
+
+// loginAccount is the key that login attempts for user are counted under.
+func loginAccount(user *entity.User) string {
+	return "user:" + user.ID.String()
+}
+
+func normalizeIdentifier(identifier string) string {
+	return strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// dummyPasswordHash is compared against when the account does not exist.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// RequestPasswordReset emails a single-use reset token to the account's
+// address. Requests are throttled per address and per client IP before the
+// account is looked up, and the lookup and the email happen in the
+// background, so neither the answer nor its timing tells whether the email
+// is registered.
+func (u *UserUsecase) RequestPasswordReset(ctx context.Context, email, clientIP string) error {
+	if err := u.throttleSend(ctx, u.resetSends, email, clientIP); err != nil {
+		return err
+	}
+	return u.inBackground(ctx, "password reset for "+email, func(ctx context.Context) error {
+		return u.sendResetToken(ctx, email)
+	})
+}
+
+// Wait blocks until the emails queued in the background have been sent or
+// have failed. The server calls it on shutdown, once no more requests come.
+func (u *UserUsecase) Wait() {
+	u.background.Wait()
+}
+
+// inBackground runs send after the request has been answered, once one of
+// the maxPendingSends slots is free. Its failure is only logged, as what.
+func (u *UserUsecase) inBackground(ctx context.Context, what string, send func(ctx context.Context) error) error {
+	select {
+	case u.sendSlots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	u.background.Add(1)
+	go func() {
+		defer u.background.Done()
+		defer func() { <-u.sendSlots }()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundSendTimeout)
+		defer cancel()
+		if err := send(ctx); err != nil {
+			log.Printf("%s failed: %v", what, err)
+		}
+	}()
+	return nil
+}
+
+func (u *UserUsecase) sendResetToken(ctx context.Context, email string) error {
+	user, err := u.repo.FindByEmail(ctx, normalizeIdentifier(email))
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
 	}
-	token, err := u.jwtSvc.GenerateToken(user.ID.String(), user.Role)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := crand.Read(buf); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	if err := u.repo.SetResetToken(ctx, user.ID, hashResetToken(token), time.Now().Add(resetTokenTTL)); err != nil {
+		return err
 	}
-	u.rdb.Del(ctx, fmt.Sprintf("user:profile:%s", user.ID.String()))
-	return token, nil
+	body := fmt.Sprintf("Use this code to reset your password: %s\nIt expires in %s. If you did not ask for a reset, ignore this email.", token, expiresIn(resetTokenTTL))
+	return u.emailSender.Send(user.Email, "Reset your password", body)
+}
+
+// expiresIn words the lifetime of a code for an email, e.g. "30 minutes".
+func expiresIn(d time.Duration) string {
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
+
+// ResetPassword sets a new password using a token from RequestPasswordReset
+// and signs the user out of every existing session.
+func (u *UserUsecase) ResetPassword(ctx context.Context, token, newPassword string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	user, err := u.repo.ConsumeResetToken(ctx, hashResetToken(token), string(hashed), now)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+	if err := u.sessions.RevokeUserSessions(ctx, user.ID.String(), now); err != nil {
+		return err
+	}
+	u.invalidateProfile(ctx, user.ID.String())
+	return nil
+}
+
+// Only the hash of a reset token is stored, like a password.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 func (u *UserUsecase) ChangeUserRole(ctx context.Context, userID, newRole string) (*entity.User, error) {
-	if newRole != "admin" && newRole != "user" {
-		return nil, errors.New("invalid role")
+	if _, err := u.roles.FindByName(ctx, newRole); err != nil {
+		return nil, err
 	}
 	updated, err := u.repo.ChangeRole(ctx, userID, newRole)
 	if err != nil {
 		return nil, err
 	}
-	key := fmt.Sprintf("user:profile:%s", userID)
-	u.rdb.Del(ctx, key)
+	u.invalidateProfile(ctx, userID)
+	return updated, nil
+}
+
+// SuspendUser blocks an account: it can no longer log in, its sessions are
+// revoked and services reject its remaining access tokens.
+func (u *UserUsecase) SuspendUser(ctx context.Context, userID, reason string) (*entity.User, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, repository.ErrInvalidUserID
+	}
+	now := time.Now()
+	updated, err := u.setSuspended(ctx, uid, true, reason, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	if err := u.sessions.RevokeUserSessions(ctx, userID, now); err != nil {
+		return nil, err
+	}
+	u.invalidateProfile(ctx, userID)
+	return updated, nil
+}
+
+// ReactivateUser lifts a suspension. The user has to log in again.
+func (u *UserUsecase) ReactivateUser(ctx context.Context, userID string) (*entity.User, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, repository.ErrInvalidUserID
+	}
+	updated, err := u.setSuspended(ctx, uid, false, "", time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	u.invalidateProfile(ctx, userID)
+	return updated, nil
+}
+
+// setSuspended records a suspension in Mongo, which logins check, and in
+// Redis, which services check tokens against. If Redis fails, the Mongo
+// write is undone so that the two agree, and the error is returned.
+func (u *UserUsecase) setSuspended(ctx context.Context, uid uuid.UUID, suspended bool, reason string, at time.Time) (*entity.User, error) {
+	before, err := u.repo.FindByID(ctx, uid.String())
+	if err != nil {
+		return nil, err
+	}
+	updated, err := u.repo.SetSuspended(ctx, uid, suspended, reason, at)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.sessions.SetSuspended(ctx, uid.String(), suspended); err != nil {
+		// undo even if the caller gave up
+		undo := context.WithoutCancel(ctx)
+		if _, uerr := u.repo.SetSuspended(undo, uid, before.Suspended, before.SuspendedReason, before.SuspendedAt); uerr != nil {
+			log.Printf("suspension of user %s left in Mongo only: %v", uid, uerr)
+		}
+		return nil, err
+	}
 	return updated, nil
 }
 
 func (u *UserUsecase) DeleteUser(ctx context.Context, userID string) error {
 	if userID == "" {
-		return errors.New("user id required")
+		return ErrUserIDRequired
 	}
 
 	if err := u.repo.DeleteUser(ctx, userID); err != nil {
 		return err
 	}
 
-	key := fmt.Sprintf("user:profile:%s", userID)
-	u.rdb.Del(ctx, key)
+	u.invalidateProfile(ctx, userID)
 	return nil
 }
+
+func profileKey(userID string) string {
+	return "user:profile:" + userID
+}
+
+// invalidateProfile drops the cached profile, which is keyed by user id.
+func (u *UserUsecase) invalidateProfile(ctx context.Context, userID string) {
+	if u.rdb != nil {
+		u.rdb.Del(ctx, profileKey(userID))
+	}
+}
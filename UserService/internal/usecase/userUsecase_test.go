@@ -2,6 +2,11 @@ package usecase
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,10 +14,15 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 
 	"CarStore/UserService/internal/entity"
+	"CarStore/UserService/internal/repository"
+	"CarStore/UserService/pkg/auth"
 	"CarStore/UserService/pkg/email"
 	"CarStore/UserService/pkg/jwt"
+	"CarStore/UserService/pkg/session"
+	"CarStore/UserService/pkg/totp"
 )
 
 // mockRepo implements repository.UserRepository for testing.
@@ -34,8 +44,14 @@ func (m *mockRepo) Update(ctx context.Context, u *entity.User) error {
 	m.user = u
 	return nil
 }
+
+// FindByID returns a copy, as Mongo would.
 func (m *mockRepo) FindByID(ctx context.Context, id string) (*entity.User, error) {
-	return m.user, m.err
+	if m.user == nil || m.err != nil {
+		return nil, m.err
+	}
+	cp := *m.user
+	return &cp, nil
 }
 func (m *mockRepo) FindAll(ctx context.Context) ([]*entity.User, error) {
 	return []*entity.User{m.user}, nil
@@ -49,7 +65,140 @@ func (m *mockRepo) VerifyCode(ctx context.Context, email, code string) (*entity.
 	if m.user.VerificationCode == code && time.Now().Before(m.user.CodeExpiresAt) {
 		return m.user, nil
 	}
-	return nil, m.err
+	if m.err != nil {
+		return nil, m.err
+	}
+	return nil, repository.ErrUserNotFound
+}
+func (m *mockRepo) ChangeRole(ctx context.Context, id, role string) (*entity.User, error) {
+	m.user.Role = role
+	return m.user, m.err
+}
+func (m *mockRepo) DeleteUser(ctx context.Context, id string) error {
+	return m.err
+}
+func (m *mockRepo) UpdateUsername(ctx context.Context, id uuid.UUID, username string) (*entity.User, error) {
+	m.user.Username = username
+	return m.user, nil
+}
+func (m *mockRepo) UpdatePassword(ctx context.Context, id uuid.UUID, passwordHash string) error {
+	m.user.Password = passwordHash
+	return nil
+}
+func (m *mockRepo) SetPendingEmail(ctx context.Context, id uuid.UUID, email, code string, expires time.Time) (*entity.User, error) {
+	m.user.PendingEmail = email
+	m.user.VerificationCode = code
+	m.user.CodeExpiresAt = expires
+	return m.user, nil
+}
+func (m *mockRepo) ConfirmPendingEmail(ctx context.Context, id uuid.UUID, email string) (*entity.User, error) {
+	if m.user.PendingEmail != email {
+		return nil, repository.ErrUserNotFound
+	}
+	m.user.Email = email
+	m.user.PendingEmail = ""
+	m.user.IsActive = true
+	m.user.VerificationCode = ""
+	return m.user, nil
+}
+func (m *mockRepo) SetResetToken(ctx context.Context, id uuid.UUID, tokenHash string, expires time.Time) error {
+	m.user.ResetTokenHash = tokenHash
+	m.user.ResetExpiresAt = expires
+	return nil
+}
+func (m *mockRepo) ConsumeResetToken(ctx context.Context, tokenHash, passwordHash string, now time.Time) (*entity.User, error) {
+	if m.user.ResetTokenHash == "" || m.user.ResetTokenHash != tokenHash || now.After(m.user.ResetExpiresAt) {
+		return nil, repository.ErrUserNotFound
+	}
+	m.user.Password = passwordHash
+	m.user.ResetTokenHash = ""
+	m.user.ResetExpiresAt = time.Time{}
+	return m.user, nil
+}
+func (m *mockRepo) SetTOTPSecret(ctx context.Context, id uuid.UUID, secret string) error {
+	m.user.TOTPSecret = secret
+	return nil
+}
+func (m *mockRepo) EnableTOTP(ctx context.Context, id uuid.UUID, recoveryCodeHashes []string) error {
+	m.user.TOTPEnabled = true
+	m.user.RecoveryCodes = recoveryCodeHashes
+	return nil
+}
+func (m *mockRepo) UseRecoveryCode(ctx context.Context, id uuid.UUID, codeHash string) (bool, error) {
+	for i, h := range m.user.RecoveryCodes {
+		if h == codeHash {
+			m.user.RecoveryCodes = append(m.user.RecoveryCodes[:i], m.user.RecoveryCodes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (m *mockRepo) SetTOTPRequired(ctx context.Context, id uuid.UUID, required bool) (*entity.User, error) {
+	m.user.TOTPRequired = required
+	return m.user, nil
+}
+func (m *mockRepo) SetSuspended(ctx context.Context, id uuid.UUID, suspended bool, reason string, at time.Time) (*entity.User, error) {
+	m.user.Suspended = suspended
+	m.user.SuspendedReason = reason
+	m.user.SuspendedAt = at
+	return m.user, nil
+}
+func (m *mockRepo) CountByRole(ctx context.Context, role string) (int64, error) {
+	if m.user.Role == role {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// memoryRoles implements repository.RoleRepository in memory.
+type memoryRoles map[string]*entity.Role
+
+func (m memoryRoles) FindByName(ctx context.Context, name string) (*entity.Role, error) {
+	r, ok := m[name]
+	if !ok {
+		return nil, repository.ErrRoleNotFound
+	}
+	return r, nil
+}
+func (m memoryRoles) FindAll(ctx context.Context) ([]*entity.Role, error) {
+	var roles []*entity.Role
+	for _, r := range m {
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+func (m memoryRoles) Create(ctx context.Context, role *entity.Role) error {
+	if _, ok := m[role.Name]; ok {
+		return repository.ErrRoleExists
+	}
+	m[role.Name] = role
+	return nil
+}
+func (m memoryRoles) Update(ctx context.Context, role *entity.Role) error {
+	if _, ok := m[role.Name]; !ok {
+		return repository.ErrRoleNotFound
+	}
+	m[role.Name] = role
+	return nil
+}
+func (m memoryRoles) Delete(ctx context.Context, name string) error {
+	if _, ok := m[name]; !ok {
+		return repository.ErrRoleNotFound
+	}
+	delete(m, name)
+	return nil
+}
+
+// recordingSender keeps sent emails instead of delivering them.
+type recordingSender struct {
+	to     []string
+	bodies []string
+}
+
+func (r *recordingSender) Send(to, subject, body string) error {
+	r.to = append(r.to, to)
+	r.bodies = append(r.bodies, body)
+	return nil
 }
 
 // setupUsecaseWithRedis returns a UserUsecase wired with an in-memory Redis and a stub repo,
@@ -65,21 +214,30 @@ func setupUsecaseWithRedis(t *testing.T) (*UserUsecase, *miniredis.Miniredis, st
 		Addr: mredis.Addr(),
 	})
 
-	jwtSvc := jwt.NewJWTService("testsecret", "UserService")
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signingKey, err := jwt.NewPrivateKey("test", priv)
+	assert.NoError(t, err)
+	jwtSvc := jwt.NewJWTService("UserService", signingKey)
 	emailSvc := email.NewConsoleSender()
 
 	// stub user with a real UUID
 	stubID := uuid.New()
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
 	stubUser := &entity.User{
 		ID:               stubID,
 		Email:            "a@b.com",
 		Username:         "u1",
+		Password:         string(hashed),
+		Role:             "user",
 		IsActive:         true,
 		VerificationCode: "",
 	}
 	repo := &mockRepo{user: stubUser, err: nil}
 
-	uc := NewUserUsecase(repo, jwtSvc, emailSvc, rdb)
+	uc := NewUserUsecase(repo, memoryRoles{}, jwtSvc, session.NewStore(rdb), emailSvc, rdb)
+	assert.NoError(t, uc.EnsureDefaultRoles(context.Background()))
 	return uc, mredis, stubID.String()
 }
 
@@ -129,3 +287,581 @@ func TestRegister_Validation(t *testing.T) {
 	err = uc.Register(ctx, "a@b.com", "u1", "", "user")
 	assert.Error(t, err)
 }
+
+func TestRefresh_RotationAndReuse(t *testing.T) {
+	uc, mredis, _ := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	store := uc.sessions.(*session.Store)
+	jwtSvc := uc.jwtSvc.(*jwt.JWTService)
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, login.RefreshToken)
+	assert.Equal(t, jwt.AccessTokenTTL, login.ExpiresIn)
+
+	// Rotation issues a new pair in the same session
+	refreshed, err := uc.Refresh(ctx, login.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+	first, _ := jwtSvc.ValidateToken(login.AccessToken)
+	second, _ := jwtSvc.ValidateToken(refreshed.AccessToken)
+	assert.Equal(t, first.SessionID, second.SessionID)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	// Reusing the rotated token revokes the whole family
+	_, err = uc.Refresh(ctx, login.RefreshToken)
+	assert.ErrorIs(t, err, session.ErrRefreshTokenReused)
+	_, err = uc.Refresh(ctx, refreshed.RefreshToken)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+	revoked, err := store.IsRevoked(ctx, second)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// Unknown tokens are rejected
+	_, err = uc.Refresh(ctx, "garbage")
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+}
+
+func TestLogout_RevokesTokenAndSession(t *testing.T) {
+	uc, mredis, _ := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	store := uc.sessions.(*session.Store)
+	jwtSvc := uc.jwtSvc.(*jwt.JWTService)
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	other, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	claims, err := jwtSvc.ValidateToken(login.AccessToken)
+	assert.NoError(t, err)
+
+	assert.NoError(t, uc.Logout(ctx, claims.ID, claims.SessionID, claims.ExpiresAt.Time))
+
+	revoked, err := store.IsRevoked(ctx, claims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	_, err = uc.Refresh(ctx, login.RefreshToken)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+	// Other sessions are unaffected
+	_, err = uc.Refresh(ctx, other.RefreshToken)
+	assert.NoError(t, err)
+}
+
+func TestPasswordReset(t *testing.T) {
+	uc, mredis, _ := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	sender := &recordingSender{}
+	uc.emailSender = sender
+	repo := uc.repo.(*mockRepo)
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+
+	// Unknown emails get the same answer and no email
+	repo.err = repository.ErrUserNotFound
+	assert.NoError(t, uc.RequestPasswordReset(ctx, "nobody@b.com", "10.0.0.1"))
+	uc.background.Wait()
+	assert.Empty(t, sender.bodies)
+	repo.err = nil
+
+	// The email is sent in the background
+	assert.NoError(t, uc.RequestPasswordReset(ctx, "a@b.com", "10.0.0.1"))
+	uc.Wait()
+	assert.Len(t, sender.bodies, 1)
+	assert.Contains(t, sender.bodies[0], "It expires in 30 minutes.")
+	token := strings.Fields(strings.SplitN(sender.bodies[0], ": ", 2)[1])[0]
+	assert.NotContains(t, repo.user.ResetTokenHash, token, "only the hash is stored")
+
+	assert.ErrorIs(t, uc.ResetPassword(ctx, "wrong", "new-password"), ErrInvalidResetToken)
+	assert.NoError(t, uc.ResetPassword(ctx, token, "new-password"))
+
+	// The token works once
+	assert.ErrorIs(t, uc.ResetPassword(ctx, token, "other-password"), ErrInvalidResetToken)
+
+	// Existing sessions are gone, the new password works
+	_, err = uc.Refresh(ctx, login.RefreshToken)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+	claims, err := uc.jwtSvc.(*jwt.JWTService).ValidateToken(login.AccessToken)
+	assert.NoError(t, err)
+	revoked, err := uc.sessions.(*session.Store).IsRevoked(ctx, claims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = uc.Login(ctx, "u1", "secret", "")
+	assert.Error(t, err)
+	_, err = uc.Login(ctx, "u1", "new-password", "")
+	assert.NoError(t, err)
+
+	// Requests are throttled per address and per client
+	for i := 1; i < codeSendPolicy.Free; i++ {
+		assert.NoError(t, uc.RequestPasswordReset(ctx, "a@b.com", ""))
+		uc.Wait()
+	}
+	assert.ErrorIs(t, uc.RequestPasswordReset(ctx, "A@b.com", ""), ErrTooManyAttempts)
+	for i := 2; i < sendIPPolicy.Free; i++ {
+		assert.NoError(t, uc.RequestPasswordReset(ctx, fmt.Sprintf("user%d@b.com", i), "10.0.0.1"))
+		uc.Wait()
+	}
+	assert.ErrorIs(t, uc.RequestPasswordReset(ctx, "another@b.com", "10.0.0.1"), ErrTooManyAttempts)
+}
+
+func TestChangePassword_RevokesOtherSessions(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	jwtSvc := uc.jwtSvc.(*jwt.JWTService)
+
+	current, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	other, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	claims, err := jwtSvc.ValidateToken(current.AccessToken)
+	assert.NoError(t, err)
+
+	err = uc.ChangePassword(ctx, stubID, claims.SessionID, "wrong", "new-password")
+	assert.ErrorIs(t, err, ErrWrongPassword)
+
+	assert.NoError(t, uc.ChangePassword(ctx, stubID, claims.SessionID, "secret", "new-password"))
+
+	// The calling session survives, the other one is revoked
+	_, err = uc.Refresh(ctx, current.RefreshToken)
+	assert.NoError(t, err)
+	_, err = uc.Refresh(ctx, other.RefreshToken)
+	assert.ErrorIs(t, err, session.ErrInvalidRefreshToken)
+
+	_, err = uc.Login(ctx, "u1", "new-password", "")
+	assert.NoError(t, err)
+}
+
+func TestChangeEmail_RequiresVerification(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	sender := &recordingSender{}
+	uc.emailSender = sender
+	repo := uc.repo.(*mockRepo)
+
+	// Taken addresses are refused
+	_, err := uc.ChangeEmail(ctx, stubID, "a@b.com")
+	assert.ErrorIs(t, err, ErrEmailTaken)
+
+	_, err = uc.Profile(ctx, stubID)
+	assert.NoError(t, err)
+	assert.True(t, mredis.Exists("user:profile:"+stubID))
+
+	repo.err = repository.ErrUserNotFound
+	updated, err := uc.ChangeEmail(ctx, stubID, "new@b.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "new@b.com", updated.PendingEmail)
+	assert.False(t, mredis.Exists("user:profile:"+stubID), "profile cache must be invalidated")
+	assert.Equal(t, []string{"new@b.com"}, sender.to)
+	assert.Contains(t, sender.bodies[0], "It expires in 15 minutes.")
+
+	// Until the new address is confirmed the old one keeps working
+	assert.Equal(t, "a@b.com", repo.user.Email)
+	assert.True(t, repo.user.IsActive)
+	repo.err = nil
+	_, err = uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+
+	// Confirming the code sent to the new address switches to it
+	repo.err = repository.ErrUserNotFound
+	err = uc.ConfirmEmail(ctx, "new@b.com", repo.user.VerificationCode, "")
+	repo.err = nil
+	assert.NoError(t, err)
+	assert.Equal(t, "new@b.com", repo.user.Email)
+	assert.Empty(t, repo.user.PendingEmail)
+	assert.True(t, repo.user.IsActive)
+}
+
+func TestUpdateProfile_InvalidatesCache(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	_, err := uc.Profile(ctx, stubID)
+	assert.NoError(t, err)
+
+	_, err = uc.UpdateProfile(ctx, stubID, "renamed")
+	assert.NoError(t, err)
+	got, err := uc.Profile(ctx, stubID)
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", got.Username)
+}
+
+// totpCode returns the code for the current step shifted by offset periods.
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	assert.NoError(t, err)
+	return code
+}
+
+func TestTOTP_EnrollAndTwoStepLogin(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+
+	_, secret, err := uc.EnrollTOTP(ctx, stubID)
+	assert.NoError(t, err)
+	_, err = uc.ConfirmTOTP(ctx, stubID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+
+	code := totpCode(t, secret, 0)
+	recovery, err := uc.ConfirmTOTP(ctx, stubID, code)
+	assert.NoError(t, err)
+	assert.Len(t, recovery, recoveryCodeCount)
+	_, _, err = uc.EnrollTOTP(ctx, stubID)
+	assert.ErrorIs(t, err, ErrTOTPAlreadyEnabled)
+
+	// The password alone only yields a challenge
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, login.ChallengeToken)
+	assert.Empty(t, login.AccessToken)
+
+	// The code used for confirmation cannot be replayed
+	_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, code)
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+
+	// A recovery code works once
+	tokens, err := uc.VerifyTOTPLogin(ctx, login.ChallengeToken, recovery[0])
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, recovery[1])
+	assert.ErrorIs(t, err, ErrInvalidChallenge, "challenges are single use")
+
+	login, err = uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, recovery[0])
+	assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+}
+
+func TestTOTP_ChallengeAttemptsAreLimited(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	_, secret, err := uc.EnrollTOTP(ctx, stubID)
+	assert.NoError(t, err)
+	_, err = uc.ConfirmTOTP(ctx, stubID, totpCode(t, secret, -1))
+	assert.NoError(t, err)
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	for i := 0; i < mfaChallengeAttempts; i++ {
+		_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, "not-a-code")
+		assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	}
+	_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, totpCode(t, secret, 0))
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+
+	_, err = uc.VerifyTOTPLogin(ctx, "unknown", "123456")
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+}
+
+func TestTOTP_GuessesAreLimitedPerUser(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	_, secret, err := uc.EnrollTOTP(ctx, stubID)
+	assert.NoError(t, err)
+	_, err = uc.ConfirmTOTP(ctx, stubID, totpCode(t, secret, -1))
+	assert.NoError(t, err)
+
+	// Fresh challenges from password logins do not bring fresh guesses
+	for i := 0; i < totpGuessPolicy.Free; i++ {
+		login, err := uc.Login(ctx, "u1", "secret", "")
+		assert.NoError(t, err)
+		_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, "not-a-code")
+		assert.ErrorIs(t, err, ErrInvalidTOTPCode)
+	}
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, "not-a-code")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	login, err = uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, totpCode(t, secret, 0))
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	assert.NoError(t, uc.UnlockAccount(ctx, stubID))
+	_, err = uc.VerifyTOTPLogin(ctx, login.ChallengeToken, totpCode(t, secret, 0))
+	assert.NoError(t, err)
+}
+
+func TestTOTP_ForcedEnrollmentForAdmins(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	jwtSvc := uc.jwtSvc.(*jwt.JWTService)
+	uc.repo.(*mockRepo).user.Role = "admin"
+	uc.RequireAdminTOTP(true)
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	claims, err := jwtSvc.ValidateToken(login.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleTOTPEnrollment, claims.Role)
+
+	_, secret, err := uc.EnrollTOTP(ctx, stubID)
+	assert.NoError(t, err)
+	_, err = uc.ConfirmTOTP(ctx, stubID, totpCode(t, secret, 0))
+	assert.NoError(t, err)
+
+	// Once enrolled, the refreshed token carries the real role
+	refreshed, err := uc.Refresh(ctx, login.RefreshToken)
+	assert.NoError(t, err)
+	claims, err = jwtSvc.ValidateToken(refreshed.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", claims.Role)
+}
+
+func TestLogin_LockoutAndUnlock(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	repo := uc.repo.(*mockRepo)
+
+	// Unknown accounts and wrong passwords look the same
+	repo.err = repository.ErrUserNotFound
+	_, err := uc.Login(ctx, "nobody", "secret", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	repo.err = nil
+	_, err = uc.Login(ctx, "u1", "wrong", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// The username and the email of an account share its attempts
+	for i := 1; i < loginAccountPolicy.Free; i++ {
+		_, err = uc.Login(ctx, "A@b.com", "wrong", "10.0.0.1")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+	_, err = uc.Login(ctx, "U1", "wrong", "10.0.0.2")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	// Even the right password is refused while locked
+	_, err = uc.Login(ctx, "u1", "secret", "10.0.0.3")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	assert.NoError(t, uc.UnlockAccount(ctx, stubID))
+	_, err = uc.Login(ctx, "u1", "secret", "10.0.0.3")
+	assert.NoError(t, err)
+}
+
+func TestLogin_LockoutPerIP(t *testing.T) {
+	uc, mredis, _ := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	uc.repo.(*mockRepo).err = repository.ErrUserNotFound
+
+	// Spraying many accounts from one address locks the address
+	var err error
+	for i := 0; i <= loginIPPolicy.Free; i++ {
+		_, err = uc.Login(ctx, fmt.Sprintf("user%d", i), "guess", "10.0.0.1")
+	}
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	_, err = uc.Login(ctx, "another", "guess", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	_, err = uc.Login(ctx, "another", "guess", "10.0.0.2")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestConfirmEmail_Throttled(t *testing.T) {
+	uc, mredis, _ := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	repo := uc.repo.(*mockRepo)
+	repo.user.IsActive = false
+
+	for i := 0; i < codeSendPolicy.Free; i++ {
+		assert.NoError(t, uc.SendVerificationCode(ctx, "a@b.com", ""))
+		uc.background.Wait()
+	}
+	err := uc.SendVerificationCode(ctx, "A@b.com", "")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	// Sends from one client are limited across addresses
+	for i := 0; i < sendIPPolicy.Free; i++ {
+		assert.NoError(t, uc.SendVerificationCode(ctx, fmt.Sprintf("user%d@b.com", i), "10.0.0.1"))
+		uc.background.Wait()
+	}
+	err = uc.SendVerificationCode(ctx, "another@b.com", "10.0.0.1")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	// An outage is reported as such and counts as no guess
+	outage := errors.New("mongo unavailable")
+	repo.err = outage
+	for i := 0; i <= codeGuessPolicy.Free; i++ {
+		err = uc.ConfirmEmail(ctx, "a@b.com", "not-it", "10.0.0.1")
+		assert.ErrorIs(t, err, outage)
+	}
+	repo.err = nil
+
+	for i := 0; i < codeGuessPolicy.Free; i++ {
+		err = uc.ConfirmEmail(ctx, "a@b.com", "not-it", "")
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	}
+	err = uc.ConfirmEmail(ctx, " A@b.com", "not-it", "")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	err = uc.ConfirmEmail(ctx, "a@b.com", repo.user.VerificationCode, "")
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestConfirmEmail_DoesNotBypassLogin(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	sender := &recordingSender{}
+	uc.emailSender = sender
+	repo := uc.repo.(*mockRepo)
+
+	// Verified addresses get no new codes, nor do unknown ones, and the
+	// answer is the same
+	assert.NoError(t, uc.SendVerificationCode(ctx, "a@b.com", "10.0.0.1"))
+	repo.err = repository.ErrUserNotFound
+	assert.NoError(t, uc.SendVerificationCode(ctx, "nobody@b.com", "10.0.0.1"))
+	uc.background.Wait()
+	repo.err = nil
+	assert.Empty(t, sender.to)
+
+	_, secret, err := uc.EnrollTOTP(ctx, stubID)
+	assert.NoError(t, err)
+	_, err = uc.ConfirmTOTP(ctx, stubID, totpCode(t, secret, 0))
+	assert.NoError(t, err)
+
+	// Someone with access to the mailbox of an unverified two-factor
+	// account can verify it, but that starts no session
+	repo.user.IsActive = false
+	assert.NoError(t, uc.SendVerificationCode(ctx, "a@b.com", "10.0.0.1"))
+	uc.background.Wait()
+	assert.Len(t, sender.to, 1)
+	err = uc.ConfirmEmail(ctx, "a@b.com", repo.user.VerificationCode, "")
+	assert.NoError(t, err)
+	assert.True(t, repo.user.IsActive)
+	for _, key := range mredis.Keys() {
+		assert.False(t, strings.HasPrefix(key, "refresh:"), "no refresh token may be issued")
+	}
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, login.ChallengeToken)
+	assert.Empty(t, login.AccessToken)
+}
+
+func TestLogin_RequiresVerifiedEmail(t *testing.T) {
+	uc, mredis, _ := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	uc.repo.(*mockRepo).user.IsActive = false
+
+	_, err := uc.Login(ctx, "u1", "secret", "")
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+	_, err = uc.Login(ctx, "u1", "wrong", "")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "status is only revealed with the right password")
+}
+
+func TestSuspendUser(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	store := uc.sessions.(*session.Store)
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+
+	// Mongo and Redis stay in agreement when Redis fails
+	mredis.SetError("connection lost")
+	_, err = uc.SuspendUser(ctx, stubID, "chargebacks")
+	assert.Error(t, err)
+	assert.False(t, uc.repo.(*mockRepo).user.Suspended, "suspension not rolled back")
+	mredis.SetError("")
+
+	suspended, err := uc.SuspendUser(ctx, stubID, "chargebacks")
+	assert.NoError(t, err)
+	assert.True(t, suspended.Suspended)
+	assert.Equal(t, "chargebacks", suspended.SuspendedReason)
+
+	isSuspended, err := store.IsSuspended(ctx, stubID)
+	assert.NoError(t, err)
+	assert.True(t, isSuspended)
+	_, err = uc.Login(ctx, "u1", "secret", "")
+	assert.ErrorIs(t, err, ErrAccountSuspended)
+	_, err = uc.Refresh(ctx, login.RefreshToken)
+	assert.Error(t, err)
+
+	_, err = uc.ReactivateUser(ctx, stubID)
+	assert.NoError(t, err)
+	isSuspended, err = store.IsSuspended(ctx, stubID)
+	assert.NoError(t, err)
+	assert.False(t, isSuspended)
+	_, err = uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+}
+
+func TestRoles_PermissionsInToken(t *testing.T) {
+	uc, mredis, stubID := setupUsecaseWithRedis(t)
+	defer mredis.Close()
+
+	ctx := context.Background()
+	jwtSvc := uc.jwtSvc.(*jwt.JWTService)
+
+	login, err := uc.Login(ctx, "u1", "secret", "")
+	assert.NoError(t, err)
+	claims, err := jwtSvc.ValidateToken(login.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, auth.HasPermission(claims.Permissions, auth.PermOrdersReadOwn))
+	assert.False(t, auth.HasPermission(claims.Permissions, auth.PermCarsWrite))
+
+	// Unknown permissions and unknown roles are refused
+	_, err = uc.CreateRole(ctx, &entity.Role{Name: "fleet", Permissions: []string{"cars:drive"}})
+	assert.ErrorIs(t, err, ErrUnknownPermission)
+	_, err = uc.ChangeUserRole(ctx, stubID, "fleet")
+	assert.ErrorIs(t, err, repository.ErrRoleNotFound)
+
+	_, err = uc.CreateRole(ctx, &entity.Role{Name: "fleet", Permissions: []string{auth.PermCarsWrite}})
+	assert.NoError(t, err)
+	_, err = uc.CreateRole(ctx, &entity.Role{Name: "fleet"})
+	assert.ErrorIs(t, err, repository.ErrRoleExists)
+	_, err = uc.ChangeUserRole(ctx, stubID, "fleet")
+	assert.NoError(t, err)
+
+	// The new role's permissions apply on refresh
+	refreshed, err := uc.Refresh(ctx, login.RefreshToken)
+	assert.NoError(t, err)
+	claims, err = jwtSvc.ValidateToken(refreshed.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "fleet", claims.Role)
+	assert.Equal(t, []string{auth.PermCarsWrite}, claims.Permissions)
+
+	// Permissions that no longer exist are dropped on startup
+	uc.roles.(memoryRoles)[roleUser].Permissions = append(uc.roles.(memoryRoles)[roleUser].Permissions, "stock:write")
+	assert.NoError(t, uc.EnsureDefaultRoles(ctx))
+	user, err := uc.roles.FindByName(ctx, roleUser)
+	assert.NoError(t, err)
+	assert.NotContains(t, user.Permissions, "stock:write")
+	assert.Contains(t, user.Permissions, auth.PermOrdersCreate)
+
+	assert.ErrorIs(t, uc.DeleteRole(ctx, "fleet"), ErrRoleInUse)
+	assert.ErrorIs(t, uc.DeleteRole(ctx, roleUser), ErrProtectedRole)
+	_, err = uc.UpdateRole(ctx, &entity.Role{Name: roleAdmin})
+	assert.ErrorIs(t, err, ErrProtectedRole)
+}
@@ -0,0 +1,106 @@
+// Package apperr defines the typed errors the usecase layers return. Each
+// error has a Kind that the gRPC servers translate into a status code, so
+// handlers can return usecase errors as they are.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kind classifies an error. A Kind is itself an error, so callers can test
+// for a whole class with errors.Is(err, apperr.NotFound).
+type Kind int
+
+const (
+	Internal Kind = iota
+	NotFound
+	AlreadyExists
+	InvalidArgument
+	// Conflict means the resource changed concurrently; retrying the whole
+	// read-modify-write may succeed.
+	Conflict
+	// FailedPrecondition means the resource is not in a state that allows
+	// the operation, and retrying will not help until it is.
+	FailedPrecondition
+	PermissionDenied
+	Unauthenticated
+	ResourceExhausted
+)
+
+var kindNames = map[Kind]string{
+	Internal:           "internal",
+	NotFound:           "not found",
+	AlreadyExists:      "already exists",
+	InvalidArgument:    "invalid argument",
+	Conflict:           "conflict",
+	FailedPrecondition: "failed precondition",
+	PermissionDenied:   "permission denied",
+	Unauthenticated:    "unauthenticated",
+	ResourceExhausted:  "resource exhausted",
+}
+
+func (k Kind) Error() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind %d", int(k))
+}
+
+// FieldViolation describes one invalid request field.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Error is a domain error. Its text, including any context added by
+// wrapping it with fmt.Errorf, is shown to clients, so it must not carry
+// internal details.
+type Error struct {
+	Kind    Kind
+	Message string
+	// Reason is a stable machine-readable code, such as
+	// "EMAIL_NOT_VERIFIED", for errors clients react to.
+	Reason     string
+	Fields     []FieldViolation
+	RetryAfter time.Duration
+}
+
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Field returns an InvalidArgument error for a single request field.
+func Field(field, description string) *Error {
+	return &Error{
+		Kind:    InvalidArgument,
+		Message: field + ": " + description,
+		Fields:  []FieldViolation{{Field: field, Description: description}},
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches the error's Kind, in addition to the usual identity match.
+func (e *Error) Is(target error) bool {
+	k, ok := target.(Kind)
+	return ok && k == e.Kind
+}
+
+// From returns the first *Error in err's chain, if any.
+func From(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+// KindOf returns the Kind of err, or Internal for errors without one.
+func KindOf(err error) Kind {
+	if e, ok := From(err); ok {
+		return e.Kind
+	}
+	return Internal
+}
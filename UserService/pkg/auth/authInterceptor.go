@@ -3,12 +3,17 @@ package auth
 import (
 	"context"
 	"strings"
+	"sync"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 
+	authpb "CarStore/UserService/api/pb/auth"
 	"CarStore/UserService/pkg/jwt"
 )
 
@@ -17,81 +22,150 @@ type contextKey string
 const (
 	ContextKeyUserID   contextKey = "userID"
 	ContextKeyUserRole contextKey = "userRole"
+	ContextKeyClaims   contextKey = "claims"
 )
 
-// methodACL defines the required minimum role for each RPC.
-// Roles: "anon", "user", "admin"; admin > user > anon
-var methodACL = map[string]string{
-	// UserService
-	"/user.UserService/RegisterUser":         "anon",
-	"/user.UserService/LoginUser":            "anon",
-	"/user.UserService/SendVerificationCode": "anon",
-	"/user.UserService/ConfirmEmail":         "anon",
-	"/user.UserService/GetProfile":           "user",
-	"/user.UserService/ListUsers":            "admin",
-	"/user.UserService/ChangeUserRole":       "admin",
-	"/user.UserService/DeleteUser":           "admin",
-
-	// CarService
-	"/car.CarService/ListCars":      "anon",
-	"/car.CarService/GetCar":        "anon",
-	"/car.CarService/CreateCar":     "admin",
-	"/car.CarService/UpdateCar":     "admin",
-	"/car.CarService/DeleteCar":     "admin",
-	"/car.CarService/DecreaseStock": "user",
-
-	// OrderService
-	"/order.OrderService/CreateOrder": "user",
-	"/order.OrderService/GetOrder":    "user",
-	"/order.OrderService/ListOrders":  "admin",
-	"/order.OrderService/UpdateOrder": "admin",
-	"/order.OrderService/DeleteOrder": "admin",
+// RevocationChecker reports whether a validly signed token was revoked,
+// e.g. by logout or because its session was compromised.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, claims *jwt.Claims) (bool, error)
 }
 
-// UnaryAuthInterceptor returns a gRPC interceptor enforcing JWT auth and role-based access.
-func UnaryAuthInterceptor(jwtSvc jwt.JWTService) grpc.UnaryServerInterceptor {
+// RoleTOTPEnrollment is the role of tokens issued to accounts that must
+// enroll in two-factor authentication before getting their real role. Such
+// tokens carry no permissions.
+const RoleTOTPEnrollment = "totp_enrollment"
+
+// rules caches the rule of each method, keyed by full method name. Methods
+// without a rule are cached as nil.
+var rules sync.Map
+
+// methodRule looks up the (auth.rule) option of a method in the descriptors
+// registered by the generated code.
+func methodRule(fullMethod string) *authpb.Rule {
+	if r, ok := rules.Load(fullMethod); ok {
+		return r.(*authpb.Rule)
+	}
+	var rule *authpb.Rule
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(fullMethod, "/"), "/", "."))
+	if desc, err := protoregistry.GlobalFiles.FindDescriptorByName(name); err == nil {
+		if md, ok := desc.(protoreflect.MethodDescriptor); ok && proto.HasExtension(md.Options(), authpb.E_Rule) {
+			rule = proto.GetExtension(md.Options(), authpb.E_Rule).(*authpb.Rule)
+		}
+	}
+	rules.Store(fullMethod, rule)
+	return rule
+}
+
+// UnaryAuthInterceptor returns a gRPC interceptor enforcing JWT auth and the
+// permissions each method declares with the (auth.rule) option.
+// Tokens are also checked against revoked, and their users against
+// suspended, unless these are nil.
+func UnaryAuthInterceptor(jwtSvc jwt.JWTService, revoked RevocationChecker, suspended UserStatusChecker) grpc.UnaryServerInterceptor {
+	a := &authorizer{jwtSvc: jwtSvc, revoked: revoked, suspended: suspended}
 	return func(
 		ctx context.Context,
 		req interface{},
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		required, ok := methodACL[info.FullMethod]
-		if !ok {
-			return nil, status.Error(codes.PermissionDenied, "method not allowed")
+		ctx, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
 		}
-		if required == "anon" {
-			return handler(ctx, req)
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor is the streaming counterpart of
+// UnaryAuthInterceptor. The token is checked once, when the stream opens.
+func StreamAuthInterceptor(jwtSvc jwt.JWTService, revoked RevocationChecker, suspended UserStatusChecker) grpc.StreamServerInterceptor {
+	a := &authorizer{jwtSvc: jwtSvc, revoked: revoked, suspended: suspended}
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
 		}
-		md, _ := metadata.FromIncomingContext(ctx)
-		auth := ""
-		if vals := md.Get("authorization"); len(vals) > 0 {
-			auth = vals[0]
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type authorizer struct {
+	jwtSvc    jwt.JWTService
+	revoked   RevocationChecker
+	suspended UserStatusChecker
+}
+
+// authorize checks a call to fullMethod against the method's rule and
+// returns the context carrying the caller's claims.
+func (a *authorizer) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	rule := methodRule(fullMethod)
+	if rule == nil {
+		return nil, status.Error(codes.PermissionDenied, "method not allowed")
+	}
+	if rule.Public {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := ""
+	if vals := md.Get("authorization"); len(vals) > 0 {
+		auth = vals[0]
+	}
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid authorization header")
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	claims, err := a.jwtSvc.ValidateToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if a.revoked != nil {
+		isRevoked, err := a.revoked.IsRevoked(ctx, claims)
+		if err != nil {
+			return nil, status.Error(codes.Unavailable, "could not check token revocation")
 		}
-		if !strings.HasPrefix(auth, "Bearer ") {
-			return nil, status.Error(codes.Unauthenticated, "missing or invalid authorization header")
+		if isRevoked {
+			return nil, status.Error(codes.Unauthenticated, "token revoked")
 		}
-		token := strings.TrimPrefix(auth, "Bearer ")
-		claims, err := jwtSvc.ValidateToken(token)
+	}
+	if a.suspended != nil {
+		isSuspended, err := a.suspended.IsSuspended(ctx, claims.UserID)
 		if err != nil {
-			return nil, status.Error(codes.Unauthenticated, "invalid token")
+			return nil, status.Error(codes.Unavailable, "could not check account status")
 		}
-		role := claims.Role
-		switch required {
-		case "user":
-			if role != "user" && role != "admin" {
-				return nil, status.Error(codes.PermissionDenied, "user role required")
-			}
-		case "admin":
-			if role != "admin" {
-				return nil, status.Error(codes.PermissionDenied, "admin role required")
-			}
+		if isSuspended {
+			return nil, status.Error(codes.PermissionDenied, "account suspended")
+		}
+	}
+	for _, p := range rule.Permissions {
+		if !HasPermission(claims.Permissions, p) {
+			return nil, status.Errorf(codes.PermissionDenied, "permission %q required", p)
 		}
-		// inject into context
-		ctx = context.WithValue(ctx, ContextKeyUserID, claims.UserID)
-		ctx = context.WithValue(ctx, ContextKeyUserRole, role)
-		return handler(ctx, req)
 	}
+	return NewContext(ctx, claims), nil
+}
+
+// authStream hands the authorized context to stream handlers.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// NewContext returns ctx carrying the caller described by claims, as the
+// auth interceptors hand it to handlers.
+func NewContext(ctx context.Context, claims *jwt.Claims) context.Context {
+	ctx = context.WithValue(ctx, ContextKeyUserID, claims.UserID)
+	ctx = context.WithValue(ctx, ContextKeyUserRole, claims.Role)
+	return context.WithValue(ctx, ContextKeyClaims, claims)
 }
 
 // FromContext retrieves the userID and role from context.
@@ -104,3 +178,9 @@ func FromContext(ctx context.Context) (userID, role string) {
 	}
 	return
 }
+
+// ClaimsFromContext retrieves the validated token claims from context.
+func ClaimsFromContext(ctx context.Context) (*jwt.Claims, bool) {
+	claims, ok := ctx.Value(ContextKeyClaims).(*jwt.Claims)
+	return claims, ok
+}
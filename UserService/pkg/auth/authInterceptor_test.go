@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	_ "CarStore/UserService/api/pb/user"
+	"CarStore/UserService/pkg/jwt"
+)
+
+func TestUnaryAuthInterceptor_MethodRules(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := jwt.NewPrivateKey("test", priv)
+	require.NoError(t, err)
+	jwtSvc := jwt.NewJWTService("UserService", key)
+	intercept := UnaryAuthInterceptor(*jwtSvc, nil, nil)
+
+	call := func(method string, perms ...string) codes.Code {
+		ctx := context.Background()
+		if perms != nil {
+			token, err := jwtSvc.GenerateToken("u1", "custom", "s1", perms)
+			require.NoError(t, err)
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+		}
+		_, err := intercept(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.OK, call("/user.UserService/LoginUser"))
+	assert.Equal(t, codes.Unauthenticated, call("/user.UserService/GetProfile"))
+	assert.Equal(t, codes.PermissionDenied, call("/user.UserService/GetProfile", PermOrdersRead))
+	assert.Equal(t, codes.OK, call("/user.UserService/GetProfile", PermUsersSelf))
+	// an empty rule only needs a valid token
+	assert.Equal(t, codes.OK, call("/user.UserService/EnrollTOTP", []string{}...))
+	assert.Equal(t, codes.PermissionDenied, call("/user.UserService/NoSuchMethod", PermUsersAdmin))
+}
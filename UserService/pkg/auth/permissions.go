@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+// Permissions checked by the services. RPCs declare the ones they need with
+// the (auth.rule) method option; roles grant them. A permission ending in
+// ":own" only covers the caller's own resources and is implied by the same
+// permission without the suffix.
+const (
+	PermUsersSelf  = "users:self"
+	PermUsersRead  = "users:read"
+	PermUsersAdmin = "users:admin"
+	PermRolesAdmin = "roles:admin"
+
+	PermCarsWrite = "cars:write"
+
+	PermOrdersCreate    = "orders:create"
+	PermOrdersReadOwn   = "orders:read:own"
+	PermOrdersRead      = "orders:read"
+	PermOrdersCancelOwn = "orders:cancel:own"
+	PermOrdersCancel    = "orders:cancel"
+	PermOrdersWrite     = "orders:write"
+)
+
+// AllPermissions lists every known permission.
+var AllPermissions = []string{
+	PermUsersSelf,
+	PermUsersRead,
+	PermUsersAdmin,
+	PermRolesAdmin,
+	PermCarsWrite,
+	PermOrdersCreate,
+	PermOrdersReadOwn,
+	PermOrdersRead,
+	PermOrdersCancelOwn,
+	PermOrdersCancel,
+	PermOrdersWrite,
+}
+
+// IsKnownPermission reports whether p is in AllPermissions.
+func IsKnownPermission(p string) bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether granted covers required.
+func HasPermission(granted []string, required string) bool {
+	unscoped, own := strings.CutSuffix(required, ":own")
+	for _, g := range granted {
+		if g == required || (own && g == unscoped) {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the token of the request grants the permission.
+func Can(ctx context.Context, permission string) bool {
+	claims, ok := ClaimsFromContext(ctx)
+	return ok && HasPermission(claims.Permissions, permission)
+}
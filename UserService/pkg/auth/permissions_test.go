@@ -0,0 +1,17 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermission(t *testing.T) {
+	granted := []string{PermOrdersRead, PermUsersSelf}
+
+	assert.True(t, HasPermission(granted, PermOrdersRead))
+	assert.True(t, HasPermission(granted, PermOrdersReadOwn), "x grants x:own")
+	assert.False(t, HasPermission([]string{PermOrdersReadOwn}, PermOrdersRead), "x:own does not grant x")
+	assert.False(t, HasPermission(granted, PermCarsWrite))
+	assert.False(t, HasPermission(nil, PermUsersSelf))
+}
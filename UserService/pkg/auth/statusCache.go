@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// UserStatusChecker reports whether a user's account is suspended.
+type UserStatusChecker interface {
+	IsSuspended(ctx context.Context, userID string) (bool, error)
+}
+
+// StatusCache remembers lookups of a UserStatusChecker for ttl, so the
+// interceptor does not query Redis on every request. A suspension takes
+// effect within ttl.
+type StatusCache struct {
+	checker UserStatusChecker
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]statusEntry
+	// sweepAt is the size at which expired entries are next dropped
+	sweepAt int
+}
+
+// StatusCacheTTL is how long the services cache a user's suspension status.
+// A suspension or reactivation that UserService records in Redis reaches
+// the interceptors of every service, UserService included, within it.
+const StatusCacheTTL = 30 * time.Second
+
+// minSweepSize is the smallest size at which expired entries are dropped.
+const minSweepSize = 10_000
+
+type statusEntry struct {
+	suspended bool
+	expires   time.Time
+}
+
+func NewStatusCache(checker UserStatusChecker, ttl time.Duration) *StatusCache {
+	return &StatusCache{checker: checker, ttl: ttl, entries: make(map[string]statusEntry), sweepAt: minSweepSize}
+}
+
+func (c *StatusCache) IsSuspended(ctx context.Context, userID string) (bool, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.suspended, nil
+	}
+
+	suspended, err := c.checker.IsSuspended(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	c.entries[userID] = statusEntry{suspended: suspended, expires: now.Add(c.ttl)}
+	if len(c.entries) >= c.sweepAt {
+		c.sweep(now)
+	}
+	c.mu.Unlock()
+	return suspended, nil
+}
+
+// sweep drops expired entries so the map does not grow forever. The next
+// sweep waits until the live entries have doubled, so the cost of sweeping
+// is spread over at least as many insertions as there are entries.
+func (c *StatusCache) sweep(now time.Time) {
+	for id, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.sweepAt = max(2*len(c.entries), minSweepSize)
+}
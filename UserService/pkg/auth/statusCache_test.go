@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingChecker struct{ lookups int }
+
+func (c *countingChecker) IsSuspended(ctx context.Context, userID string) (bool, error) {
+	c.lookups++
+	return userID == "banned", nil
+}
+
+func TestStatusCache(t *testing.T) {
+	ctx := context.Background()
+	checker := &countingChecker{}
+	c := NewStatusCache(checker, time.Hour)
+
+	for range 2 {
+		suspended, err := c.IsSuspended(ctx, "banned")
+		require.NoError(t, err)
+		assert.True(t, suspended)
+	}
+	assert.Equal(t, 1, checker.lookups, "second call cached")
+
+	// live entries are kept, and the next sweep waits for twice as many
+	for i := range minSweepSize {
+		_, err := c.IsSuspended(ctx, strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	assert.Len(t, c.entries, minSweepSize+1)
+	assert.Equal(t, 2*minSweepSize, c.sweepAt)
+
+	// expired entries are dropped
+	expired := NewStatusCache(checker, -time.Second)
+	for i := range minSweepSize {
+		_, err := expired.IsSuspended(ctx, strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	assert.Empty(t, expired.entries)
+	assert.Equal(t, minSweepSize, expired.sweepAt)
+}
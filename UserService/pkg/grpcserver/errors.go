@@ -0,0 +1,82 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"CarStore/UserService/pkg/apperr"
+)
+
+// errorDomain is reported in ErrorInfo details.
+const errorDomain = "carstore"
+
+var kindCodes = map[apperr.Kind]codes.Code{
+	apperr.Internal:           codes.Internal,
+	apperr.NotFound:           codes.NotFound,
+	apperr.AlreadyExists:      codes.AlreadyExists,
+	apperr.InvalidArgument:    codes.InvalidArgument,
+	apperr.Conflict:           codes.Aborted,
+	apperr.FailedPrecondition: codes.FailedPrecondition,
+	apperr.PermissionDenied:   codes.PermissionDenied,
+	apperr.Unauthenticated:    codes.Unauthenticated,
+	apperr.ResourceExhausted:  codes.ResourceExhausted,
+}
+
+// StatusError converts err into a gRPC status error. Errors that already
+// carry a status pass through. Errors without a kind become Internal, and
+// their text is logged rather than sent to the client.
+func StatusError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := apperr.From(err); ok && e.Kind != apperr.Internal {
+		return appStatus(e, err.Error()).Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "request canceled")
+	}
+	log.Printf("internal error (request_id=%s): %v", RequestIDFromContext(ctx), err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func appStatus(e *apperr.Error, message string) *status.Status {
+	st := status.New(kindCodes[e.Kind], message)
+	var msgs []protoadapt.MessageV1
+	if len(e.Fields) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, f := range e.Fields {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Description,
+			})
+		}
+		msgs = append(msgs, br)
+	}
+	if e.Reason != "" {
+		msgs = append(msgs, &errdetails.ErrorInfo{Reason: e.Reason, Domain: errorDomain})
+	}
+	if e.RetryAfter > 0 {
+		msgs = append(msgs, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)})
+	}
+	if len(msgs) == 0 {
+		return st
+	}
+	withDetails, err := st.WithDetails(msgs...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
@@ -0,0 +1,132 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"buf.build/go/protovalidate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"CarStore/UserService/pkg/apperr"
+)
+
+var info = &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+func TestUnaryRecovery(t *testing.T) {
+	unary := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Panics"}
+	_, err := UnaryRecovery()(context.Background(), nil, unary, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "1", panics.Get(unary.FullMethod).String())
+}
+
+func TestStreamRecovery(t *testing.T) {
+	err := StreamRecovery()(nil, &contextStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: info.FullMethod},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			panic("boom")
+		})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestWithRequestID(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDHeader, "abc"))
+	assert.Equal(t, "abc", RequestIDFromContext(withRequestID(ctx)))
+
+	id := RequestIDFromContext(withRequestID(context.Background()))
+	assert.NotEmpty(t, id)
+	assert.NotEqual(t, id, RequestIDFromContext(withRequestID(context.Background())))
+}
+
+func TestUnaryDeadline(t *testing.T) {
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		return time.Until(deadline), nil
+	}
+
+	left, err := UnaryDeadline(time.Second)(context.Background(), nil, info, handler)
+	require.NoError(t, err)
+	assert.InDelta(t, time.Second, left, float64(100*time.Millisecond), "default applied")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	left, err = UnaryDeadline(time.Second)(ctx, nil, info, handler)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, left.(time.Duration), time.Second, "long deadline capped")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	left, err = UnaryDeadline(time.Second)(ctx, nil, info, handler)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, left.(time.Duration), 10*time.Millisecond, "short deadline kept")
+}
+
+func TestStatusError(t *testing.T) {
+	ctx := context.Background()
+	notFound := apperr.New(apperr.NotFound, "car not found")
+
+	st := status.Convert(StatusError(ctx, fmt.Errorf("%w: %q", notFound, "x")))
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, `car not found: "x"`, st.Message())
+
+	st = status.Convert(StatusError(ctx, apperr.New(apperr.Conflict, "changed")))
+	assert.Equal(t, codes.Aborted, st.Code())
+
+	st = status.Convert(StatusError(ctx, apperr.Field("car_id", "must be a UUID")))
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	br, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	assert.Equal(t, "car_id", br.FieldViolations[0].Field)
+
+	// statuses pass through, anything else is hidden
+	assert.Equal(t, codes.PermissionDenied, status.Code(StatusError(ctx, status.Error(codes.PermissionDenied, "no"))))
+	st = status.Convert(StatusError(ctx, errors.New("mongo: connection refused")))
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.NotContains(t, st.Message(), "mongo")
+	assert.Nil(t, StatusError(ctx, nil))
+}
+
+func violation(field, message string) *protovalidate.Violation {
+	return &protovalidate.Violation{Proto: validate.Violation_builder{
+		Field: validate.FieldPath_builder{Elements: []*validate.FieldPathElement{
+			validate.FieldPathElement_builder{FieldName: proto.String(field)}.Build(),
+		}}.Build(),
+		Message: proto.String(message),
+	}.Build()}
+}
+
+func TestViolationsError(t *testing.T) {
+	err := violationsError(&protovalidate.ValidationError{Violations: []*protovalidate.Violation{
+		violation("car_id", "value must be a valid UUID"),
+		violation("quantity", "value must be greater than 0"),
+	}})
+
+	assert.Equal(t, apperr.InvalidArgument, err.Kind)
+	assert.Equal(t, "car_id: value must be a valid UUID", err.Message)
+	assert.Equal(t, []apperr.FieldViolation{
+		{Field: "car_id", Description: "value must be a valid UUID"},
+		{Field: "quantity", Description: "value must be greater than 0"},
+	}, err.Fields)
+
+	st, _ := status.FromError(StatusError(context.Background(), err))
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	assert.Len(t, st.Details()[0].(*errdetails.BadRequest).FieldViolations, 2)
+}
+
+func TestValidateIgnoresNonProto(t *testing.T) {
+	assert.NoError(t, validateMessage("not a message"))
+}
@@ -0,0 +1,154 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader carries the request ID in incoming metadata and in the
+// response header. The gateway forwards it from the HTTP header.
+const RequestIDHeader = "x-request-id"
+
+type contextKey struct{}
+
+// RequestIDFromContext returns the ID of the request being served.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// withRequestID reuses the caller's request ID or assigns a new one, and
+// echoes it in the response header.
+func withRequestID(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := ""
+	if vals := md.Get(RequestIDHeader); len(vals) > 0 && len(vals[0]) <= 128 {
+		id = vals[0]
+	}
+	if id == "" {
+		id = uuid.NewString()
+	}
+	// outgoing metadata lets handlers pass the ID on to other services
+	ctx = metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func UnaryRequestID() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = withRequestID(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, RequestIDFromContext(ctx)))
+		return handler(ctx, req)
+	}
+}
+
+func StreamRequestID() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := withRequestID(ss.Context())
+		_ = ss.SetHeader(metadata.Pairs(RequestIDHeader, RequestIDFromContext(ctx)))
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func UnaryLogging() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+func StreamLogging() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(ss.Context(), info.FullMethod, start, err)
+		return err
+	}
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	log.Printf("rpc %s code=%s duration=%s request_id=%s",
+		method, status.Code(err), time.Since(start).Round(time.Microsecond), RequestIDFromContext(ctx))
+}
+
+// UnaryRecovery turns a panic in a handler into an Internal error instead
+// of crashing the process. The stack is logged and the panic counted in the
+// grpc_panics metric.
+func UnaryRecovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ctx, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func StreamRecovery() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ss.Context(), info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recovered(ctx context.Context, method string, r interface{}) error {
+	panics.Add(method, 1)
+	log.Printf("panic in %s (request_id=%s): %v\n%s", method, RequestIDFromContext(ctx), r, debug.Stack())
+	return status.Error(codes.Internal, "internal error")
+}
+
+// UnaryDeadline bounds every call by timeout. Shorter client deadlines are
+// kept.
+func UnaryDeadline(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+func StreamDeadline(timeout time.Duration) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := context.WithTimeout(ss.Context(), timeout)
+		defer cancel()
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// contextStream replaces the context of a stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryErrors converts handler errors with StatusError.
+func UnaryErrors() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, StatusError(ctx, err)
+	}
+}
+
+func StreamErrors() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return StatusError(ss.Context(), handler(srv, ss))
+	}
+}
@@ -0,0 +1,28 @@
+package grpcserver
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// panics counts recovered handler panics per full method name. It is
+// published by expvar at /debug/vars.
+var panics = expvar.NewMap("grpc_panics")
+
+// ServeMetrics exposes expvar's /debug/vars, including the recovered panics,
+// on addr, e.g. "localhost:6060", in the background. An empty addr disables
+// it.
+func ServeMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		log.Printf("metrics listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,65 @@
+// Package grpcserver builds the gRPC servers of all services with the same
+// chain of unary and stream interceptors, so every RPC, streaming or not,
+// gets request IDs, logging, panic recovery, deadlines, auth and request
+// validation.
+package grpcserver
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+
+	"CarStore/UserService/pkg/auth"
+	"CarStore/UserService/pkg/jwt"
+)
+
+// Default deadlines for calls whose client did not set a shorter one.
+const (
+	DefaultUnaryTimeout  = 30 * time.Second
+	DefaultStreamTimeout = 10 * time.Minute
+)
+
+type Options struct {
+	JWT       jwt.JWTService
+	Revoked   auth.RevocationChecker // may be nil
+	Suspended auth.UserStatusChecker // may be nil
+
+	// Longest a call may run; zero means the default.
+	UnaryTimeout  time.Duration
+	StreamTimeout time.Duration
+}
+
+// New returns a server with the interceptor chain installed, outermost
+// first: request ID, logging, error mapping, recovery, deadline, auth,
+// validation. Logging sits outside the others so that it records the final
+// status. Validation runs after auth so that unauthenticated callers learn
+// nothing about the request format.
+func New(opts Options, extra ...grpc.ServerOption) *grpc.Server {
+	if opts.UnaryTimeout == 0 {
+		opts.UnaryTimeout = DefaultUnaryTimeout
+	}
+	if opts.StreamTimeout == 0 {
+		opts.StreamTimeout = DefaultStreamTimeout
+	}
+	chain := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			UnaryRequestID(),
+			UnaryLogging(),
+			UnaryErrors(),
+			UnaryRecovery(),
+			UnaryDeadline(opts.UnaryTimeout),
+			auth.UnaryAuthInterceptor(opts.JWT, opts.Revoked, opts.Suspended),
+			UnaryValidate(),
+		),
+		grpc.ChainStreamInterceptor(
+			StreamRequestID(),
+			StreamLogging(),
+			StreamErrors(),
+			StreamRecovery(),
+			StreamDeadline(opts.StreamTimeout),
+			auth.StreamAuthInterceptor(opts.JWT, opts.Revoked, opts.Suspended),
+			StreamValidate(),
+		),
+	}
+	return grpc.NewServer(append(chain, extra...)...)
+}
@@ -0,0 +1,98 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	carpetpb "CarStore/CarService/api/pb/car"
+	"CarStore/UserService/pkg/jwt"
+)
+
+// carServer counts the calls that reach its handlers.
+type carServer struct {
+	carpetpb.UnimplementedCarServiceServer
+	calls int
+}
+
+func (s *carServer) GetCar(ctx context.Context, req *carpetpb.GetCarRequest) (*carpetpb.GetCarResponse, error) {
+	s.calls++
+	return &carpetpb.GetCarResponse{}, nil
+}
+
+func (s *carServer) DeleteCar(ctx context.Context, req *carpetpb.DeleteCarRequest) (*carpetpb.DeleteCarResponse, error) {
+	s.calls++
+	return &carpetpb.DeleteCarResponse{}, nil
+}
+
+func (s *carServer) UploadCarMedia(stream grpc.ClientStreamingServer[carpetpb.UploadCarMediaRequest, carpetpb.CarMedia]) error {
+	s.calls++
+	return stream.SendAndClose(&carpetpb.CarMedia{})
+}
+
+// serve runs New's server over an in-memory connection.
+func serve(t *testing.T, opts Options, srv carpetpb.CarServiceServer) carpetpb.CarServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	s := New(opts)
+	carpetpb.RegisterCarServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return carpetpb.NewCarServiceClient(conn)
+}
+
+// TestNew_AuthRunsFirst calls through the whole interceptor chain: callers
+// without a token never reach a handler of a non-public method, unary or
+// streaming, and are not told whether their request was valid.
+func TestNew_AuthRunsFirst(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := jwt.NewPrivateKey("test", priv)
+	require.NoError(t, err)
+	jwtSvc := jwt.NewJWTService("UserService", key)
+	srv := &carServer{}
+	client := serve(t, Options{JWT: *jwtSvc}, srv)
+	ctx := context.Background()
+
+	_, err = client.DeleteCar(ctx, &carpetpb.DeleteCarRequest{Id: uuid.NewString()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.DeleteCar(ctx, &carpetpb.DeleteCarRequest{Id: "not-a-uuid"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "auth before validation")
+
+	stream, err := client.UploadCarMedia(ctx)
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Zero(t, srv.calls, "a handler ran without auth")
+
+	_, err = client.GetCar(ctx, &carpetpb.GetCarRequest{Id: uuid.NewString()})
+	assert.NoError(t, err)
+
+	token, err := jwtSvc.GenerateToken("u1", "admin", "s1", []string{"cars:write"})
+	require.NoError(t, err)
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	_, err = client.DeleteCar(ctx, &carpetpb.DeleteCarRequest{Id: uuid.NewString()})
+	assert.NoError(t, err)
+	stream, err = client.UploadCarMedia(ctx)
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, srv.calls)
+}
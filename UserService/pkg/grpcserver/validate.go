@@ -0,0 +1,77 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+
+	"buf.build/go/protovalidate"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"CarStore/UserService/pkg/apperr"
+)
+
+// UnaryValidate checks requests against the buf.validate rules declared in
+// the proto files before the handler runs.
+func UnaryValidate() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := validateMessage(req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamValidate checks every message the client sends on a stream.
+func StreamValidate() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingStream{ServerStream: ss})
+	}
+}
+
+type validatingStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return validateMessage(m)
+}
+
+func validateMessage(m interface{}) error {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return nil
+	}
+	err := protovalidate.Validate(msg)
+	if err == nil {
+		return nil
+	}
+	var verr *protovalidate.ValidationError
+	if !errors.As(err, &verr) {
+		// Compilation or runtime errors mean a broken rule, not a bad request.
+		return err
+	}
+	return violationsError(verr)
+}
+
+// violationsError lists every violation as a field violation; the message
+// names the first one.
+func violationsError(verr *protovalidate.ValidationError) *apperr.Error {
+	e := &apperr.Error{Kind: apperr.InvalidArgument, Message: "invalid request"}
+	for _, v := range verr.Violations {
+		field := protovalidate.FieldPathString(v.Proto.GetField())
+		e.Fields = append(e.Fields, apperr.FieldViolation{Field: field, Description: v.Proto.GetMessage()})
+	}
+	if len(e.Fields) > 0 {
+		f := e.Fields[0]
+		if f.Field == "" {
+			e.Message = f.Description
+		} else {
+			e.Message = f.Field + ": " + f.Description
+		}
+	}
+	return e
+}
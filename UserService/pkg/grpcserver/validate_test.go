@@ -0,0 +1,61 @@
+package grpcserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	orderpb "CarStore/OrderService/api/pb/order"
+	userpb "CarStore/UserService/api/pb/user"
+	"CarStore/UserService/pkg/apperr"
+)
+
+// TestUnaryValidate runs the rules declared in the service protos.
+func TestUnaryValidate(t *testing.T) {
+	validate := UnaryValidate()
+	call := func(req interface{}) []apperr.FieldViolation {
+		called := false
+		_, err := validate(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			})
+		if err == nil {
+			assert.True(t, called, "valid request not handled")
+			return nil
+		}
+		assert.False(t, called, "invalid request handled")
+		e, ok := apperr.From(err)
+		require.True(t, ok, "%v", err)
+		assert.Equal(t, apperr.InvalidArgument, e.Kind)
+		return e.Fields
+	}
+
+	assert.Empty(t, call(&orderpb.CreateOrderRequest{CarId: uuid.NewString(), Quantity: 1}))
+	assert.Equal(t, []apperr.FieldViolation{
+		{Field: "car_id", Description: "value must be a valid UUID"},
+		{Field: "quantity", Description: "value must be greater than 0"},
+	}, call(&orderpb.CreateOrderRequest{CarId: "42", Quantity: -1}))
+
+	// the (user.password) rule
+	register := func(password string) []apperr.FieldViolation {
+		return call(&userpb.RegisterUserRequest{Email: "a@b.com", Username: "u1", Password: password})
+	}
+	assert.Empty(t, register("secret12"))
+	for password, want := range map[string]string{
+		"secret1":                      "must be at least 8 characters",
+		"secretsecret":                 "must contain a letter and a digit",
+		"12345678":                     "must contain a letter and a digit",
+		"s3" + strings.Repeat("a", 71): "must be at most 72 bytes",
+	} {
+		assert.Equal(t, []apperr.FieldViolation{{Field: "password", Description: want}}, register(password), password)
+	}
+	fields := call(&userpb.ResetPasswordRequest{Token: "t", NewPassword: "short"})
+	require.NotEmpty(t, fields)
+	assert.Equal(t, "new_password", fields[0].Field)
+}
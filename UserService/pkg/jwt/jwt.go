@@ -5,52 +5,110 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"time"
 )
 
+// AccessTokenTTL is the lifetime of an access token. Clients renew it with
+// a refresh token.
+const AccessTokenTTL = 15 * time.Minute
+
+// ErrNoSigningKey is returned when a verification-only service is asked to
+// mint a token.
+var ErrNoSigningKey = errors.New("no signing key configured")
+
+// JWTService signs tokens with one private key and verifies them against a
+// set of public keys selected by the "kid" header. Keeping the previous
+// public keys in the set lets tokens signed before a key rotation stay valid
+// until they expire.
+//
+// Verifiers in other services do not fetch the JWKS; they only know the
+// keys they are started with. A rotation therefore takes three steps:
+//  1. add the new public key to every verifier's key list and redeploy them;
+//  2. let the issuer sign with the new key, keeping the old public key for
+//     verification;
+//  3. once AccessTokenTTL has passed, drop the old key everywhere.
+//
+// Signing with a key before every verifier knows it gets its tokens
+// rejected.
 type JWTService struct {
-	secretKey string
-	issuer    string
+	issuer  string
+	signing *Key
+	keys    map[string]Key
 }
 
+// Claims carries the user and the session (refresh token family) the token
+// was issued for. RegisteredClaims.ID is the token's unique jti.
 type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
+	UserID      string   `json:"user_id"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"perms,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// NewJWTService creates a new JWTService with the given secret key and issuer
-func NewJWTService(secretKey, issuer string) *JWTService {
-	return &JWTService{secretKey: secretKey, issuer: issuer}
+// NewJWTService creates a JWTService that signs with the given key as issuer
+// and also accepts tokens signed by any of the verification keys
+func NewJWTService(issuer string, signing *Key, verify ...Key) *JWTService {
+	s := NewVerifier(issuer, verify...)
+	s.signing = signing
+	s.keys[signing.ID] = signing.Public()
+	return s
+}
+
+// NewVerifier creates a JWTService that can only verify tokens, and only
+// those of the given issuer. The services other than the issuer use it with
+// the issuer's public keys, so they hold no key that could mint a token.
+func NewVerifier(issuer string, verify ...Key) *JWTService {
+	keys := make(map[string]Key, len(verify))
+	for _, k := range verify {
+		keys[k.ID] = k.Public()
+	}
+	return &JWTService{issuer: issuer, keys: keys}
 }
 
-// GenerateToken creates a signed JWT for the specified user ID and role
-func (s *JWTService) GenerateToken(userID string, role string) (string, error) {
+// GenerateToken creates a signed, short-lived JWT for the specified user ID,
+// role and the role's permissions within the given session
+func (s *JWTService) GenerateToken(userID, role, sessionID string, permissions []string) (string, error) {
+	if s.signing == nil {
+		return "", ErrNoSigningKey
+	}
+	now := time.Now()
 	claims := Claims{
-		UserID: userID,
-		Role:   role,
+		UserID:      userID,
+		Role:        role,
+		Permissions: permissions,
+		SessionID:   sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			Issuer:    s.issuer,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(s.secretKey))
+	token := jwt.NewWithClaims(s.signing.method(), claims)
+	token.Header["kid"] = s.signing.ID
+	signedToken, err := token.SignedString(s.signing.private)
 	if err != nil {
 		return "", err
 	}
 	return signedToken, nil
 }
 
-// ValidateToken parses and validates a JWT string, returning the Claims if valid
+// ValidateToken parses and validates a JWT string, returning the Claims if
+// valid. Tokens must carry an expiry and the service's issuer.
 func (s *JWTService) ValidateToken(tokenStr string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if t.Method.Alg() != key.Alg {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
-		return []byte(s.secretKey), nil
-	})
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}), jwt.WithExpirationRequired(), jwt.WithIssuer(s.issuer))
 	if err != nil {
 		return nil, err
 	}
@@ -60,3 +118,12 @@ func (s *JWTService) ValidateToken(tokenStr string) (*Claims, error) {
 	}
 	return claims, nil
 }
+
+// Keys returns the public verification keys, e.g. to publish them as JWKS.
+func (s *JWTService) Keys() []Key {
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,107 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEdKey(t *testing.T, kid string) *Key {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	k, err := NewPrivateKey(kid, priv)
+	require.NoError(t, err)
+	return k
+}
+
+func TestJWTService_KeyRotation(t *testing.T) {
+	oldKey := newEdKey(t, "old")
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := NewPrivateKey("new", rsaPriv)
+	require.NoError(t, err)
+
+	oldToken, err := NewJWTService("UserService", oldKey).GenerateToken("u1", "user", "s1", nil)
+	require.NoError(t, err)
+
+	// After rotation the old public key still verifies tokens it signed
+	rotated := NewJWTService("UserService", newKey, oldKey.Public())
+	claims, err := rotated.ValidateToken(oldToken)
+	require.NoError(t, err)
+	assert.Equal(t, "u1", claims.UserID)
+
+	newToken, err := rotated.GenerateToken("u2", "admin", "s2", []string{"cars:write"})
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", parsed.Header["kid"])
+	assert.Equal(t, AlgRS256, parsed.Header["alg"])
+
+	// A verifier holding public keys only can verify but not sign
+	verifier := NewVerifier("UserService", rotated.Keys()...)
+	claims, err = verifier.ValidateToken(newToken)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Role)
+	assert.Equal(t, []string{"cars:write"}, claims.Permissions)
+	_, err = verifier.GenerateToken("u3", "admin", "", nil)
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+
+	// Once the old key is dropped its tokens are rejected
+	_, err = NewVerifier("UserService", newKey.Public()).ValidateToken(oldToken)
+	assert.Error(t, err)
+
+	jwks := rotated.JWKS()
+	require.Len(t, jwks, 2)
+	assert.Equal(t, JWK{Kty: "RSA", Kid: "new", Alg: AlgRS256, Use: "sig", N: jwks[0].N, E: "AQAB"}, jwks[0])
+	assert.Equal(t, "OKP", jwks[1].Kty)
+	assert.Equal(t, "Ed25519", jwks[1].Crv)
+}
+
+func TestJWTService_RejectsSymmetricTokens(t *testing.T) {
+	key := newEdKey(t, "k1")
+	svc := NewJWTService("UserService", key)
+
+	// An HS256 token "signed" with a guessable secret must not verify
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		UserID: "attacker",
+		Role:   "admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	forged.Header["kid"] = "k1"
+	signed, err := forged.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = svc.ValidateToken(signed)
+	assert.Error(t, err)
+}
+
+func TestJWTService_RequiresExpiry(t *testing.T) {
+	key := newEdKey(t, "k1")
+	svc := NewJWTService("UserService", key)
+
+	// Handlers read ExpiresAt, so a validly signed token without one is rejected
+	token := jwt.NewWithClaims(key.method(), Claims{UserID: "u1", Role: "user", RegisteredClaims: jwt.RegisteredClaims{Issuer: "UserService"}})
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(key.private)
+	require.NoError(t, err)
+	_, err = svc.ValidateToken(signed)
+	assert.ErrorIs(t, err, jwt.ErrTokenRequiredClaimMissing)
+}
+
+func TestJWTService_RequiresIssuer(t *testing.T) {
+	key := newEdKey(t, "k1")
+	token, err := NewJWTService("OtherService", key).GenerateToken("u1", "user", "s1", nil)
+	require.NoError(t, err)
+
+	// A token signed with a trusted key for another issuer is rejected
+	_, err = NewVerifier("UserService", key.Public()).ValidateToken(token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+}
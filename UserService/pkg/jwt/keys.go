@@ -0,0 +1,163 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms.
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is a signing or verification key identified by its kid.
+type Key struct {
+	ID      string
+	Alg     string
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// NewPrivateKey wraps an RSA or Ed25519 private key.
+func NewPrivateKey(kid string, priv crypto.Signer) (*Key, error) {
+	k, err := NewPublicKey(kid, priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	k.private = priv
+	return &k, nil
+}
+
+// NewPublicKey wraps an RSA or Ed25519 public key.
+func NewPublicKey(kid string, pub crypto.PublicKey) (Key, error) {
+	if kid == "" {
+		return Key{}, errors.New("key id required")
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return Key{ID: kid, Alg: AlgRS256, public: pub}, nil
+	case ed25519.PublicKey:
+		return Key{ID: kid, Alg: AlgEdDSA, public: pub}, nil
+	default:
+		return Key{}, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// Public returns the key without its private part.
+func (k Key) Public() Key {
+	k.private = nil
+	return k
+}
+
+func (k Key) method() jwt.SigningMethod {
+	if k.Alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// LoadPrivateKey reads a PKCS#8 PEM private key from path.
+func LoadPrivateKey(kid, path string) (*Key, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, priv)
+	}
+	return NewPrivateKey(kid, signer)
+}
+
+// LoadPublicKeys reads PKIX PEM public keys from a comma separated list of
+// kid=path pairs, e.g. "2024-06=/keys/old.pem,2024-09=/keys/new.pem".
+func LoadPublicKeys(spec string) ([]Key, error) {
+	var keys []Key
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("public key %q: want kid=path", entry)
+		}
+		block, err := readPEM(path)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		k, err := NewPublicKey(kid, pub)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+// JWK is the RFC 7517 representation of a public key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS returns the verification keys as JWKs, ordered by kid.
+func (s *JWTService) JWKS() []JWK {
+	keys := s.Keys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	out := make([]JWK, 0, len(keys))
+	for _, k := range keys {
+		jwk := JWK{Kid: k.ID, Alg: k.Alg, Use: "sig"}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		out = append(out, jwk)
+	}
+	return out
+}
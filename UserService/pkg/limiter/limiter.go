@@ -0,0 +1,155 @@
+// Package limiter throttles guessable operations, such as logins and
+// verification codes, with failure counters in Redis.
+//
+// Each key gets a number of free attempts per window. Every attempt past
+// that has to wait for a delay that doubles with each further attempt, up
+// to a maximum. Attempts are counted as they start, in one Redis script,
+// so concurrent attempts are limited as well as sequential ones.
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"CarStore/UserService/pkg/apperr"
+)
+
+// ErrLocked matches every *LockedError.
+var ErrLocked = errors.New("too many attempts")
+
+// LockedError is returned while a key is locked out.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// Unwrap exposes the lockout as an apperr.ResourceExhausted error carrying
+// the retry delay.
+func (e *LockedError) Unwrap() error {
+	return &apperr.Error{Kind: apperr.ResourceExhausted, Message: e.Error(), RetryAfter: e.RetryAfter}
+}
+
+// Policy configures a Limiter.
+type Policy struct {
+	Free      int           // attempts allowed per window before locking
+	Window    time.Duration // attempts are forgotten this long after the last one
+	BaseDelay time.Duration // lockout before the first attempt past Free
+	MaxDelay  time.Duration
+}
+
+// Limiter counts attempts per key. Keys are namespaced by the limiter name,
+// so one Redis instance can hold several limiters.
+type Limiter struct {
+	rdb    *redis.Client
+	name   string
+	policy Policy
+}
+
+func New(rdb *redis.Client, name string, p Policy) *Limiter {
+	return &Limiter{rdb: rdb, name: name, policy: p}
+}
+
+// take counts an attempt unless the key is locked, and locks it for the
+// delay the next attempt would have to wait. KEYS are the counter and the
+// lock; ARGV the window in ms, the free attempts, and the delays in ms for
+// the attempts past them, the last repeating. It returns the remaining
+// lockout in ms, or 0 if the attempt was counted.
+var take = redis.NewScript(`
+local ttl = redis.call('PTTL', KEYS[2])
+if ttl > 0 then
+	return ttl
+end
+local n = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+local over = n + 1 - tonumber(ARGV[2])
+if over > 0 then
+	local delay = tonumber(ARGV[math.min(over, #ARGV - 2) + 2])
+	if delay > 0 then
+		redis.call('SET', KEYS[2], 1, 'PX', delay)
+	end
+end
+return 0
+`)
+
+// forgive uncounts an attempt, lifting the lockout it set. KEYS are the
+// counter and the lock; ARGV[1] the free attempts.
+var forgive = redis.NewScript(`
+local n = redis.call('DECR', KEYS[1])
+if n <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+if n < tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[2])
+end
+return n
+`)
+
+// Take counts an attempt for key before it is made, so concurrent attempts
+// cannot get past the limit together. It returns a *LockedError, without
+// counting, while key is locked out. Once the free attempts are used up,
+// each attempt locks key until the next one is allowed. Callers Reset the
+// key when the attempt succeeds, or Forgive it when it should not count.
+func (l *Limiter) Take(ctx context.Context, key string) error {
+	args := []interface{}{l.policy.Window.Milliseconds(), l.policy.Free}
+	for n := l.policy.Free + 1; ; n++ {
+		delay := l.Delay(n)
+		args = append(args, delay.Milliseconds())
+		if delay == 0 || delay >= l.policy.MaxDelay {
+			break
+		}
+	}
+	ttl, err := take.Run(ctx, l.rdb, []string{l.countKey(key), l.lockKey(key)}, args...).Int64()
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return &LockedError{RetryAfter: time.Duration(ttl) * time.Millisecond}
+	}
+	return nil
+}
+
+// Forgive takes back an attempt counted by Take, such as a successful one
+// from a client whose other attempts must still count.
+func (l *Limiter) Forgive(ctx context.Context, key string) error {
+	return forgive.Run(ctx, l.rdb, []string{l.countKey(key), l.lockKey(key)}, l.policy.Free).Err()
+}
+
+// Reset forgets all attempts for key and lifts its lockout.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.rdb.Del(ctx, l.countKey(key), l.lockKey(key)).Err()
+}
+
+// Delay is the lockout before the n-th attempt in a window.
+func (l *Limiter) Delay(n int) time.Duration {
+	over := n - l.policy.Free
+	if over <= 0 {
+		return 0
+	}
+	delay := l.policy.BaseDelay
+	for i := 1; i < over && delay < l.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.policy.MaxDelay {
+		delay = l.policy.MaxDelay
+	}
+	return delay
+}
+
+func (l *Limiter) countKey(key string) string {
+	return "limit:" + l.name + ":count:" + key
+}
+
+func (l *Limiter) lockKey(key string) string {
+	return "limit:" + l.name + ":lock:" + key
+}
@@ -0,0 +1,100 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelay(t *testing.T) {
+	l := New(nil, "test", Policy{Free: 2, BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+	for n, want := range map[int]time.Duration{
+		1: 0,
+		2: 0,
+		3: time.Second,
+		4: 2 * time.Second,
+		5: 4 * time.Second,
+		6: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		assert.Equal(t, want, l.Delay(n), "attempt %d", n)
+	}
+}
+
+func TestLimiter_LocksOutAndResets(t *testing.T) {
+	mredis, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mredis.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: mredis.Addr()})
+
+	ctx := context.Background()
+	l := New(rdb, "test", Policy{Free: 2, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour})
+
+	assert.NoError(t, l.Take(ctx, "k"))
+	assert.NoError(t, l.Take(ctx, "k"))
+
+	err = l.Take(ctx, "k")
+	var locked *LockedError
+	require.True(t, errors.As(err, &locked))
+	assert.Equal(t, time.Minute, locked.RetryAfter)
+	assert.NoError(t, l.Take(ctx, "other"), "keys are independent")
+
+	// the lockout expires, but the next attempt doubles it
+	mredis.FastForward(time.Minute)
+	assert.NoError(t, l.Take(ctx, "k"))
+	err = l.Take(ctx, "k")
+	require.True(t, errors.As(err, &locked))
+	assert.Equal(t, 2*time.Minute, locked.RetryAfter)
+
+	assert.NoError(t, l.Reset(ctx, "k"))
+	assert.NoError(t, l.Take(ctx, "k"))
+}
+
+func TestLimiter_Forgive(t *testing.T) {
+	mredis, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mredis.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: mredis.Addr()})
+
+	ctx := context.Background()
+	l := New(rdb, "test", Policy{Free: 2, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour})
+
+	// a forgiven attempt neither counts nor locks
+	assert.NoError(t, l.Take(ctx, "k"))
+	assert.NoError(t, l.Take(ctx, "k"))
+	assert.NoError(t, l.Forgive(ctx, "k"))
+	assert.NoError(t, l.Take(ctx, "k"))
+	assert.ErrorIs(t, l.Take(ctx, "k"), ErrLocked)
+}
+
+func TestLimiter_ConcurrentAttempts(t *testing.T) {
+	mredis, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mredis.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: mredis.Addr()})
+
+	ctx := context.Background()
+	l := New(rdb, "test", Policy{Free: 3, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour})
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Take(ctx, "k") == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), allowed.Load())
+}
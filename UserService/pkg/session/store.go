@@ -0,0 +1,237 @@
+// Package session keeps refresh tokens and access token revocations in Redis.
+//
+// Every login starts a session: a family of refresh tokens sharing one
+// session id. Refreshing rotates the token, and presenting an already used
+// token revokes the whole family, since it means the token was stolen.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+
+	"CarStore/UserService/pkg/apperr"
+	"CarStore/UserService/pkg/jwt"
+)
+
+// RefreshTokenTTL is the lifetime of a refresh token.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = apperr.New(apperr.Unauthenticated, "invalid refresh token")
+	// ErrRefreshTokenReused is returned when a rotated refresh token is
+	// presented again; its session has been revoked.
+	ErrRefreshTokenReused = apperr.New(apperr.Unauthenticated, "refresh token reused, session revoked")
+)
+
+type Store struct {
+	rdb *redis.Client
+}
+
+func NewStore(rdb *redis.Client) *Store {
+	return &Store{rdb: rdb}
+}
+
+// Session is what a refresh token resolves to.
+type Session struct {
+	UserID    string
+	SessionID string
+	StartedAt time.Time
+}
+
+// Start opens a new session for the user and returns its first refresh token.
+func (s *Store) Start(ctx context.Context, userID string) (refreshToken string, sess *Session, err error) {
+	sess = &Session{UserID: userID, SessionID: uuid.NewString(), StartedAt: time.Now()}
+	refreshToken, err = s.issue(ctx, sess)
+	if err != nil {
+		return "", nil, err
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.SAdd(ctx, userSessionsKey(userID), sess.SessionID)
+	pipe.Expire(ctx, userSessionsKey(userID), RefreshTokenTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", nil, err
+	}
+	return refreshToken, sess, nil
+}
+
+// Rotate exchanges a refresh token for a new one in the same session. The
+// old token can only be exchanged once.
+func (s *Store) Rotate(ctx context.Context, refreshToken string) (string, *Session, error) {
+	hash := hashToken(refreshToken)
+	vals, err := s.rdb.HGetAll(ctx, tokenKey(hash)).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(vals) == 0 {
+		return "", nil, ErrInvalidRefreshToken
+	}
+	started, _ := strconv.ParseInt(vals["started"], 10, 64)
+	sess := &Session{UserID: vals["user_id"], SessionID: vals["sid"], StartedAt: time.UnixMilli(started)}
+
+	revoked, err := s.rdb.Exists(ctx, revokedSessionKey(sess.SessionID)).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	if revoked > 0 {
+		return "", nil, ErrInvalidRefreshToken
+	}
+	cutoff, err := s.revokedBefore(ctx, sess.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+	if sess.StartedAt.Before(cutoff) {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	first, err := s.rdb.SetNX(ctx, usedKey(hash), 1, RefreshTokenTTL).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	if !first {
+		if err := s.RevokeSession(ctx, sess.SessionID); err != nil {
+			return "", nil, err
+		}
+		return "", nil, ErrRefreshTokenReused
+	}
+
+	next, err := s.issue(ctx, sess)
+	if err != nil {
+		return "", nil, err
+	}
+	return next, sess, nil
+}
+
+// RevokeSession invalidates every refresh and access token of a session.
+func (s *Store) RevokeSession(ctx context.Context, sessionID string) error {
+	return s.rdb.Set(ctx, revokedSessionKey(sessionID), 1, RefreshTokenTTL).Err()
+}
+
+// RevokeOtherSessions revokes every session of a user except keepSessionID.
+func (s *Store) RevokeOtherSessions(ctx context.Context, userID, keepSessionID string) error {
+	ids, err := s.rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == keepSessionID {
+			continue
+		}
+		if err := s.RevokeSession(ctx, id); err != nil {
+			return err
+		}
+		if err := s.rdb.SRem(ctx, userSessionsKey(userID), id).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeUserSessions invalidates every session of a user started before the
+// given time, e.g. after a password reset.
+func (s *Store) RevokeUserSessions(ctx context.Context, userID string, before time.Time) error {
+	return s.rdb.Set(ctx, revokedBeforeKey(userID), before.UnixMilli(), RefreshTokenTTL).Err()
+}
+
+// RevokeToken denylists one access token until it expires.
+func (s *Store) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.rdb.Set(ctx, denylistKey(jti), 1, ttl).Err()
+}
+
+// SetSuspended marks a user as suspended, or lifts the suspension.
+func (s *Store) SetSuspended(ctx context.Context, userID string, suspended bool) error {
+	if !suspended {
+		return s.rdb.Del(ctx, suspendedKey(userID)).Err()
+	}
+	return s.rdb.Set(ctx, suspendedKey(userID), 1, 0).Err()
+}
+
+// IsSuspended reports whether the user is suspended.
+func (s *Store) IsSuspended(ctx context.Context, userID string) (bool, error) {
+	n, err := s.rdb.Exists(ctx, suspendedKey(userID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// IsRevoked reports whether an access token was denylisted, belongs to a
+// revoked session, or was issued before its user's sessions were revoked.
+func (s *Store) IsRevoked(ctx context.Context, claims *jwt.Claims) (bool, error) {
+	keys := []string{denylistKey(claims.ID)}
+	if claims.SessionID != "" {
+		keys = append(keys, revokedSessionKey(claims.SessionID))
+	}
+	n, err := s.rdb.Exists(ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+	cutoff, err := s.revokedBefore(ctx, claims.UserID)
+	if err != nil {
+		return false, err
+	}
+	if cutoff.IsZero() || claims.IssuedAt == nil {
+		return false, nil
+	}
+	// iat has second precision, so a token from the same second as the
+	// cutoff counts as revoked
+	return !claims.IssuedAt.Time.After(cutoff.Truncate(time.Second)), nil
+}
+
+// revokedBefore returns the user's session cutoff, or the zero time.
+func (s *Store) revokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	ms, err := s.rdb.Get(ctx, revokedBeforeKey(userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(ms), nil
+}
+
+func (s *Store) issue(ctx context.Context, sess *Session) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	key := tokenKey(hashToken(token))
+
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", sess.UserID, "sid", sess.SessionID, "started", sess.StartedAt.UnixMilli())
+	pipe.Expire(ctx, key, RefreshTokenTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Only token hashes are stored, so a Redis dump does not leak usable tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func tokenKey(hash string) string               { return "refresh:token:" + hash }
+func usedKey(hash string) string                { return "refresh:used:" + hash }
+func revokedSessionKey(sessionID string) string { return "session:revoked:" + sessionID }
+func userSessionsKey(userID string) string      { return "user:sessions:" + userID }
+func revokedBeforeKey(userID string) string     { return "user:revoked_before:" + userID }
+func denylistKey(jti string) string             { return "jwt:denylist:" + jti }
+func suspendedKey(userID string) string         { return "user:suspended:" + userID }
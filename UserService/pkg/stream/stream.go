@@ -0,0 +1,191 @@
+// Package stream sets up the JetStream streams shared by the services and
+// runs durable consumers with explicit ack, backoff and dead-lettering.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	StreamOrders = "ORDERS"
+	StreamStock  = "STOCK"
+	StreamDLQ    = "DLQ"
+
+	// DLQPrefix is prepended to the subject of dead-lettered messages.
+	DLQPrefix = "dlq."
+)
+
+// Headers set on dead-lettered messages.
+const (
+	HeaderDLQReason     = "Dlq-Reason"
+	HeaderDLQStream     = "Dlq-Stream"
+	HeaderDLQSequence   = "Dlq-Sequence"
+	HeaderDLQDeliveries = "Dlq-Deliveries"
+)
+
+// Backoff is the redelivery delay after each failed attempt. A message is
+// dead-lettered after MaxDeliver attempts.
+var Backoff = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute, 5 * time.Minute}
+
+var MaxDeliver = len(Backoff) + 1
+
+// ackWait bounds how long a delivery may stay unacknowledged, e.g. when a
+// consumer crashes mid-message, before the server redelivers it.
+const ackWait = 30 * time.Second
+
+var streams = []jetstream.StreamConfig{
+	{
+		Name:       StreamOrders,
+		Subjects:   []string{"order.>"},
+		Storage:    jetstream.FileStorage,
+		MaxAge:     7 * 24 * time.Hour,
+		Duplicates: 2 * time.Minute,
+	},
+	{
+		Name:       StreamStock,
+		Subjects:   []string{"stock.>"},
+		Storage:    jetstream.FileStorage,
+		MaxAge:     7 * 24 * time.Hour,
+		Duplicates: 2 * time.Minute,
+	},
+	{
+		Name:     StreamDLQ,
+		Subjects: []string{DLQPrefix + ">"},
+		Storage:  jetstream.FileStorage,
+		MaxAge:   30 * 24 * time.Hour,
+	},
+}
+
+// EnsureStreams creates the streams, or updates them to the expected
+// configuration if they already exist. It is safe to call on every startup.
+func EnsureStreams(ctx context.Context, js jetstream.JetStream) error {
+	for _, cfg := range streams {
+		if _, err := js.CreateOrUpdateStream(ctx, cfg); err != nil {
+			return fmt.Errorf("stream %s: %w", cfg.Name, err)
+		}
+	}
+	return nil
+}
+
+// Publish marshals v as JSON and publishes it to the stream. The server
+// drops a second message with the same msgID within the duplicate window.
+func Publish(ctx context.Context, js jetstream.JetStream, subject, msgID string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = js.Publish(ctx, subject, data, jetstream.WithMsgID(msgID))
+	return err
+}
+
+// permanentError marks a failure that retrying cannot fix.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the message is dead-lettered without redelivery,
+// e.g. for payloads that cannot be decoded.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Handler processes one message. A nil error acks it; any other error naks
+// it for redelivery unless it is Permanent or the last allowed attempt.
+type Handler func(ctx context.Context, msg jetstream.Msg) error
+
+// Consumer describes a durable consumer on a stream.
+type Consumer struct {
+	Stream  string
+	Durable string
+	Subject string
+}
+
+// Consume creates or updates the durable pull consumer and processes its
+// messages with h until ctx is cancelled.
+func Consume(ctx context.Context, js jetstream.JetStream, c Consumer, h Handler) error {
+	cons, err := js.CreateOrUpdateConsumer(ctx, c.Stream, jetstream.ConsumerConfig{
+		Durable:       c.Durable,
+		FilterSubject: c.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       ackWait,
+		MaxDeliver:    MaxDeliver,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("consumer %s: %w", c.Durable, err)
+	}
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		handle(ctx, js, msg, h)
+	})
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", c.Durable, err)
+	}
+	go func() {
+		<-ctx.Done()
+		cc.Stop()
+	}()
+	return nil
+}
+
+func handle(ctx context.Context, js jetstream.JetStream, msg jetstream.Msg, h Handler) {
+	err := h(ctx, msg)
+	if err == nil {
+		if err := msg.Ack(); err != nil {
+			log.Printf("ack %s: %v", msg.Subject(), err)
+		}
+		return
+	}
+
+	md, mdErr := msg.Metadata()
+	if mdErr != nil {
+		log.Printf("metadata %s: %v", msg.Subject(), mdErr)
+		_ = msg.Nak()
+		return
+	}
+	var perm permanentError
+	if errors.As(err, &perm) || md.NumDelivered >= uint64(MaxDeliver) {
+		log.Printf("dead-lettering %s (seq %d, attempt %d): %v", msg.Subject(), md.Sequence.Stream, md.NumDelivered, err)
+		if dlqErr := deadLetter(ctx, js, msg, md, err); dlqErr != nil {
+			// Leave the message unacked so it is redelivered and dead-lettered later.
+			log.Printf("dead-letter %s: %v", msg.Subject(), dlqErr)
+			_ = msg.NakWithDelay(Backoff[len(Backoff)-1])
+			return
+		}
+		_ = msg.Term()
+		return
+	}
+
+	delay := Backoff[len(Backoff)-1]
+	if i := int(md.NumDelivered) - 1; i < len(Backoff) {
+		delay = Backoff[i]
+	}
+	log.Printf("%s (seq %d, attempt %d) failed, redelivering in %s: %v", msg.Subject(), md.Sequence.Stream, md.NumDelivered, delay, err)
+	_ = msg.NakWithDelay(delay)
+}
+
+// deadLetter republishes msg to dlq.<subject>, keeping its headers and
+// recording why and where it came from.
+func deadLetter(ctx context.Context, js jetstream.JetStream, msg jetstream.Msg, md *jetstream.MsgMetadata, cause error) error {
+	out := nats.NewMsg(DLQPrefix + msg.Subject())
+	out.Data = msg.Data()
+	for k, v := range msg.Headers() {
+		out.Header[k] = v
+	}
+	out.Header.Set(HeaderDLQReason, cause.Error())
+	out.Header.Set(HeaderDLQStream, md.Stream)
+	out.Header.Set(HeaderDLQSequence, strconv.FormatUint(md.Sequence.Stream, 10))
+	out.Header.Set(HeaderDLQDeliveries, strconv.FormatUint(md.NumDelivered, 10))
+	out.Header.Del(jetstream.MsgIDHeader)
+	_, err := js.PublishMsg(ctx, out)
+	return err
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
@@ -20,14 +21,26 @@ func run() error {
 	_ = godotenv.Load()
 
 	ctx := context.Background()
-	mux := runtime.NewServeMux()
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeader),
+	)
 	opts := []grpc.DialOption{grpc.WithInsecure()}
 
 	// register each service
 	if err := userpb.RegisterUserServiceHandlerFromEndpoint(ctx, mux, "localhost:50052", opts); err != nil {
 		return err
 	}
-	if err := carpetpb.RegisterCarServiceHandlerFromEndpoint(ctx, mux, "localhost:50053", opts); err != nil {
+	// the car connection is shared with the media routes
+	carConn, err := grpc.NewClient("localhost:50053", opts...)
+	if err != nil {
+		return err
+	}
+	defer carConn.Close()
+	if err := carpetpb.RegisterCarServiceHandler(ctx, mux, carConn); err != nil {
+		return err
+	}
+	if err := registerMediaRoutes(mux, carpetpb.NewCarServiceClient(carConn)); err != nil {
 		return err
 	}
 	if err := orderpb.RegisterOrderServiceHandlerFromEndpoint(ctx, mux, "localhost:50054", opts); err != nil {
@@ -39,6 +52,22 @@ func run() error {
 	return http.ListenAndServe(":"+port, mux)
 }
 
+// the request ID travels as plain X-Request-Id in both directions so that
+// clients and service logs can be correlated
+func incomingHeader(key string) (string, bool) {
+	if strings.EqualFold(key, "X-Request-Id") {
+		return "x-request-id", true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func outgoingHeader(key string) (string, bool) {
+	if key == "x-request-id" {
+		return "X-Request-Id", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
 func main() {
 	flag.Parse()
 	log.Fatal(run())
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	carpetpb "CarStore/CarService/api/pb/car"
+)
+
+const (
+	// maxUploadBytes leaves room for the multipart framing around
+	// CarService's 10 MiB image limit.
+	maxUploadBytes = 11 << 20
+	// uploadChunkSize is the size of the chunks streamed to CarService.
+	uploadChunkSize = 64 << 10
+)
+
+// registerMediaRoutes adds the car image routes that have no
+// google.api.http mapping: multipart uploads and raw image downloads.
+func registerMediaRoutes(mux *runtime.ServeMux, client carpetpb.CarServiceClient) error {
+	if err := mux.HandlePath(http.MethodPost, "/cars/{car_id}/media", uploadCarMedia(mux, client)); err != nil {
+		return err
+	}
+	return mux.HandlePath(http.MethodGet, "/cars/{car_id}/media/{media_id}", getCarMedia(mux, client))
+}
+
+// uploadCarMedia streams the "file" field of a multipart form to
+// UploadCarMedia without buffering it, and answers with the CarMedia.
+func uploadCarMedia(mux *runtime.ServeMux, client carpetpb.CarServiceClient) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, "/car.CarService/UploadCarMedia",
+			runtime.WithHTTPPathPattern("/cars/{car_id}/media"))
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+		file, err := formFile(r, "file")
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel() // aborts the stream on early returns
+		stream, err := client.UploadCarMedia(ctx)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		// a failed Send is reported by CloseAndRecv
+		meta := &carpetpb.UploadCarMediaRequest{Data: &carpetpb.UploadCarMediaRequest_Metadata{
+			Metadata: &carpetpb.UploadCarMediaMetadata{CarId: params["car_id"]},
+		}}
+		if stream.Send(meta) == nil {
+			if err := sendChunks(stream, file); err != nil {
+				runtime.HTTPError(ctx, mux, outbound, w, r, uploadError(err))
+				return
+			}
+		}
+
+		resp, err := stream.CloseAndRecv()
+		md := runtime.ServerMetadata{TrailerMD: stream.Trailer()}
+		md.HeaderMD, _ = stream.Header()
+		ctx = runtime.NewServerMetadataContext(ctx, md)
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		runtime.ForwardResponseMessage(ctx, mux, outbound, w, r, resp)
+	}
+}
+
+// sendChunks streams r until it is drained or a Send fails, and returns
+// only read errors.
+func sendChunks(stream carpetpb.CarService_UploadCarMediaClient, r io.Reader) error {
+	buf := make([]byte, uploadChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if stream.Send(&carpetpb.UploadCarMediaRequest{Data: &carpetpb.UploadCarMediaRequest_Chunk{Chunk: buf[:n]}}) != nil {
+				return nil
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// formFile returns the content of the named file field, which must come
+// before any other file in the form.
+func formFile(r *http.Request, name string) (io.Reader, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "request must be multipart/form-data")
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, status.Errorf(codes.InvalidArgument, "missing %q field", name)
+		}
+		if err != nil {
+			return nil, uploadError(err)
+		}
+		if part.FormName() == name {
+			return part, nil
+		}
+	}
+}
+
+func uploadError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return status.Error(codes.InvalidArgument, "upload is too large")
+	}
+	return status.Errorf(codes.InvalidArgument, "read upload: %v", err)
+}
+
+// getCarMedia writes the image streamed by GetCarMedia as the response
+// body. Media ids are never reused, so the image may be cached for good.
+func getCarMedia(mux *runtime.ServeMux, client carpetpb.CarServiceClient) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, params map[string]string) {
+		_, outbound := runtime.MarshalerForRequest(mux, r)
+		ctx, err := runtime.AnnotateContext(r.Context(), mux, r, "/car.CarService/GetCarMedia",
+			runtime.WithHTTPPathPattern("/cars/{car_id}/media/{media_id}"))
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stream, err := client.GetCarMedia(ctx, &carpetpb.GetCarMediaRequest{
+			CarId:     params["car_id"],
+			MediaId:   params["media_id"],
+			Thumbnail: r.URL.Query().Get("thumbnail") == "true",
+		})
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		// errors are only reported before the first chunk; afterwards
+		// the client sees a truncated body
+		chunk, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = status.Error(codes.Internal, "empty media")
+			}
+			runtime.HTTPError(ctx, mux, outbound, w, r, err)
+			return
+		}
+		if md, err := stream.Header(); err == nil {
+			forwardHeaders(w, md)
+		}
+		w.Header().Set("Content-Type", chunk.ContentType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		for err == nil {
+			if _, err = w.Write(chunk.Data); err == nil {
+				chunk, err = stream.Recv()
+			}
+		}
+	}
+}
+
+// forwardHeaders sets the response headers the gateway passes on for
+// gRPC header metadata, such as X-Request-Id.
+func forwardHeaders(w http.ResponseWriter, md metadata.MD) {
+	for k, vs := range md {
+		if h, ok := outgoingHeader(k); ok {
+			for _, v := range vs {
+				w.Header().Add(h, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	carpetpb "CarStore/CarService/api/pb/car"
+)
+
+// fakeCarClient keeps uploaded images in memory, standing in for the
+// media RPCs of CarService.
+type fakeCarClient struct {
+	carpetpb.CarServiceClient
+	images map[string][]byte
+}
+
+func (c *fakeCarClient) UploadCarMedia(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[carpetpb.UploadCarMediaRequest, carpetpb.CarMedia], error) {
+	return &fakeUpload{client: c}, nil
+}
+
+func (c *fakeCarClient) GetCarMedia(ctx context.Context, in *carpetpb.GetCarMediaRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[carpetpb.CarMediaChunk], error) {
+	data, ok := c.images[in.MediaId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "media not found")
+	}
+	// three chunks, the content type in the first only
+	third := len(data)/3 + 1
+	var chunks []*carpetpb.CarMediaChunk
+	for i := 0; i < len(data); i += third {
+		chunks = append(chunks, &carpetpb.CarMediaChunk{Data: data[i:min(i+third, len(data))]})
+	}
+	chunks[0].ContentType = "image/png"
+	return &fakeDownload{chunks: chunks}, nil
+}
+
+type fakeUpload struct {
+	grpc.ClientStream
+	client *fakeCarClient
+	reqs   []*carpetpb.UploadCarMediaRequest
+}
+
+// Send copies req, as a real stream marshals it before returning;
+// sendChunks reuses its buffer.
+func (u *fakeUpload) Send(req *carpetpb.UploadCarMediaRequest) error {
+	u.reqs = append(u.reqs, proto.Clone(req).(*carpetpb.UploadCarMediaRequest))
+	return nil
+}
+
+func (u *fakeUpload) CloseAndRecv() (*carpetpb.CarMedia, error) {
+	if len(u.reqs) == 0 || u.reqs[0].GetMetadata() == nil {
+		return nil, status.Error(codes.InvalidArgument, "metadata must be the first message")
+	}
+	var data []byte
+	for _, req := range u.reqs[1:] {
+		data = append(data, req.GetChunk()...)
+	}
+	id := "m" + strconv.Itoa(len(u.client.images))
+	u.client.images[id] = data
+	return &carpetpb.CarMedia{Id: id, ContentType: "image/png", Size: int64(len(data))}, nil
+}
+
+func (u *fakeUpload) Header() (metadata.MD, error) { return nil, nil }
+func (u *fakeUpload) Trailer() metadata.MD         { return nil }
+
+type fakeDownload struct {
+	grpc.ClientStream
+	chunks []*carpetpb.CarMediaChunk
+}
+
+func (d *fakeDownload) Recv() (*carpetpb.CarMediaChunk, error) {
+	if len(d.chunks) == 0 {
+		return nil, io.EOF
+	}
+	c := d.chunks[0]
+	d.chunks = d.chunks[1:]
+	return c, nil
+}
+
+func (d *fakeDownload) Header() (metadata.MD, error) {
+	return metadata.Pairs("x-request-id", "req-1"), nil
+}
+
+// multipartBody returns a form with one field holding content.
+func multipartBody(t *testing.T, field string, content []byte) (io.Reader, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile(field, "car.png")
+	require.NoError(t, err)
+	part.Write(content)
+	require.NoError(t, w.Close())
+	return &buf, w.FormDataContentType()
+}
+
+func TestMediaRoutes(t *testing.T) {
+	client := &fakeCarClient{images: make(map[string][]byte)}
+	mux := runtime.NewServeMux(runtime.WithOutgoingHeaderMatcher(outgoingHeader))
+	require.NoError(t, registerMediaRoutes(mux, client))
+	upload := func(field string, content []byte) *httptest.ResponseRecorder {
+		body, contentType := multipartBody(t, field, content)
+		req := httptest.NewRequest(http.MethodPost, "/cars/c1/media", body)
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The form must have a file field
+	rec := upload("photo", []byte("png"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `missing \"file\" field`)
+
+	// Bodies over the limit are cut off
+	rec = upload("file", make([]byte, maxUploadBytes))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "too large")
+	assert.Empty(t, client.images, "oversized upload was stored")
+
+	// An upload comes back byte for byte
+	image := bytes.Repeat([]byte("0123456789"), 20_000) // several upload chunks
+	rec = upload("file", image)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var media struct{ ID string }
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &media))
+	require.NotEmpty(t, media.ID)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cars/c1/media/"+media.ID, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, bytes.Equal(image, rec.Body.Bytes()), "downloaded %d bytes, want %d", rec.Body.Len(), len(image))
+	for header, want := range map[string]string{
+		"Content-Type":  "image/png",
+		"Cache-Control": "public, max-age=31536000, immutable",
+		"X-Request-Id":  "req-1",
+	} {
+		assert.Equal(t, want, rec.Header().Get(header), header)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cars/c1/media/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "unknown media")
+}